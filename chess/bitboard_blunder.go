@@ -86,4 +86,7 @@ func InitBitboards() {
 	for sq = 0; sq < 65; sq++ {
 		SquareBB[sq] = 0x8000000000000000 >> sq
 	}
+
+	initMagics()
+	initMoveGen()
 }
@@ -94,6 +94,17 @@ func (b *Board) Flip(fd FlipDirection) *Board {
 	return NewBoard(m)
 }
 
+// FlipColors flips the board UpDown and swaps the color of every piece, so
+// the board is the same from the other side's point of view.
+func (b *Board) FlipColors() *Board {
+	m := map[Square]Piece{}
+	for sq, p := range b.SquareMap() {
+		mv := NewSquare(sq.File(), Rank(7-sq.Rank()))
+		m[mv] = NewPiece(p.Type(), p.Color().Other())
+	}
+	return NewBoard(m)
+}
+
 // Transpose flips the board over the A8 to H1 diagonal.
 func (b *Board) Transpose() *Board {
 	m := map[Square]Piece{}
@@ -220,6 +231,16 @@ func (b *Board) update(m *Move) {
 	s1BB := BBForSquare(m.s1)
 	s2BB := BBForSquare(m.s2)
 
+	// move king and rook for castle, the king takes its own rook
+	if m.isCastle() {
+		rook := pieceOf(Rook, p1.Color())
+		kingTo, rookTo := m.castleSquares()
+		b.setBBForPiece(p1, (b.BBForPiece(p1) & ^s1BB)|BBForSquare(kingTo))
+		b.setBBForPiece(rook, (b.BBForPiece(rook) & ^s2BB)|BBForSquare(rookTo))
+		b.calcConvienceBBs(m)
+		return
+	}
+
 	// move s1 piece to s2
 	for _, p := range allPieces {
 		BB := b.BBForPiece(p)
@@ -249,19 +270,64 @@ func (b *Board) update(m *Move) {
 			b.BBWhitePawn = ^(BBForSquare(m.s2) >> 8) & b.BBWhitePawn
 		}
 	}
-	// move rook for castle
-	if p1.Color() == White && m.HasTag(KingSideCastle) {
-		b.BBWhiteRook = (b.BBWhiteRook & ^BBForSquare(H1) | BBForSquare(F1))
-	} else if p1.Color() == White && m.HasTag(QueenSideCastle) {
-		b.BBWhiteRook = (b.BBWhiteRook & ^BBForSquare(A1)) | BBForSquare(D1)
-	} else if p1.Color() == Black && m.HasTag(KingSideCastle) {
-		b.BBBlackRook = (b.BBBlackRook & ^BBForSquare(H8) | BBForSquare(F8))
-	} else if p1.Color() == Black && m.HasTag(QueenSideCastle) {
-		b.BBBlackRook = (b.BBBlackRook & ^BBForSquare(A8)) | BBForSquare(D8)
-	}
 	b.calcConvienceBBs(m)
 }
 
+// Take back the move, putting back the captured piece, which is NoPiece if
+// the move wasn't a capture.
+func (b *Board) revert(m *Move, captured Piece) {
+	s1BB := BBForSquare(m.s1)
+	s2BB := BBForSquare(m.s2)
+
+	// move king and rook back from their castling squares
+	if m.isCastle() {
+		kingTo, rookTo := m.castleSquares()
+		king := WhiteKing
+		if b.BBBlackKing.Occupied(kingTo) {
+			king = BlackKing
+		}
+		rook := pieceOf(Rook, king.Color())
+		b.setBBForPiece(king, (b.BBForPiece(king) & ^BBForSquare(kingTo))|s1BB)
+		b.setBBForPiece(rook, (b.BBForPiece(rook) & ^BBForSquare(rookTo))|s2BB)
+		b.revertConvienceBBs(king, m.s1)
+		return
+	}
+
+	// move the s2 piece back to s1, as a pawn if it promoted
+	p2 := b.Piece(m.s2)
+	p1 := p2
+	if m.promo != NoPieceType {
+		p1 = pieceOf(Pawn, p2.Color())
+	}
+	b.setBBForPiece(p2, b.BBForPiece(p2) & ^s2BB)
+	b.setBBForPiece(p1, b.BBForPiece(p1)|s1BB)
+
+	// put back the captured piece, behind s2 for en passant
+	if captured != NoPiece {
+		capturedSq := m.s2
+		if m.HasTag(EnPassant) && p1.Color() == White {
+			capturedSq = m.s2 - 8
+		} else if m.HasTag(EnPassant) {
+			capturedSq = m.s2 + 8
+		}
+		b.setBBForPiece(captured, b.BBForPiece(captured)|BBForSquare(capturedSq))
+	}
+	b.revertConvienceBBs(p1, m.s1)
+}
+
+// Update the convenience bitboards after taking back a move of the piece,
+// which is back on s1.
+func (b *Board) revertConvienceBBs(p Piece, s1 Square) {
+	b.WhiteSqs = b.BBWhiteKing | b.BBWhiteQueen | b.BBWhiteRook | b.BBWhiteBishop | b.BBWhiteKnight | b.BBWhitePawn
+	b.BlackSqs = b.BBBlackKing | b.BBBlackQueen | b.BBBlackRook | b.BBBlackBishop | b.BBBlackKnight | b.BBBlackPawn
+	b.EmptySqs = ^(b.WhiteSqs | b.BlackSqs)
+	if p == WhiteKing {
+		b.WhiteKingSq = s1
+	} else if p == BlackKing {
+		b.BlackKingSq = s1
+	}
+}
+
 func (b *Board) calcConvienceBBs(m *Move) {
 	WhiteSqs := b.BBWhiteKing | b.BBWhiteQueen | b.BBWhiteRook | b.BBWhiteBishop | b.BBWhiteKnight | b.BBWhitePawn
 	BlackSqs := b.BBBlackKing | b.BBBlackQueen | b.BBBlackRook | b.BBBlackBishop | b.BBBlackKnight | b.BBBlackPawn
@@ -281,10 +347,16 @@ func (b *Board) calcConvienceBBs(m *Move) {
 				b.BlackKingSq = sqr
 			}
 		}
-	} else if m.s1 == b.WhiteKingSq {
-		b.WhiteKingSq = m.s2
-	} else if m.s1 == b.BlackKingSq {
-		b.BlackKingSq = m.s2
+	} else {
+		kingTo := m.s2
+		if m.isCastle() {
+			kingTo, _ = m.castleSquares()
+		}
+		if m.s1 == b.WhiteKingSq {
+			b.WhiteKingSq = kingTo
+		} else if m.s1 == b.BlackKingSq {
+			b.BlackKingSq = kingTo
+		}
 	}
 }
 
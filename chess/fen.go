@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"unicode"
 )
 
 // Decodes FEN notation into a GameState.  An error is returned
@@ -23,7 +24,7 @@ func decodeFEN(fen string) (*Position, error) {
 	if !ok {
 		return nil, fmt.Errorf("chess: fen invalid turn %s", parts[1])
 	}
-	rights, err := formCastleRights(parts[2])
+	rights, rooks, err := formCastleRights(parts[2], b)
 	if err != nil {
 		return nil, err
 	}
@@ -39,14 +40,19 @@ func decodeFEN(fen string) (*Position, error) {
 	if err != nil || moveCount < 1 {
 		return nil, fmt.Errorf("chess: fen invalid move count %s", parts[5])
 	}
-	return &Position{
+	pos := &Position{
 		board:           b,
 		turn:            turn,
 		castleRights:    rights,
+		castleRooks:     rooks,
 		enPassantSquare: sq,
 		halfMoveClock:   halfMoveClock,
 		moveCount:       moveCount,
-	}, nil
+	}
+	pos.inCheck = isInCheck(pos)
+	pos.key = Zobrist.GenHash(pos)
+	pos.pawnKey = Zobrist.GenPawnHash(pos.board)
+	return pos, nil
 }
 
 // generates board from fen format: rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR
@@ -93,22 +99,95 @@ func fenFormRank(rankStr string) (map[File]Piece, error) {
 	return m, nil
 }
 
-func formCastleRights(castleStr string) (CastleRights, error) {
-	// check for duplicates aka. KKkq right now is valid
-	for _, s := range []string{"K", "Q", "k", "q", "-"} {
-		if strings.Count(castleStr, s) > 1 {
-			return "-", fmt.Errorf("chess: fen invalid castle rights %s", castleStr)
-		}
+// Parses the castle rights, which can be given as KQkq, as the files of the
+// castling rooks (Shredder-FEN), or as a mix of both (X-FEN), where KQkq stand
+// for the outermost rook on that side of the king. Returns the rights and the
+// starting squares of the castling rooks.
+func formCastleRights(castleStr string, b *Board) (CastleRights, [2][2]Square, error) {
+	rooks := defaultCastleRooks
+	err := fmt.Errorf("chess: fen invalid castle rights %s", castleStr)
+	if castleStr == "-" {
+		return NoCastleRights, rooks, nil
 	}
+
+	var seen [2][2]bool
 	for _, r := range castleStr {
-		c := fmt.Sprintf("%c", r)
-		switch c {
-		case "K", "Q", "k", "q", "-":
+		c := White
+		if unicode.IsLower(r) {
+			c = Black
+		}
+		kingSq := b.kingSquare(c)
+		backRank := Rank1
+		if c == Black {
+			backRank = Rank8
+		}
+
+		var side Side
+		switch upper := unicode.ToUpper(r); {
+		case upper == 'K':
+			side = KingSide
+			rooks[c][side-1] = outerCastleRook(b, c, side)
+		case upper == 'Q':
+			side = QueenSide
+			rooks[c][side-1] = outerCastleRook(b, c, side)
+		case upper >= 'A' && upper <= 'H':
+			if kingSq == NoSquare || kingSq.Rank() != backRank {
+				return NoCastleRights, rooks, err
+			}
+			file := File(upper - 'A')
+			if file == kingSq.File() {
+				return NoCastleRights, rooks, err
+			}
+			side = QueenSide
+			if file > kingSq.File() {
+				side = KingSide
+			}
+			rooks[c][side-1] = NewSquare(file, backRank)
 		default:
-			return "-", fmt.Errorf("chess: fen invalid castle rights %s", castleStr)
+			return NoCastleRights, rooks, err
+		}
+
+		// Each side can only be given once
+		if seen[c][side-1] {
+			return NoCastleRights, rooks, err
+		}
+		seen[c][side-1] = true
+	}
+
+	rights := NoCastleRights
+	for c := range seen {
+		for side := range seen[c] {
+			if seen[c][side] {
+				rights |= castleRightBits[c][side]
+			}
+		}
+	}
+	return rights, rooks, nil
+}
+
+// Get the square of the outermost rook of the color on its back rank, on the
+// given side of its king. Falls back to the corner square if there is none.
+func outerCastleRook(b *Board, c Color, side Side) Square {
+	corner := defaultCastleRooks[c][side-1]
+	kingSq := b.kingSquare(c)
+	if kingSq == NoSquare || kingSq.Rank() != corner.Rank() {
+		return corner
+	}
+	rooks := b.BBForPiece(pieceOf(Rook, c)) & BBRanks[corner.Rank()]
+	if side == KingSide {
+		for f := FileH; f > kingSq.File(); f-- {
+			if sq := NewSquare(f, corner.Rank()); rooks&BBForSquare(sq) != 0 {
+				return sq
+			}
+		}
+	} else {
+		for f := FileA; f < kingSq.File(); f++ {
+			if sq := NewSquare(f, corner.Rank()); rooks&BBForSquare(sq) != 0 {
+				return sq
+			}
 		}
 	}
-	return CastleRights(castleStr), nil
+	return corner
 }
 
 func formEnPassant(enPassant string) (Square, error) {
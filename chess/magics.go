@@ -0,0 +1,145 @@
+package chess
+
+// magics.go contains the magic bitboard tables used to look up slider attacks.
+// For every square, the occupancy of the squares that can block a slider is
+// multiplied by a magic number, and the top bits of the product index into a
+// table of precomputed attacks. The number of index bits is the number of
+// blocker squares, so squares with fewer blockers get smaller tables.
+//
+// https://www.chessprogramming.org/Magic_Bitboards
+
+// A constant representing the value to use when seeding the random numbers
+// used to search for magic numbers.
+const MagicSeedValue = 1070372
+
+// A Magic holds everything needed to look up the attacks of a slider on one
+// square.
+type Magic struct {
+	Mask    Bitboard
+	Magic   uint64
+	Shift   uint8
+	Attacks []Bitboard
+}
+
+// Get the index into the attack table for the given occupancy.
+func (magic *Magic) index(occupied Bitboard) uint64 {
+	return (uint64(occupied&magic.Mask) * magic.Magic) >> magic.Shift
+}
+
+var RookMagics [64]Magic
+var BishopMagics [64]Magic
+
+// Magic numbers found with findMagic, so the tables can be filled without
+// searching for them on every start.
+var (
+	RookMagicNumbers = [64]uint64{17884285829282, 563504038807556, 1157988071511163138, 9851658545680901, 9223408320872976385, 577615241661268130, 9223794399655510017, 70510486487057, 2815303887110656, 18579581863724032, 7205763803986919552, 2308094844750594304, 5084141918101760, 4629735603457491584, 1205223733461504, 9259970660078725632, 1224979376157097988, 40833768327741505, 6918654936138154112, 146367537712496648, 282712195923976, 9007267976347776, 1197958053857689696, 720646311273070605, 279206428820, 5764616328455391504, 1188952502804939776, 1125934275002372, 1153062276463333380, 8080091131821432832, 2323857547326406914, 1153062517249933344, 12105957831694369793, 1162016681973842504, 1155736262964413456, 1127551322097664, 9241668601831752584, 4899989035363075328, 9024809696509952, 4611756393614049578, 4505798654854145, 5368726163001217025, 1154470167875437056, 40673684024722432, 282575025737728, 282574760976388, 5188218513866625024, 18014948267409536, 216876473330439808, 1126184180515969, 40673683957613056, 9233223695399801088, 1266671763329024, 1226245873513529728, 1196337372594241, 2594214123399036932, 324261921952417024, 288234782810706064, 144119654858752008, 144124001382375568, 648554630493504520, 900733669906419712, 306262385100857346, 144133881954582560}
+
+	BishopMagicNumbers = [64]uint64{580559327760896, 212352982135040, 576742296276502592, 774057269297680, 4611703611695825984, 74872897922991105, 11031632414736, 5783770915507552384, 2603153161011601448, 40534631121158912, 1299469980944958032, 1230665912408080408, 1153211986206000141, 9148083058245636, 144258129438179332, 288794567888609280, 580977547148787856, 866951726586008608, 9009399490151936, 72093061886280720, 9304443702368013824, 306829719813489664, 5333959614559945216, 289373885499969805, 146369324955762816, 37167961932318730, 9223943783988200513, 9281921039624151048, 565151133073538, 11530341238050390052, 11260137378415112, 290487399183548936, 9228157283262006336, 145169928225792, 3605133702157345792, 70643689259072, 2306133281089265696, 585505335557755906, 13853389113299304714, 82261079661003042, 13835621009681580608, 8444251487674432, 613899131823456897, 10453137344512393248, 144682674053726208, 1148036302508288, 292736209440015424, 18089199694021136, 5188146925651759170, 576500340225085440, 289005600703250432, 9295712275191039745, 9572488119862528, 9241404167304790080, 5198860618744004644, 4973117503393244224, 2162431650448425122, 1225287022835662880, 299239028564500, 2306974424936484864, 1197512319860736, 580965589972942866, 11908168329965142016, 2378525160350171206}
+)
+
+// Fill the attack tables for every square, searching for a new magic number
+// if a stored one doesn't work for the square.
+func initMagics() {
+	var prng PseduoRandomGenerator
+	prng.Seed(MagicSeedValue)
+
+	edges := BBFileA | BBFileH | BBRank1 | BBRank8
+
+	for sq := Square(0); sq < numOfSquaresInBoard; sq++ {
+		// Edge squares are never blockers, unless the slider is on that edge
+		pos := BBForSquare(sq)
+		rankMask := BBRanks[sq.Rank()]
+		fileMask := BBFiles[sq.File()]
+		rookMask := linearAttack(EmptyBB, pos, rankMask)&^(BBFileA|BBFileH) |
+			linearAttack(EmptyBB, pos, fileMask)&^(BBRank1|BBRank8)
+		bishopMask := linearDiaAttack(EmptyBB, sq) &^ edges
+
+		initMagic(&RookMagics[sq], rookMask, sq, RookMagicNumbers[sq], linearHvAttack, &prng)
+		initMagic(&BishopMagics[sq], bishopMask, sq, BishopMagicNumbers[sq], linearDiaAttack, &prng)
+	}
+}
+
+// Fill the attack table of the magic for the square, using the given magic
+// number if it maps every blocker occupancy of the mask without destructive
+// collisions, or a new one found with findMagic otherwise.
+func initMagic(
+	magic *Magic,
+	mask Bitboard,
+	sq Square,
+	number uint64,
+	attack func(Bitboard, Square) Bitboard,
+	prng *PseduoRandomGenerator,
+) {
+	bits := mask.CountBits()
+	size := 1 << bits
+
+	// Enumerate every subset of the mask with the Carry-Rippler trick
+	occupancies := make([]Bitboard, 0, size)
+	attacks := make([]Bitboard, 0, size)
+	subset := EmptyBB
+	for {
+		occupancies = append(occupancies, subset)
+		attacks = append(attacks, attack(subset, sq))
+		subset = (subset - mask) & mask
+		if subset == EmptyBB {
+			break
+		}
+	}
+
+	magic.Mask = mask
+	magic.Shift = uint8(64 - bits)
+	magic.Attacks = make([]Bitboard, size)
+
+	// The attempt that last wrote each entry, so the table doesn't have to be
+	// cleared between attempts
+	epoch := make([]int, size)
+
+	magic.Magic = number
+	if !fillMagic(magic, occupancies, attacks, epoch, 1) {
+		findMagic(magic, occupancies, attacks, epoch, prng)
+	}
+}
+
+// Search for a magic number that maps every blocker occupancy to an index
+// without destructive collisions.
+func findMagic(
+	magic *Magic,
+	occupancies []Bitboard,
+	attacks []Bitboard,
+	epoch []int,
+	prng *PseduoRandomGenerator,
+) {
+	for attempt := 2; ; attempt++ {
+		magic.Magic = prng.SparseRandom64()
+
+		// Magics with too few high bits set are rarely good
+		if Bitboard((uint64(magic.Mask)*magic.Magic)&0xff00000000000000).CountBits() < 6 {
+			continue
+		}
+
+		if fillMagic(magic, occupancies, attacks, epoch, attempt) {
+			return
+		}
+	}
+}
+
+// Try to fill the attack table with the current magic number. Returns false
+// if two occupancies with different attacks map to the same index.
+func fillMagic(
+	magic *Magic,
+	occupancies []Bitboard,
+	attacks []Bitboard,
+	epoch []int,
+	attempt int,
+) bool {
+	for i, occupied := range occupancies {
+		index := magic.index(occupied)
+		if epoch[index] != attempt {
+			epoch[index] = attempt
+			magic.Attacks[index] = attacks[i]
+		} else if magic.Attacks[index] != attacks[i] {
+			return false
+		}
+	}
+	return true
+}
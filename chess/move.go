@@ -19,7 +19,9 @@ const (
 	inCheck
 )
 
-// A Move is the movement of a piece from one square to another.
+// A Move is the movement of a piece from one square to another. Castles are
+// stored as the king taking its own rook, so that the king and rook can start
+// on any file as in Chess960.
 type Move struct {
 	s1    Square
 	s2    Square
@@ -27,10 +29,22 @@ type Move struct {
 	tags  MoveTag
 }
 
+// NewMove returns the move of the piece on s1 to s2, promoting to promo. The
+// move has no tags, so it should be checked with PseudoLegal before being
+// played.
+func NewMove(s1, s2 Square, promo PieceType) Move {
+	return Move{s1: s1, s2: s2, promo: promo}
+}
+
 // String returns a string useful for debugging.  String doesn't return
-// algebraic notation.
+// algebraic notation.  Castles are written as the king's move, use
+// UCINotation with Chess960 set to write them as the king taking its rook.
 func (m *Move) String() string {
-	return m.s1.String() + m.s2.String() + m.promo.String()
+	s2 := m.s2
+	if m.isCastle() {
+		s2, _ = m.castleSquares()
+	}
+	return m.s1.String() + s2.String() + m.promo.String()
 }
 
 // S1 returns the origin square of the move.
@@ -57,6 +71,20 @@ func (m *Move) addTag(tag MoveTag) {
 	m.tags = m.tags | tag
 }
 
+func (m *Move) isCastle() bool {
+	return m.HasTag(KingSideCastle | QueenSideCastle)
+}
+
+// Get the squares the king and rook end up on after castling, which are the
+// same as in standard chess wherever they started.
+func (m *Move) castleSquares() (kingTo Square, rookTo Square) {
+	rank := m.s1.Rank()
+	if m.HasTag(KingSideCastle) {
+		return NewSquare(FileG, rank), NewSquare(FileF, rank)
+	}
+	return NewSquare(FileC, rank), NewSquare(FileD, rank)
+}
+
 type moveSlice []*Move
 
 func (a moveSlice) find(m *Move) *Move {
@@ -90,7 +90,7 @@ func standardMoves(pos *Position, first bool) []*Move {
 
 func addTags(m *Move, pos *Position) {
 	p := pos.board.Piece(m.s1)
-	if pos.board.isOccupied(m.s2) {
+	if pos.board.isOccupied(m.s2) && !m.isCastle() {
 		m.addTag(Capture)
 	} else if m.s2 == pos.enPassantSquare && p.Type() == Pawn {
 		m.addTag(EnPassant)
@@ -200,50 +200,18 @@ func BBForPossibleMoves(pos *Position, pt PieceType, sq Square) Bitboard {
 	return Bitboard(0)
 }
 
-// TODO can calc isInCheck twice
 func castleMoves(pos *Position) []*Move {
 	moves := []*Move{}
-	kingSide := pos.castleRights.CanCastle(pos.Turn(), KingSide)
-	queenSide := pos.castleRights.CanCastle(pos.Turn(), QueenSide)
-	// white king side
-	if pos.turn == White && kingSide &&
-		(^pos.board.EmptySqs&(BBForSquare(F1)|BBForSquare(G1))) == 0 &&
-		!squaresAreAttacked(pos, F1, G1) &&
-		!pos.inCheck {
-		m := &Move{s1: E1, s2: G1}
-		m.addTag(KingSideCastle)
-		addTags(m, pos)
-		moves = append(moves, m)
-	}
-	// white queen side
-	if pos.turn == White && queenSide &&
-		(^pos.board.EmptySqs&(BBForSquare(B1)|BBForSquare(C1)|BBForSquare(D1))) == 0 &&
-		!squaresAreAttacked(pos, C1, D1) &&
-		!pos.inCheck {
-		m := &Move{s1: E1, s2: C1}
-		m.addTag(QueenSideCastle)
-		addTags(m, pos)
-		moves = append(moves, m)
-	}
-	// black king side
-	if pos.turn == Black && kingSide &&
-		(^pos.board.EmptySqs&(BBForSquare(F8)|BBForSquare(G8))) == 0 &&
-		!squaresAreAttacked(pos, F8, G8) &&
-		!pos.inCheck {
-		m := &Move{s1: E8, s2: G8}
-		m.addTag(KingSideCastle)
-		addTags(m, pos)
-		moves = append(moves, m)
-	}
-	// black queen side
-	if pos.turn == Black && queenSide &&
-		(^pos.board.EmptySqs&(BBForSquare(B8)|BBForSquare(C8)|BBForSquare(D8))) == 0 &&
-		!squaresAreAttacked(pos, C8, D8) &&
-		!pos.inCheck {
-		m := &Move{s1: E8, s2: C8}
-		m.addTag(QueenSideCastle)
+	for _, side := range []Side{KingSide, QueenSide} {
+		castle, ok := pos.canCastle(side)
+		if !ok {
+			continue
+		}
+		m := &castle
 		addTags(m, pos)
-		moves = append(moves, m)
+		if !m.HasTag(inCheck) {
+			moves = append(moves, m)
+		}
 	}
 	return moves
 }
@@ -284,14 +252,27 @@ func PawnAttacks(pos *Position, sq Square) Bitboard {
 	return capRight | capLeft
 }
 
+// Get the squares attacked by a bishop on the square.
 func DiaAttack(occupied Bitboard, sq Square) Bitboard {
+	magic := &BishopMagics[sq]
+	return magic.Attacks[magic.index(occupied)]
+}
+
+// Get the squares attacked by a rook on the square.
+func HvAttack(occupied Bitboard, sq Square) Bitboard {
+	magic := &RookMagics[sq]
+	return magic.Attacks[magic.index(occupied)]
+}
+
+// Slider attacks computed ray by ray, used to fill the magic bitboard tables.
+func linearDiaAttack(occupied Bitboard, sq Square) Bitboard {
 	pos := BBForSquare(sq)
 	dMask := BBDiagonals[sq]
 	adMask := BBAntiDiagonals[sq]
 	return linearAttack(occupied, pos, dMask) | linearAttack(occupied, pos, adMask)
 }
 
-func HvAttack(occupied Bitboard, sq Square) Bitboard {
+func linearHvAttack(occupied Bitboard, sq Square) Bitboard {
 	pos := BBForSquare(sq)
 	rankMask := BBRanks[Square(sq).Rank()]
 	fileMask := BBFiles[Square(sq).File()]
@@ -0,0 +1,373 @@
+package chess
+
+// movegen.go contains a pseudo-legal move generator for engines. Unlike
+// ValidMoves, it doesn't check whether a move leaves the king in check or
+// gives check, and it writes moves into a buffer supplied by the caller
+// instead of allocating them. Captures and quiet moves are generated in
+// separate stages, so a search can try the captures before it pays for
+// generating the quiet moves. Moves are tagged with Capture, EnPassant and
+// the castle tags, but never with Check.
+//
+// A move is legal if the king of the side that made it isn't attacked after
+// it's made, which can be tested with KingAttacked.
+
+// The number of moves a buffer needs to be able to hold every pseudo-legal
+// move of any position.
+const MaxMoves = 256
+
+// The squares strictly between two squares on the same rank, file or
+// diagonal, and the squares attacked by a pawn of each color.
+var (
+	BBBetween     [64][64]Bitboard
+	BBPawnAttacks [2][64]Bitboard
+)
+
+// Fill the tables used by the move generator.
+func initMoveGen() {
+	for sq := Square(0); sq < numOfSquaresInBoard; sq++ {
+		bb := BBForSquare(sq)
+		BBPawnAttacks[White][sq] = ((bb & ^BBFileH & ^BBRank8) >> 9) | ((bb & ^BBFileA & ^BBRank8) >> 7)
+		BBPawnAttacks[Black][sq] = ((bb & ^BBFileH & ^BBRank1) << 7) | ((bb & ^BBFileA & ^BBRank1) << 9)
+	}
+
+	for s1 := Square(0); s1 < numOfSquaresInBoard; s1++ {
+		for s2 := Square(0); s2 < numOfSquaresInBoard; s2++ {
+			occupied := BBForSquare(s1) | BBForSquare(s2)
+			if HvAttack(EmptyBB, s1)&BBForSquare(s2) != 0 {
+				BBBetween[s1][s2] = HvAttack(occupied, s1) & HvAttack(occupied, s2)
+			} else if DiaAttack(EmptyBB, s1)&BBForSquare(s2) != 0 {
+				BBBetween[s1][s2] = DiaAttack(occupied, s1) & DiaAttack(occupied, s2)
+			}
+		}
+	}
+}
+
+// Get the piece of the given type and color.
+func pieceOf(pt PieceType, c Color) Piece {
+	return Piece(int(c)*6 + int(pt))
+}
+
+// Check if the square is attacked by any piece of the given color.
+func (b *Board) attackedBy(sq Square, c Color) bool {
+	occupied := ^b.EmptySqs
+	queens := b.BBForPiece(pieceOf(Queen, c))
+	return BBPawnAttacks[c.Other()][sq]&b.BBForPiece(pieceOf(Pawn, c)) != 0 ||
+		BBKnightMoves[sq]&b.BBForPiece(pieceOf(Knight, c)) != 0 ||
+		BBKingMoves[sq]&b.BBForPiece(pieceOf(King, c)) != 0 ||
+		DiaAttack(occupied, sq)&(b.BBForPiece(pieceOf(Bishop, c))|queens) != 0 ||
+		HvAttack(occupied, sq)&(b.BBForPiece(pieceOf(Rook, c))|queens) != 0
+}
+
+// Get every piece of the given color attacking the square.
+func (b *Board) attackersOf(sq Square, c Color) Bitboard {
+	occupied := ^b.EmptySqs
+	queens := b.BBForPiece(pieceOf(Queen, c))
+	return (BBPawnAttacks[c.Other()][sq] & b.BBForPiece(pieceOf(Pawn, c))) |
+		(BBKnightMoves[sq] & b.BBForPiece(pieceOf(Knight, c))) |
+		(BBKingMoves[sq] & b.BBForPiece(pieceOf(King, c))) |
+		(DiaAttack(occupied, sq) & (b.BBForPiece(pieceOf(Bishop, c)) | queens)) |
+		(HvAttack(occupied, sq) & (b.BBForPiece(pieceOf(Rook, c)) | queens))
+}
+
+func (b *Board) kingSquare(c Color) Square {
+	if c == White {
+		return b.WhiteKingSq
+	}
+	return b.BlackKingSq
+}
+
+func (b *Board) colorSqs(c Color) Bitboard {
+	if c == White {
+		return b.WhiteSqs
+	}
+	return b.BlackSqs
+}
+
+// KingAttacked returns true if the king of the given color is attacked. After
+// making a pseudo-legal move, the move was illegal if the king of the side
+// that made it is attacked.
+func (pos *Position) KingAttacked(c Color) bool {
+	kingSq := pos.board.kingSquare(c)
+	// king should only be missing in tests / examples
+	if kingSq == NoSquare {
+		return false
+	}
+	return pos.board.attackedBy(kingSq, c.Other())
+}
+
+// GenerateCaptures appends every capture, en passant capture and promotion of
+// the side to move to the buffer and returns it.
+func (pos *Position) GenerateCaptures(moves []Move) []Move {
+	enemies := pos.board.colorSqs(pos.turn.Other())
+	moves = pos.genPawnCaptures(moves, enemies, true)
+	moves = pos.genPawnPushes(moves, pos.promoRank())
+	moves = pos.genPieceMoves(moves, enemies)
+	return pos.genKingMoves(moves, enemies)
+}
+
+// GenerateQuiets appends every move of the side to move that isn't generated
+// by GenerateCaptures, including castling, to the buffer and returns it.
+func (pos *Position) GenerateQuiets(moves []Move) []Move {
+	moves = pos.genPawnPushes(moves, ^pos.promoRank())
+	moves = pos.genPieceMoves(moves, pos.board.EmptySqs)
+	moves = pos.genKingMoves(moves, pos.board.EmptySqs)
+	return pos.genCastles(moves)
+}
+
+// GenerateEvasions appends the moves of the side to move that can get its
+// king out of check to the buffer and returns it. Only king moves are
+// generated in double check, otherwise the other pieces can only capture
+// the checker or block it.
+func (pos *Position) GenerateEvasions(moves []Move) []Move {
+	kingSq := pos.board.kingSquare(pos.turn)
+	checkers := pos.board.attackersOf(kingSq, pos.turn.Other())
+
+	moves = pos.genKingMoves(moves, ^pos.board.colorSqs(pos.turn))
+
+	if checkers.CountBits() > 1 {
+		return moves
+	}
+
+	checkerSq := Square(checkers.Msb())
+	blocks := BBBetween[kingSq][checkerSq]
+
+	moves = pos.genPawnCaptures(moves, checkers, true)
+	moves = pos.genPawnPushes(moves, blocks)
+	return pos.genPieceMoves(moves, checkers|blocks)
+}
+
+// GenerateMoves appends every pseudo-legal move of the side to move to the
+// buffer and returns it.
+func (pos *Position) GenerateMoves(moves []Move) []Move {
+	if pos.KingAttacked(pos.turn) {
+		return pos.GenerateEvasions(moves)
+	}
+	moves = pos.GenerateCaptures(moves)
+	return pos.GenerateQuiets(moves)
+}
+
+// PseudoLegal checks if the move, usually taken from a transposition table,
+// can be played in the position as if it came from the move generator, and
+// returns it with the tags the move generator would have given it.
+func (pos *Position) PseudoLegal(m Move) (Move, bool) {
+	p := pos.board.Piece(m.s1)
+	if p == NoPiece || p.Color() != pos.turn || m.s1 == m.s2 {
+		return Move{}, false
+	}
+
+	// Castles are the only moves onto a square of the same color
+	target := BBForSquare(m.s2)
+	if p.Type() == King && pos.board.Piece(m.s2) == pieceOf(Rook, pos.turn) {
+		for _, side := range []Side{KingSide, QueenSide} {
+			if castle, ok := pos.canCastle(side); ok && castle.s2 == m.s2 {
+				return castle, true
+			}
+		}
+		return Move{}, false
+	}
+	if target&pos.board.colorSqs(pos.turn) != 0 {
+		return Move{}, false
+	}
+
+	move := Move{s1: m.s1, s2: m.s2, promo: m.promo}
+	if target&pos.board.colorSqs(pos.turn.Other()) != 0 {
+		move.addTag(Capture)
+	}
+
+	if p.Type() != Pawn && m.promo != NoPieceType {
+		return Move{}, false
+	}
+
+	occupied := ^pos.board.EmptySqs
+	switch p.Type() {
+	case King:
+		return move, BBKingMoves[m.s1]&target != 0
+	case Queen:
+		return move, (DiaAttack(occupied, m.s1)|HvAttack(occupied, m.s1))&target != 0
+	case Rook:
+		return move, HvAttack(occupied, m.s1)&target != 0
+	case Bishop:
+		return move, DiaAttack(occupied, m.s1)&target != 0
+	case Knight:
+		return move, BBKnightMoves[m.s1]&target != 0
+	}
+
+	// Pawns must promote to a piece exactly when they reach the last rank
+	if (target&pos.promoRank() != 0) != (m.promo != NoPieceType) ||
+		m.promo == King || m.promo == Pawn {
+		return Move{}, false
+	}
+
+	if move.HasTag(Capture) {
+		return move, BBPawnAttacks[pos.turn][m.s1]&target != 0
+	}
+
+	if m.s2 == pos.enPassantSquare && BBPawnAttacks[pos.turn][m.s1]&target != 0 {
+		move.addTag(EnPassant)
+		return move, true
+	}
+
+	if pos.pawnPushes(BBForSquare(m.s1))&target != 0 {
+		return move, true
+	}
+	return Move{}, false
+}
+
+// The rank the pawns of the side to move promote on.
+func (pos *Position) promoRank() Bitboard {
+	if pos.turn == White {
+		return BBRank8
+	}
+	return BBRank1
+}
+
+// Get the squares the pawns can be pushed to, one or two squares forward.
+func (pos *Position) pawnPushes(pawns Bitboard) Bitboard {
+	empty := pos.board.EmptySqs
+	if pos.turn == White {
+		upOne := (pawns >> 8) & empty
+		upTwo := ((upOne & BBRank3) >> 8) & empty
+		return upOne | upTwo
+	}
+	upOne := (pawns << 8) & empty
+	upTwo := ((upOne & BBRank6) << 8) & empty
+	return upOne | upTwo
+}
+
+// Append a move of the piece on the square to each of the targets.
+func (pos *Position) appendMoves(moves []Move, s1 Square, targets Bitboard) []Move {
+	enemies := pos.board.colorSqs(pos.turn.Other())
+	for targets != 0 {
+		s2 := Square(targets.PopBit())
+		m := Move{s1: s1, s2: s2}
+		if enemies&BBForSquare(s2) != 0 {
+			m.addTag(Capture)
+		}
+		moves = append(moves, m)
+	}
+	return moves
+}
+
+// Append a move, or all four promotions if the pawn reaches the last rank.
+func (pos *Position) appendPawnMove(moves []Move, m Move) []Move {
+	if BBForSquare(m.s2)&pos.promoRank() == 0 {
+		return append(moves, m)
+	}
+	for _, pt := range promoPieceTypes {
+		m.promo = pt
+		moves = append(moves, m)
+	}
+	return moves
+}
+
+// Generate the king moves to the targets, not including castling.
+func (pos *Position) genKingMoves(moves []Move, targets Bitboard) []Move {
+	kingSq := pos.board.kingSquare(pos.turn)
+	if kingSq == NoSquare {
+		return moves
+	}
+	return pos.appendMoves(moves, kingSq, BBKingMoves[kingSq]&targets)
+}
+
+// Generate the moves of the queens, rooks, bishops and knights to the
+// targets.
+func (pos *Position) genPieceMoves(moves []Move, targets Bitboard) []Move {
+	occupied := ^pos.board.EmptySqs
+	for pt := Queen; pt <= Knight; pt++ {
+		pieces := pos.board.BBForPiece(pieceOf(pt, pos.turn))
+		for pieces != 0 {
+			s1 := Square(pieces.PopBit())
+			var attacks Bitboard
+			switch pt {
+			case Queen:
+				attacks = DiaAttack(occupied, s1) | HvAttack(occupied, s1)
+			case Rook:
+				attacks = HvAttack(occupied, s1)
+			case Bishop:
+				attacks = DiaAttack(occupied, s1)
+			case Knight:
+				attacks = BBKnightMoves[s1]
+			}
+			moves = pos.appendMoves(moves, s1, attacks&targets)
+		}
+	}
+	return moves
+}
+
+// Generate the pawn captures of the targets, and en passant if asked for.
+func (pos *Position) genPawnCaptures(moves []Move, targets Bitboard, enPassant bool) []Move {
+	pawns := pos.board.BBForPiece(pieceOf(Pawn, pos.turn))
+	for pawns != 0 {
+		s1 := Square(pawns.PopBit())
+		attacks := BBPawnAttacks[pos.turn][s1]
+		captures := attacks & targets
+		for captures != 0 {
+			s2 := Square(captures.PopBit())
+			moves = pos.appendPawnMove(moves, Move{s1: s1, s2: s2, tags: Capture})
+		}
+		if enPassant && pos.enPassantSquare != NoSquare &&
+			attacks&BBForSquare(pos.enPassantSquare) != 0 {
+			moves = append(moves, Move{s1: s1, s2: pos.enPassantSquare, tags: EnPassant})
+		}
+	}
+	return moves
+}
+
+// Generate the pawn pushes to the targets.
+func (pos *Position) genPawnPushes(moves []Move, targets Bitboard) []Move {
+	pawns := pos.board.BBForPiece(pieceOf(Pawn, pos.turn))
+	for pawns != 0 {
+		s1 := Square(pawns.PopBit())
+		pushes := pos.pawnPushes(BBForSquare(s1)) & targets
+		for pushes != 0 {
+			s2 := Square(pushes.PopBit())
+			moves = pos.appendPawnMove(moves, Move{s1: s1, s2: s2})
+		}
+	}
+	return moves
+}
+
+// Generate the castles of the side to move.
+func (pos *Position) genCastles(moves []Move) []Move {
+	for _, side := range []Side{KingSide, QueenSide} {
+		if castle, ok := pos.canCastle(side); ok {
+			moves = append(moves, castle)
+		}
+	}
+	return moves
+}
+
+// Check if the side to move can castle on the given side, and return the
+// castle as the king taking its own rook. Every square the king and rook
+// cross must be empty apart from the two of them, and the king can't castle
+// out of or through check. Whether it lands in check is left to the legality
+// test like for every other move.
+func (pos *Position) canCastle(side Side) (Move, bool) {
+	if !pos.castleRights.CanCastle(pos.turn, side) {
+		return Move{}, false
+	}
+
+	kingSq := pos.board.kingSquare(pos.turn)
+	rookSq := pos.castleRook(pos.turn, side)
+	if kingSq.Rank() != rookSq.Rank() || pos.board.Piece(rookSq) != pieceOf(Rook, pos.turn) {
+		return Move{}, false
+	}
+
+	castle := Move{s1: kingSq, s2: rookSq, tags: castleTags[side]}
+	kingTo, rookTo := castle.castleSquares()
+	kingPath := BBBetween[kingSq][kingTo] | BBForSquare(kingTo)
+	path := kingPath | BBBetween[rookSq][rookTo] | BBForSquare(rookTo)
+	path &^= BBForSquare(kingSq) | BBForSquare(rookSq)
+	if path&^pos.board.EmptySqs != 0 {
+		return Move{}, false
+	}
+
+	them := pos.turn.Other()
+	crossed := BBBetween[kingSq][kingTo] | BBForSquare(kingSq)
+	for crossed != 0 {
+		if pos.board.attackedBy(Square(crossed.PopBit()), them) {
+			return Move{}, false
+		}
+	}
+	return castle, true
+}
@@ -31,7 +31,12 @@ type Notation interface {
 // UCINotation is a more computer friendly alternative to algebraic
 // notation.  This notation uses the same format as the UCI (Universal Chess
 // Interface).  Examples: e2e4, e7e5, e1g1 (white short castling), e7e8q (for promotion)
-type UCINotation struct{}
+type UCINotation struct {
+	// Chess960 makes castles encode as the king taking its own rook, e1h1
+	// instead of e1g1, which is how castles are sent when the UCI_Chess960
+	// option is on.  Both forms are always decoded.
+	Chess960 bool
+}
 
 // String implements the fmt.Stringer interface and returns
 // the notation's name.
@@ -40,8 +45,11 @@ func (UCINotation) String() string {
 }
 
 // Encode implements the Encoder interface.
-func (UCINotation) Encode(pos *Position, m *Move) string {
-	return m.S1().String() + m.S2().String() + m.Promo().String()
+func (n UCINotation) Encode(pos *Position, m *Move) string {
+	if n.Chess960 && m.isCastle() {
+		return m.s1.String() + m.s2.String()
+	}
+	return m.String()
 }
 
 // Decode implements the Decoder interface.
@@ -72,10 +80,19 @@ func (UCINotation) Decode(pos *Position, s string) (*Move, error) {
 	}
 	p := pos.Board().Piece(s1)
 	if p.Type() == King {
-		if (s1 == E1 && s2 == G1) || (s1 == E8 && s2 == G8) {
-			m.addTag(KingSideCastle)
-		} else if (s1 == E1 && s2 == C1) || (s1 == E8 && s2 == C8) {
-			m.addTag(QueenSideCastle)
+		for _, side := range []Side{KingSide, QueenSide} {
+			if !pos.castleRights.CanCastle(p.Color(), side) {
+				continue
+			}
+			// The king either takes its own rook, or moves to where it lands
+			// in a way no other king move could, more than one file or not
+			// at all
+			castle := &Move{s1: s1, s2: pos.castleRook(p.Color(), side), tags: castleTags[side]}
+			kingTo, _ := castle.castleSquares()
+			fileDiff := int(s2.File()) - int(s1.File())
+			if s2 == castle.s2 || (s2 == kingTo && (fileDiff > 1 || fileDiff < -1 || s1 == s2)) {
+				return castle, nil
+			}
 		}
 	} else if p.Type() == Pawn && s2 == pos.enPassantSquare {
 		m.addTag(EnPassant)
@@ -0,0 +1,35 @@
+package chess
+
+import "testing"
+
+func TestUCINotationCastles(t *testing.T) {
+	tests := []struct {
+		name     string
+		fen      string
+		move     string
+		standard string
+		chess960 string
+	}{
+		{"White king side", "r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1", "e1g1", "e1g1", "e1h1"},
+		{"Black queen side", "r3k2r/8/8/8/8/8/8/R3K2R b KQkq - 0 1", "e8a8", "e8c8", "e8a8"},
+		{"Chess960 king on its square", "1r4kr/8/8/8/8/8/8/1R4KR w HBhb - 0 1", "g1h1", "g1g1", "g1h1"},
+		{"Not a castle", "r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1", "e1f1", "e1f1", "e1f1"},
+	}
+
+	// Both forms decode to the same move, which encodes in the form of the
+	// notation
+	for _, test := range tests {
+		pos := zobristPosition(t, test.fen)
+		move, err := UCINotation{}.Decode(pos, test.move)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if s := (UCINotation{}).Encode(pos, move); s != test.standard || move.String() != test.standard {
+			t.Errorf("%s: encoded %s as %s, expected %s", test.name, test.move, s, test.standard)
+		}
+		if s := (UCINotation{Chess960: true}).Encode(pos, move); s != test.chess960 {
+			t.Errorf("%s: encoded %s as %s with Chess960, expected %s", test.name, test.move, s, test.chess960)
+		}
+	}
+}
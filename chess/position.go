@@ -19,41 +19,87 @@ const (
 	QueenSide
 )
 
-// CastleRights holds the state of both sides castling abilities.
-type CastleRights string
+// CastleRights holds the state of both sides castling abilities as a
+// bitmask, with the bits of the zobrist castling numbers.
+type CastleRights uint8
+
+// NoCastleRights is the castle rights of a position where neither side can
+// castle.
+const NoCastleRights CastleRights = 0
+
+// The bit of each castle right, indexed by color and side.
+var castleRightBits = [2][2]CastleRights{
+	{CastleRights(WhiteKingsideRight), CastleRights(WhiteQueensideRight)},
+	{CastleRights(BlackKingsideRight), CastleRights(BlackQueensideRight)},
+}
+
+// The FEN character of each castle right, indexed by color and side.
+var castleRightChars = [2][2]string{{"K", "Q"}, {"k", "q"}}
 
 // CanCastle returns true if the given color and side combination
 // can castle, otherwise returns false.
 func (cr CastleRights) CanCastle(c Color, side Side) bool {
-	char := "k"
-	if side == QueenSide {
-		char = "q"
-	}
-	if c == White {
-		char = strings.ToUpper(char)
-	}
-	return strings.Contains(string(cr), char)
+	return cr&castleRightBits[c][side-1] != 0
 }
 
 // String implements the fmt.Stringer interface and returns
 // a FEN compatible string.  Ex. KQq
 func (cr CastleRights) String() string {
-	return string(cr)
+	s := ""
+	for c := White; c <= Black; c++ {
+		for side := KingSide; side <= QueenSide; side++ {
+			if cr.CanCastle(c, side) {
+				s += castleRightChars[c][side-1]
+			}
+		}
+	}
+	if s == "" {
+		return "-"
+	}
+	return s
 }
 
+// The starting squares of the castling rooks in standard chess, indexed by
+// color and side.
+var defaultCastleRooks = [2][2]Square{{H1, A1}, {H8, A8}}
+
+// The castle tag for each side, indexed by side.
+var castleTags = [3]MoveTag{0, KingSideCastle, QueenSideCastle}
+
 // Position represents the state of the game without reguard
 // to its outcome.  Position is translatable to FEN notation.
 type Position struct {
 	board           *Board
 	turn            Color
 	castleRights    CastleRights
+	castleRooks     [2][2]Square
 	enPassantSquare Square
 	halfMoveClock   int
 	moveCount       int
 	inCheck         bool
 	validMoves      []*Move
+	key             uint64
+	pawnKey         uint64
+	undoStack       []undoState
+}
+
+// The state of a position before a move was made in place that can't be
+// worked out from the position after it, which is all that is needed to
+// unmake the move.
+type undoState struct {
+	move            Move
+	captured        Piece
+	castleRights    CastleRights
+	enPassantSquare Square
+	halfMoveClock   int
+	key             uint64
+	pawnKey         uint64
 }
 
+// The number of moves the undo stack of a copied position can hold before it
+// has to grow.
+const undoStackSize = 256
+
 const (
 	startFEN = "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1"
 )
@@ -82,17 +128,171 @@ func (pos *Position) Update(m *Move) *Position {
 	} else {
 		halfMove++
 	}
+	epSq := pos.updateEnPassantSquare(m)
+	key := Zobrist.updateKey(pos, m, ncr, epSq)
+	pawnKey := Zobrist.updatePawnKey(pos, m)
 	b := pos.board.copy()
 	b.update(m)
-	return &Position{
+	newPos := &Position{
 		board:           b,
 		turn:            pos.turn.Other(),
 		castleRights:    ncr,
-		enPassantSquare: pos.updateEnPassantSquare(m),
+		castleRooks:     pos.castleRooks,
+		enPassantSquare: epSq,
 		halfMoveClock:   halfMove,
 		moveCount:       moveCount,
-		inCheck:         m.HasTag(Check),
+		key:             key,
+		pawnKey:         pawnKey,
+	}
+	// moves from the pseudo-legal generator aren't tagged with Check
+	newPos.inCheck = newPos.KingAttacked(newPos.turn)
+	return newPos
+}
+
+// Copy returns a copy of the position which can be searched with MakeMove and
+// UnmakeMove without changing the original position.
+func (pos *Position) Copy() *Position {
+	cp := pos.copy()
+	cp.validMoves = pos.validMoves
+	cp.undoStack = make([]undoState, 0, undoStackSize)
+	return cp
+}
+
+// FlipColors returns the position with the colors swapped: the board is
+// flipped UpDown with every piece changing color, and so do the side to
+// move, the castle rights and the en passant square. Evaluations of the
+// flipped position from the side to move should match the original's.
+func (pos *Position) FlipColors() *Position {
+	flip := func(sq Square) Square {
+		if sq == NoSquare {
+			return NoSquare
+		}
+		return NewSquare(sq.File(), Rank(7-sq.Rank()))
+	}
+
+	rights := NoCastleRights
+	for c := White; c <= Black; c++ {
+		for side := KingSide; side <= QueenSide; side++ {
+			if pos.castleRights.CanCastle(c.Other(), side) {
+				rights |= castleRightBits[c][side-1]
+			}
+		}
+	}
+
+	var rooks [2][2]Square
+	for c := range rooks {
+		for side := range rooks[c] {
+			rooks[c][side] = flip(pos.castleRooks[1-c][side])
+		}
+	}
+
+	cp := &Position{
+		board:           pos.board.FlipColors(),
+		turn:            pos.turn.Other(),
+		castleRights:    rights,
+		castleRooks:     rooks,
+		enPassantSquare: flip(pos.enPassantSquare),
+		halfMoveClock:   pos.halfMoveClock,
+		moveCount:       pos.moveCount,
+		undoStack:       make([]undoState, 0, undoStackSize),
+	}
+	cp.key = Zobrist.GenHash(cp)
+	cp.pawnKey = Zobrist.GenPawnHash(cp.board)
+	cp.inCheck = cp.KingAttacked(cp.turn)
+	return cp
+}
+
+// MakeMove plays the given move in place. Like Update, the move isn't
+// validated. The move can be taken back with UnmakeMove.
+func (pos *Position) MakeMove(m *Move) {
+	captured := NoPiece
+	if m.HasTag(EnPassant) {
+		captured = pieceOf(Pawn, pos.turn.Other())
+	} else if !m.isCastle() {
+		captured = pos.board.Piece(m.s2)
+	}
+	pos.pushUndoState(*m, captured)
+
+	if pos.turn == Black {
+		pos.moveCount++
+	}
+	p := pos.board.Piece(m.s1)
+	if p.Type() == Pawn || m.HasTag(Capture) {
+		pos.halfMoveClock = 0
+	} else {
+		pos.halfMoveClock++
+	}
+	ncr := pos.updateCastleRights(m)
+	epSq := pos.updateEnPassantSquare(m)
+	pos.key = Zobrist.updateKey(pos, m, ncr, epSq)
+	pos.pawnKey = Zobrist.updatePawnKey(pos, m)
+	pos.board.update(m)
+	pos.turn = pos.turn.Other()
+	pos.castleRights = ncr
+	pos.enPassantSquare = epSq
+	pos.inCheck = pos.KingAttacked(pos.turn)
+	pos.validMoves = nil
+}
+
+// UnmakeMove takes back the last move made with MakeMove.
+func (pos *Position) UnmakeMove() {
+	undo := pos.popUndoState()
+	pos.board.revert(&undo.move, undo.captured)
+	pos.inCheck = pos.KingAttacked(pos.turn)
+}
+
+// MakeNullMove passes the turn to the other side in place. The null move can
+// be taken back with UnmakeNullMove.
+func (pos *Position) MakeNullMove() {
+	pos.pushUndoState(Move{}, NoPiece)
+
+	pos.key ^= Zobrist.SideToMoveNumber()
+	if pos.enPassantSquare != NoSquare {
+		pos.key ^= Zobrist.EPNumber(uint8(pos.enPassantSquare))
 	}
+	if pos.turn == Black {
+		pos.moveCount++
+	}
+	pos.turn = pos.turn.Other()
+	pos.enPassantSquare = NoSquare
+	pos.validMoves = nil
+}
+
+// UnmakeNullMove takes back the last null move made with MakeNullMove.
+func (pos *Position) UnmakeNullMove() {
+	pos.popUndoState()
+	pos.inCheck = pos.KingAttacked(pos.turn)
+}
+
+func (pos *Position) pushUndoState(m Move, captured Piece) {
+	pos.undoStack = append(pos.undoStack, undoState{
+		move:            m,
+		captured:        captured,
+		castleRights:    pos.castleRights,
+		enPassantSquare: pos.enPassantSquare,
+		halfMoveClock:   pos.halfMoveClock,
+		key:             pos.key,
+		pawnKey:         pos.pawnKey,
+	})
+}
+
+// Restore the state saved before the last move and pass the turn back. The
+// board is left for the caller to take back.
+func (pos *Position) popUndoState() *undoState {
+	undo := &pos.undoStack[len(pos.undoStack)-1]
+	pos.undoStack = pos.undoStack[:len(pos.undoStack)-1]
+
+	pos.turn = pos.turn.Other()
+	if pos.turn == Black {
+		pos.moveCount--
+	}
+	pos.castleRights = undo.castleRights
+	pos.enPassantSquare = undo.enPassantSquare
+	pos.halfMoveClock = undo.halfMoveClock
+	pos.key = undo.key
+	pos.pawnKey = undo.pawnKey
+	pos.validMoves = nil
+	return undo
 }
 
 // ValidMoves returns a list of valid moves for the position.
@@ -135,6 +335,16 @@ func (pos *Position) CastleRights() CastleRights {
 	return pos.castleRights
 }
 
+// Key returns the zobrist key of the position.
+func (pos *Position) Key() uint64 {
+	return pos.key
+}
+
+// PawnKey returns the zobrist key of the pawns of the position.
+func (pos *Position) PawnKey() uint64 {
+	return pos.pawnKey
+}
+
 // Returns move count for the position
 func (pos *Position) MoveCount() int {
 	return pos.moveCount
@@ -150,7 +360,7 @@ func (pos *Position) InCheck() bool {
 func (pos *Position) String() string {
 	b := pos.board.String()
 	t := pos.turn.String()
-	c := pos.castleRights.String()
+	c := pos.castleFEN()
 	sq := "-"
 	if pos.enPassantSquare != NoSquare {
 		sq = pos.enPassantSquare.String()
@@ -179,11 +389,14 @@ func (pos *Position) UnmarshalText(text []byte) error {
 	}
 	pos.board = cp.board
 	pos.castleRights = cp.castleRights
+	pos.castleRooks = cp.castleRooks
 	pos.turn = cp.turn
 	pos.enPassantSquare = cp.enPassantSquare
 	pos.halfMoveClock = cp.halfMoveClock
 	pos.moveCount = cp.moveCount
 	pos.inCheck = isInCheck(cp)
+	pos.key = cp.key
+	pos.pawnKey = cp.pawnKey
 	return nil
 }
 
@@ -265,22 +478,24 @@ func (pos *Position) UnmarshalBinary(data []byte) error {
 	if err := binary.Read(buf, binary.BigEndian, &b); err != nil {
 		return err
 	}
-	pos.castleRights = ""
+	pos.castleRights = NoCastleRights
 	pos.turn = White
 	if b&bitsCastleWhiteKing != 0 {
-		pos.castleRights += "K"
+		pos.castleRights |= castleRightBits[White][KingSide-1]
 	}
 	if b&bitsCastleWhiteQueen != 0 {
-		pos.castleRights += "Q"
+		pos.castleRights |= castleRightBits[White][QueenSide-1]
 	}
 	if b&bitsCastleBlackKing != 0 {
-		pos.castleRights += "k"
+		pos.castleRights |= castleRightBits[Black][KingSide-1]
 	}
 	if b&bitsCastleBlackQueen != 0 {
-		pos.castleRights += "q"
+		pos.castleRights |= castleRightBits[Black][QueenSide-1]
 	}
-	if pos.castleRights == "" {
-		pos.castleRights = "-"
+	for _, c := range []Color{White, Black} {
+		for _, side := range []Side{KingSide, QueenSide} {
+			pos.castleRooks[c][side-1] = outerCastleRook(pos.board, c, side)
+		}
 	}
 	if b&bitsTurn != 0 {
 		pos.turn = Black
@@ -289,6 +504,8 @@ func (pos *Position) UnmarshalBinary(data []byte) error {
 		pos.enPassantSquare = NoSquare
 	}
 	pos.inCheck = isInCheck(pos)
+	pos.key = Zobrist.GenHash(pos)
+	pos.pawnKey = Zobrist.GenPawnHash(pos.board)
 	return nil
 }
 
@@ -297,32 +514,63 @@ func (pos *Position) copy() *Position {
 		board:           pos.board.copy(),
 		turn:            pos.turn,
 		castleRights:    pos.castleRights,
+		castleRooks:     pos.castleRooks,
 		enPassantSquare: pos.enPassantSquare,
 		halfMoveClock:   pos.halfMoveClock,
 		moveCount:       pos.moveCount,
 		inCheck:         pos.inCheck,
+		key:             pos.key,
+		pawnKey:         pos.pawnKey,
 	}
 }
 
+// Get the starting square of the rook the color can castle with on the side.
+func (pos *Position) castleRook(c Color, side Side) Square {
+	return pos.castleRooks[c][side-1]
+}
+
+// Get the castle rights after the move. A right is lost when the king moves,
+// or when its rook moves or is captured.
 func (pos *Position) updateCastleRights(m *Move) CastleRights {
-	cr := string(pos.castleRights)
-	p := pos.board.Piece(m.s1)
-	if p == WhiteKing || m.s1 == H1 || m.s2 == H1 {
-		cr = strings.Replace(cr, "K", "", -1)
+	cr := pos.castleRights
+	if cr == NoCastleRights {
+		return cr
 	}
-	if p == WhiteKing || m.s1 == A1 || m.s2 == A1 {
-		cr = strings.Replace(cr, "Q", "", -1)
-	}
-	if p == BlackKing || m.s1 == H8 || m.s2 == H8 {
-		cr = strings.Replace(cr, "k", "", -1)
-	}
-	if p == BlackKing || m.s1 == A8 || m.s2 == A8 {
-		cr = strings.Replace(cr, "q", "", -1)
-	}
-	if cr == "" {
-		cr = "-"
-	}
-	return CastleRights(cr)
+	p := pos.board.Piece(m.s1)
+	for c := White; c <= Black; c++ {
+		for side := KingSide; side <= QueenSide; side++ {
+			rook := pos.castleRook(c, side)
+			if p == pieceOf(King, c) || m.s1 == rook || m.s2 == rook {
+				cr &^= castleRightBits[c][side-1]
+			}
+		}
+	}
+	return cr
+}
+
+// Get the castle rights in X-FEN, which writes KQkq unless another rook
+// stands further out on the same side as the castling rook, in which case
+// the file of the castling rook is written instead.
+func (pos *Position) castleFEN() string {
+	fen := ""
+	for i, char := range []string{"K", "Q", "k", "q"} {
+		c, side := Color(i/2), Side(i%2+1)
+		if !pos.castleRights.CanCastle(c, side) {
+			continue
+		}
+		rook := pos.castleRook(c, side)
+		if rook == outerCastleRook(pos.board, c, side) {
+			fen += char
+		} else if c == White {
+			fen += strings.ToUpper(rook.File().String())
+		} else {
+			fen += rook.File().String()
+		}
+	}
+	if fen == "" {
+		return "-"
+	}
+	return fen
 }
 
 func (pos *Position) updateEnPassantSquare(m *Move) Square {
@@ -353,6 +601,11 @@ func (pos *Position) samePosition(pos2 *Position) bool {
 func (pos *Position) NullMove() *Position {
 	cp := pos.copy()
 	cp.turn = cp.turn.Other()
+	cp.key ^= Zobrist.SideToMoveNumber()
+	if pos.enPassantSquare != NoSquare {
+		cp.key ^= Zobrist.EPNumber(uint8(pos.enPassantSquare))
+	}
+	cp.enPassantSquare = NoSquare
 	if pos.turn == Black {
 		cp.moveCount++
 	}
@@ -0,0 +1,54 @@
+package chess
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestMakeUnmakeMove(t *testing.T) {
+	// Positions with castling, en passant, promotions and Chess960 castling
+	fens := []string{
+		"r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w KQkq - 0 1",
+		"r3k2r/Pppp1ppp/1b3nbN/nP6/BBP1P3/q4N2/Pp1P2PP/R2Q1RK1 w kq - 0 1",
+		"rnbqkbnr/ppp1p1pp/8/3pPp2/8/8/PPPP1PPP/RNBQKBNR w KQkq f6 0 3",
+		"bqnb1rkr/pp3ppp/3ppn2/2p5/5P2/P2P4/NPP1P1PP/BQ1BNRKR w HFhf - 2 9",
+		"2nnrbkr/p1qppppp/8/1ppb4/6PP/3PP3/PPP2P2/BQNNRBKR w HEhe - 1 9",
+	}
+
+	random := rand.New(rand.NewSource(1))
+	for _, fen := range fens {
+		for game := 0; game < 4; game++ {
+			pos := zobristPosition(t, fen).Copy()
+			for ply := 0; ply < 40; ply++ {
+				moves := pos.ValidMoves()
+				if len(moves) == 0 {
+					break
+				}
+
+				// Every move must give the same position as Update, and
+				// unmaking it must give back the position
+				before, board, check := pos.String(), *pos.board, pos.inCheck
+				for _, move := range moves {
+					updated := pos.Update(move)
+					pos.MakeMove(move)
+					if pos.String() != updated.String() || *pos.board != *updated.board ||
+						pos.inCheck != updated.inCheck || pos.Key() != updated.Key() {
+						t.Errorf("%s after %s: made %s, updated %s", before, move, pos, updated)
+					}
+					pos.UnmakeMove()
+					if pos.String() != before || *pos.board != board || pos.inCheck != check {
+						t.Errorf("%s after %s: unmade to %s", before, move, pos)
+					}
+				}
+
+				pos.MakeNullMove()
+				pos.UnmakeNullMove()
+				if pos.String() != before || pos.inCheck != check {
+					t.Errorf("%s: unmade null move to %s", before, pos)
+				}
+
+				pos.MakeMove(moves[random.Intn(len(moves))])
+			}
+		}
+	}
+}
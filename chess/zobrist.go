@@ -1,14 +1,12 @@
-package engine
-
-import (
-	"github.com/Sidhant-Roymoulik/Light-Blue/chess"
-)
+package chess
 
 // Borrowed from https://github.com/algerbrex/blunder/blob/main/engine/zobrist.go
 // Thanks Algerbrex, I didn't want to have to deal with debugging my own implementation.
 
 // zobrist.go contains an interface for creating and incrementally updating
-// the zobrist hash value of a given position.
+// the zobrist hash value of a given position. Every position carries its key,
+// which is updated by Update and NullMove, so the key of a position never has
+// to be generated from scratch during a search.
 //
 // https://www.chessprogramming.org/Zobrist_Hashing
 
@@ -48,6 +46,12 @@ const (
 	// into _Zobrist.epFileRand64 to return a 0, which will not affect the zobrist
 	// hash.
 	NoEPFile = 8
+
+	// Constants representing the castling right bits.
+	WhiteKingsideRight  uint8 = 0x8
+	WhiteQueensideRight uint8 = 0x4
+	BlackKingsideRight  uint8 = 0x2
+	BlackQueensideRight uint8 = 0x1
 )
 
 // A constant which will be a singleton of the _Zobrist struct below,
@@ -113,6 +117,17 @@ func (zobrist *_Zobrist) CastlingNumber(castlingRights uint8) uint64 {
 	return zobrist.castlingRightsRand64[castlingRights]
 }
 
+// Convert the castling rights of a position into the castling bits used to
+// index the castling numbers.
+func CastlingBits(castleRights CastleRights) uint8 {
+	return uint8(castleRights)
+}
+
+// Get the unique random number corresponding to the piece on the square.
+func (zobrist *_Zobrist) pieceSquareNumber(piece Piece, sq Square) uint64 {
+	return zobrist.PieceNumber(uint8(piece.Type()), uint8(piece.Color()), uint8(sq))
+}
+
 // Get the unique random number corresponding to the side to move given.
 func (zobrist *_Zobrist) SideToMoveNumber() uint64 {
 	return zobrist.sideToMoveRand64
@@ -121,23 +136,113 @@ func (zobrist *_Zobrist) SideToMoveNumber() uint64 {
 // Generate a zobrist hash from scratch for the given position.
 // Useful for creating hashs when loading in FEN strings and
 // debugging zobrist hashing itself.
-func (zobrist *_Zobrist) GenHash(pos *chess.Position) (hash uint64) {
+func (zobrist *_Zobrist) GenHash(pos *Position) (hash uint64) {
 	squares := pos.Board().SquareMap()
 	for square, piece := range squares {
 		// print(uint8(piece.Type()), uint8(piece.Color()), uint8(square))
 		hash ^= zobrist.PieceNumber(uint8(piece.Type()), uint8(piece.Color()), uint8(square))
 	}
 
-	// hash ^= zobrist.EPNumber(uint8(pos.EnPassantSquare()))
-	// hash ^= zobrist.CastlingNumber(pos.CastleRights())
+	if pos.EnPassantSquare() != NoSquare {
+		hash ^= zobrist.EPNumber(uint8(pos.EnPassantSquare()))
+	}
+	hash ^= zobrist.CastlingNumber(CastlingBits(pos.CastleRights()))
 
-	if pos.Turn() == chess.White {
+	if pos.Turn() == White {
 		hash ^= zobrist.SideToMoveNumber()
 	}
 
 	return hash
 }
 
+// Generate a zobrist hash of only the pawns on the board. Evaluation terms
+// that only depend on the pawns can be cached with it.
+func (zobrist *_Zobrist) GenPawnHash(board *Board) (hash uint64) {
+	for color, pawns := range [2]Bitboard{board.BBWhitePawn, board.BBBlackPawn} {
+		for pawns != 0 {
+			hash ^= zobrist.PieceNumber(uint8(Pawn), uint8(color), pawns.PopBit())
+		}
+	}
+	return hash
+}
+
+// Get the key of the position after the move, by updating the key of the
+// position before it. Must be called before the board is updated.
+func (zobrist *_Zobrist) updateKey(pos *Position, m *Move, castleRights CastleRights, epSq Square) uint64 {
+	key := pos.key
+	p1 := pos.board.Piece(m.s1)
+
+	// Move the king and rook when castling, the king takes its own rook
+	if m.isCastle() {
+		rook := pieceOf(Rook, p1.Color())
+		kingTo, rookTo := m.castleSquares()
+		key ^= zobrist.pieceSquareNumber(p1, m.s1) ^ zobrist.pieceSquareNumber(p1, kingTo)
+		key ^= zobrist.pieceSquareNumber(rook, m.s2) ^ zobrist.pieceSquareNumber(rook, rookTo)
+	} else {
+		// Move the piece, taking whatever was on the target square
+		key ^= zobrist.pieceSquareNumber(p1, m.s1)
+		if captured := pos.board.Piece(m.s2); captured != NoPiece {
+			key ^= zobrist.pieceSquareNumber(captured, m.s2)
+		}
+		if m.promo != NoPieceType {
+			key ^= zobrist.pieceSquareNumber(NewPiece(m.promo, p1.Color()), m.s2)
+		} else {
+			key ^= zobrist.pieceSquareNumber(p1, m.s2)
+		}
+
+		// Remove the pawn captured en passant
+		if m.HasTag(EnPassant) {
+			if p1.Color() == White {
+				key ^= zobrist.pieceSquareNumber(BlackPawn, m.s2-8)
+			} else {
+				key ^= zobrist.pieceSquareNumber(WhitePawn, m.s2+8)
+			}
+		}
+	}
+
+	key ^= zobrist.CastlingNumber(CastlingBits(pos.castleRights))
+	key ^= zobrist.CastlingNumber(CastlingBits(castleRights))
+
+	if pos.enPassantSquare != NoSquare {
+		key ^= zobrist.EPNumber(uint8(pos.enPassantSquare))
+	}
+	if epSq != NoSquare {
+		key ^= zobrist.EPNumber(uint8(epSq))
+	}
+
+	return key ^ zobrist.SideToMoveNumber()
+}
+
+// Get the pawn key of the position after the move, by updating the pawn key
+// of the position before it. Must be called before the board is updated.
+func (zobrist *_Zobrist) updatePawnKey(pos *Position, m *Move) uint64 {
+	key := pos.pawnKey
+	if m.isCastle() {
+		return key
+	}
+
+	// Remove a pawn that is taken, including en passant
+	if captured := pos.board.Piece(m.s2); captured.Type() == Pawn {
+		key ^= zobrist.pieceSquareNumber(captured, m.s2)
+	} else if m.HasTag(EnPassant) {
+		if pos.turn == White {
+			key ^= zobrist.pieceSquareNumber(BlackPawn, m.s2-8)
+		} else {
+			key ^= zobrist.pieceSquareNumber(WhitePawn, m.s2+8)
+		}
+	}
+
+	// Move the pawn, which leaves the pawns if it promotes
+	if p1 := pos.board.Piece(m.s1); p1.Type() == Pawn {
+		key ^= zobrist.pieceSquareNumber(p1, m.s1)
+		if m.promo == NoPieceType {
+			key ^= zobrist.pieceSquareNumber(p1, m.s2)
+		}
+	}
+
+	return key
+}
+
 // Precomputing all possible en passant file numbers
 // is much more efficent for Blunder than calculating
 // them on the fly.
@@ -0,0 +1,198 @@
+package chess
+
+import (
+	"math/rand"
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	InitBitboards()
+	InitZobrist()
+	os.Exit(m.Run())
+}
+
+const zobristStartFEN = "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1"
+
+// Get the position of the FEN, failing the test if the incrementally updated
+// key doesn't match a key generated from scratch.
+func zobristPosition(t *testing.T, fen string) *Position {
+	t.Helper()
+	pos, err := decodeFEN(fen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkZobristKey(t, pos)
+	return pos
+}
+
+// Play the moves from the FEN, checking the key after every move.
+func zobristMoves(t *testing.T, fen string, moves ...string) *Position {
+	t.Helper()
+	pos := zobristPosition(t, fen)
+	for _, smove := range moves {
+		move, err := UCINotation{}.Decode(pos, smove)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pos = pos.Update(move)
+		checkZobristKey(t, pos)
+	}
+	return pos
+}
+
+func checkZobristKey(t *testing.T, pos *Position) {
+	t.Helper()
+	if pos.Key() != Zobrist.GenHash(pos) {
+		t.Errorf("incremental key doesn't match the generated key for %s", pos)
+	}
+	if pos.PawnKey() != Zobrist.GenPawnHash(pos.board) {
+		t.Errorf("incremental pawn key doesn't match the generated pawn key for %s", pos)
+	}
+}
+
+func TestZobristDifferentPositions(t *testing.T) {
+	// Positions that differ in some part of their identity
+	tests := []struct {
+		name string
+		a    string
+		b    string
+	}{
+		{"side to move", zobristStartFEN, "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR b KQkq - 0 1"},
+		{"white king side castling", zobristStartFEN, "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w Qkq - 0 1"},
+		{"white queen side castling", zobristStartFEN, "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w Kkq - 0 1"},
+		{"black king side castling", zobristStartFEN, "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQq - 0 1"},
+		{"black queen side castling", zobristStartFEN, "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQk - 0 1"},
+		{"no castling", zobristStartFEN, "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w - - 0 1"},
+		{
+			"castling color",
+			"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQ - 0 1",
+			"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w kq - 0 1",
+		},
+		{
+			"white en passant square",
+			"rnbqkbnr/ppp1pppp/8/8/3pP3/8/PPPP1PPP/RNBQKBNR b KQkq e3 0 3",
+			"rnbqkbnr/ppp1pppp/8/8/3pP3/8/PPPP1PPP/RNBQKBNR b KQkq - 0 3",
+		},
+		{
+			"black en passant square",
+			"rnbqkbnr/pppp1ppp/8/3Pp3/8/8/PPP1PPPP/RNBQKBNR w KQkq e6 0 3",
+			"rnbqkbnr/pppp1ppp/8/3Pp3/8/8/PPP1PPPP/RNBQKBNR w KQkq - 0 3",
+		},
+	}
+
+	for _, test := range tests {
+		if zobristPosition(t, test.a).Key() == zobristPosition(t, test.b).Key() {
+			t.Errorf("%s: same key for %s and %s", test.name, test.a, test.b)
+		}
+	}
+}
+
+func TestZobristSamePositions(t *testing.T) {
+	// Positions reached in different ways that must hash the same
+	tests := []struct {
+		name string
+		a    *Position
+		b    *Position
+	}{
+		{
+			"knights moving out and back",
+			zobristPosition(t, zobristStartFEN),
+			zobristMoves(t, zobristStartFEN, "g1f3", "g8f6", "f3g1", "f6g8"),
+		},
+		{
+			"double pawn push",
+			zobristPosition(t, "rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq e3 0 1"),
+			zobristMoves(t, zobristStartFEN, "e2e4"),
+		},
+		{
+			"king move losing both castling rights",
+			zobristPosition(t, "rnbqkbnr/pppp1ppp/8/4p3/4P3/8/PPPPKPPP/RNBQ1BNR b kq - 1 2"),
+			zobristMoves(t, zobristStartFEN, "e2e4", "e7e5", "e1e2"),
+		},
+		{
+			"rook move losing one castling right",
+			zobristPosition(t, "rnbqkbnr/1ppppppp/8/p7/P7/R7/1PPPPPPP/1NBQKBNR b Kkq - 1 2"),
+			zobristMoves(t, zobristStartFEN, "a2a4", "a7a5", "a1a3"),
+		},
+		{
+			"null move clearing the en passant square",
+			zobristPosition(t, "rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR w KQkq - 0 1"),
+			zobristPosition(t, "rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq e3 0 1").NullMove(),
+		},
+	}
+
+	for _, test := range tests {
+		checkZobristKey(t, test.b)
+		if test.a.Key() != test.b.Key() {
+			t.Errorf("%s: different keys for %s and %s", test.name, test.a, test.b)
+		}
+	}
+}
+
+func TestZobristIncrementalUpdates(t *testing.T) {
+	// Captures, en passant, promotions and castling on both sides
+	zobristMoves(t,
+		"r3k2r/pPppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w KQkq - 0 1",
+		"a2a4", "b4a3", "e1c1", "e8g8", "b7a8q", "a3b2", "c1b2",
+	)
+	zobristMoves(t, "8/8/8/8/8/8/1p4k1/R3K3 b Q - 0 1", "b2a1r", "e1d2")
+	zobristMoves(t,
+		"rnbqkbnr/ppp1p1pp/8/3pPp2/8/8/PPPP1PPP/RNBQKBNR w KQkq f6 0 3",
+		"e5f6", "g8f6", "e1e2", "e8f7",
+	)
+
+	// Making and unmaking moves in place must give back the key
+	pos := zobristPosition(t,
+		"r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w KQkq - 0 1",
+	).Copy()
+	key := pos.Key()
+	for _, move := range pos.ValidMoves() {
+		pos.MakeMove(move)
+		checkZobristKey(t, pos)
+		pos.UnmakeMove()
+		if pos.Key() != key {
+			t.Errorf("key changed after making and unmaking %s", move)
+		}
+	}
+}
+
+func TestZobristPawnKey(t *testing.T) {
+	// Positions with double pushes, en passant and promotions close by
+	fens := []string{
+		zobristStartFEN,
+		"r3k2r/pPppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w KQkq - 0 1",
+		"rnbqkbnr/ppp1p1pp/8/3pPp2/8/8/PPPP1PPP/RNBQKBNR w KQkq f6 0 3",
+		"8/2p5/3p4/KP5r/1R3p1k/8/4P1P1/8 w - - 0 1",
+		"8/1P4k1/8/8/8/8/1p4K1/8 w - - 0 1",
+	}
+
+	// Along random games, the pawn key kept by MakeMove, UnmakeMove and
+	// Update must match the one generated from the pawns
+	random := rand.New(rand.NewSource(1))
+	for _, fen := range fens {
+		for game := 0; game < 10; game++ {
+			pos := zobristPosition(t, fen).Copy()
+			for ply := 0; ply < 60; ply++ {
+				moves := pos.ValidMoves()
+				if len(moves) == 0 {
+					break
+				}
+
+				pawnKey := pos.PawnKey()
+				for _, move := range moves {
+					checkZobristKey(t, pos.Update(move))
+					pos.MakeMove(move)
+					checkZobristKey(t, pos)
+					pos.UnmakeMove()
+					if pos.PawnKey() != pawnKey {
+						t.Errorf("%s: pawn key changed after making and unmaking %s", pos, move)
+					}
+				}
+
+				pos.MakeMove(moves[random.Intn(len(moves))])
+				checkZobristKey(t, pos)
+			}
+		}
+	}
+}
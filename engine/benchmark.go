@@ -37,6 +37,10 @@ func benchmark(
 		e.counters.iid_move_found,
 		e.counters.lmp_pruned,
 		e.counters.futility_pruned,
+		e.counters.lmr_reduced,
+		e.counters.singular_extensions,
+		e.counters.see_pruned,
+		pawn_hash_hit_rate(&e.counters),
 	}
 	rows = append(rows, row)
 
@@ -46,6 +50,14 @@ func benchmark(
 	return rows
 }
 
+// Get the percentage of pawn hash table probes that hit.
+func pawn_hash_hit_rate(counters *EngineCounters) string {
+	if counters.pawn_hash_probes == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", 100*float64(counters.pawn_hash_hits)/float64(counters.pawn_hash_probes))
+}
+
 func benchmark_range(plymin int, plymax int, e *Engine, pos *chess.Position) {
 	rows := [][]interface{}{}
 	for i := plymin; i <= plymax; i++ {
@@ -67,6 +79,10 @@ func benchmark_range(plymin int, plymax int, e *Engine, pos *chess.Position) {
 			"IID",
 			"LMP",
 			"Futility",
+			"LMR",
+			"Singular",
+			"SEE",
+			"Pawn Hits",
 		})
 		fmt.Println(t.Render("grid"))
 	}
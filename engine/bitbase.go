@@ -0,0 +1,177 @@
+package engine
+
+import (
+	"github.com/Sidhant-Roymoulik/Light-Blue/chess"
+)
+
+// bitbase.go contains the KPK bitbase, which stores whether king and pawn
+// versus king is won for every position with the pawn on the a to d files.
+// It's generated at startup by retrograde analysis: positions that can be
+// classified right away, like a safe promotion or a capturable pawn, are
+// classified first, and every other position is classified from the
+// positions its moves lead to until nothing changes.
+//
+// https://www.chessprogramming.org/KPK
+
+// The number of positions in the bitbase: the side to move, 24 pawn squares
+// and the squares of both kings.
+const KPKSize int = 2 * 24 * 64 * 64
+
+// The results a position can have while the bitbase is generated. Results
+// are bit flags so the results of the moves of a position can be combined.
+const (
+	kpk_invalid uint8 = 0
+	kpk_unknown uint8 = 1
+	kpk_draw    uint8 = 2
+	kpk_win     uint8 = 4
+)
+
+// One bit for every position, set if the strong side wins.
+var kpk_bitbase [KPKSize / 64]uint64
+
+// Get the index of the position, with the strong side as white and the pawn
+// on the a to d files.
+func kpk_index(stm chess.Color, white_king uint8, black_king uint8, pawn uint8) int {
+	pawn_index := int(RankOf(pawn)-1)*4 + int(FileOf(pawn))
+	return ((int(stm)*24+pawn_index)*64+int(white_king))*64 + int(black_king)
+}
+
+// Generate the bitbase.
+func InitKPKBitbase() {
+	results := make([]uint8, KPKSize)
+
+	// Classify the positions that don't depend on other positions
+	for idx := 0; idx < KPKSize; idx++ {
+		stm, white_king, black_king, pawn := kpk_position(idx)
+		results[idx] = kpk_initial_result(stm, white_king, black_king, pawn)
+	}
+
+	// Classify the other positions from the results of their moves, until
+	// no more positions can be classified
+	for changed := true; changed; {
+		changed = false
+		for idx := 0; idx < KPKSize; idx++ {
+			if results[idx] != kpk_unknown {
+				continue
+			}
+			stm, white_king, black_king, pawn := kpk_position(idx)
+			results[idx] = kpk_classify(results, stm, white_king, black_king, pawn)
+			changed = changed || results[idx] != kpk_unknown
+		}
+	}
+
+	for idx := 0; idx < KPKSize; idx++ {
+		if results[idx] == kpk_win {
+			kpk_bitbase[idx/64] |= 1 << (idx % 64)
+		}
+	}
+}
+
+// Get the position stored at the index.
+func kpk_position(idx int) (chess.Color, uint8, uint8, uint8) {
+	black_king := uint8(idx % 64)
+	white_king := uint8((idx / 64) % 64)
+	pawn_index := (idx / (64 * 64)) % 24
+	stm := chess.Color(idx / (64 * 64 * 24))
+	return stm, white_king, black_king, uint8((pawn_index/4+1)*8 + pawn_index%4)
+}
+
+// Get the result of the position if it can be found without looking at its
+// moves.
+func kpk_initial_result(stm chess.Color, white_king uint8, black_king uint8, pawn uint8) uint8 {
+	// Touching kings, a king on the pawn, or black in check with white to
+	// move can't happen
+	if distance(white_king, black_king) <= 1 ||
+		white_king == pawn ||
+		black_king == pawn ||
+		(stm == chess.White && PawnAttacks[chess.White][pawn]&chess.SquareBB[black_king] != 0) {
+		return kpk_invalid
+	}
+
+	// White wins if the pawn can promote without being captured
+	promotion := pawn + 8
+	if stm == chess.White && RankOf(pawn) == Rank7 && white_king != promotion &&
+		(distance(black_king, promotion) > 1 || distance(white_king, promotion) == 1) {
+		return kpk_win
+	}
+
+	if stm == chess.Black {
+		white_attacks := KingMoves[white_king] | PawnAttacks[chess.White][pawn]
+
+		// Black is stalemated
+		if KingMoves[black_king]&^white_attacks == 0 {
+			return kpk_draw
+		}
+
+		// Black can capture the pawn
+		if KingMoves[black_king]&chess.SquareBB[pawn]&^KingMoves[white_king] != 0 {
+			return kpk_draw
+		}
+	}
+
+	return kpk_unknown
+}
+
+// Get the result of the position from the results of its moves. White wins
+// if a move wins, and black draws if a move draws.
+func kpk_classify(results []uint8, stm chess.Color, white_king uint8, black_king uint8, pawn uint8) uint8 {
+	moves := kpk_invalid
+
+	if stm == chess.White {
+		king_moves := KingMoves[white_king]
+		for king_moves != 0 {
+			sq := king_moves.PopBit()
+			moves |= results[kpk_index(chess.Black, sq, black_king, pawn)]
+		}
+
+		// Pawn pushes onto a king are invalid positions, which don't change
+		// the result
+		if RankOf(pawn) < Rank7 {
+			moves |= results[kpk_index(chess.Black, white_king, black_king, pawn+8)]
+		}
+		if RankOf(pawn) == Rank2 && pawn+8 != white_king && pawn+8 != black_king {
+			moves |= results[kpk_index(chess.Black, white_king, black_king, pawn+16)]
+		}
+
+		if moves&kpk_win != 0 {
+			return kpk_win
+		} else if moves&kpk_unknown != 0 {
+			return kpk_unknown
+		}
+		return kpk_draw
+	}
+
+	king_moves := KingMoves[black_king]
+	for king_moves != 0 {
+		sq := king_moves.PopBit()
+		moves |= results[kpk_index(chess.White, white_king, sq, pawn)]
+	}
+
+	if moves&kpk_draw != 0 {
+		return kpk_draw
+	} else if moves&kpk_unknown != 0 {
+		return kpk_unknown
+	}
+	return kpk_win
+}
+
+// Check if the position is won for the strong side. The squares can be for
+// either color, and the pawn on any file.
+func kpk_probe(strong chess.Color, stm chess.Color, strong_king uint8, pawn uint8, weak_king uint8) bool {
+	// Flip the board so the strong side is white, and mirror it so the pawn
+	// is on the a to d files
+	if strong == chess.Black {
+		strong_king, pawn, weak_king = strong_king^56, pawn^56, weak_king^56
+	}
+	if FileOf(pawn) > FileD {
+		strong_king, pawn, weak_king = strong_king^7, pawn^7, weak_king^7
+	}
+
+	relative_stm := chess.White
+	if stm != strong {
+		relative_stm = chess.Black
+	}
+
+	idx := kpk_index(relative_stm, strong_king, weak_king, pawn)
+	return kpk_bitbase[idx/64]&(1<<(idx%64)) != 0
+}
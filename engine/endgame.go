@@ -0,0 +1,521 @@
+package engine
+
+import (
+	"strings"
+
+	"github.com/Sidhant-Roymoulik/Light-Blue/chess"
+)
+
+// endgame.go contains evaluation functions for endgames the general
+// evaluation doesn't understand. Endgames are looked up by the material on
+// the board, and either replace the general evaluation, like mating with
+// bishop and knight, or scale it down, like a rook pawn with the wrong
+// bishop. Endgames are written for a strong and a weak side, and work for
+// either color.
+//
+// https://www.chessprogramming.org/Endgame
+// (Adapted from https://github.com/official-stockfish/Stockfish/blob/master/src/endgame.cpp)
+
+// An evaluation function for an endgame, which returns the score from the
+// strong side's perspective.
+type endgame_eval func(ev *Evaluator, strong chess.Color, turn chess.Color) int
+
+// A scaling function for an endgame, which returns how much of the general
+// evaluation to keep, from ScaleFactorDraw to ScaleFactorNormal.
+type endgame_scale func(ev *Evaluator, strong chess.Color, turn chess.Color) int
+
+// An endgame and the side it's written for. The strong side of an endgame
+// with the same material on both sides is the side the evaluation favors.
+type endgame[T any] struct {
+	function  T
+	strong    chess.Color
+	symmetric bool
+}
+
+const (
+	KnownWinValue int = 10000
+
+	ScaleFactorDraw   int = 0
+	ScaleFactorNormal int = 64
+)
+
+// Endgames by the material key of their pieces.
+var endgame_evals = map[uint64]endgame[endgame_eval]{}
+var endgame_scales = map[uint64]endgame[endgame_scale]{}
+
+// -----------------------------------------------------------------------------
+// 		Endgame Tables
+// -----------------------------------------------------------------------------
+
+// Generate the KPK bitbase and register every endgame.
+func InitEndgames() {
+	InitKPKBitbase()
+
+	add_endgame(endgame_evals, "KPvK", eval_kpk)
+	add_endgame(endgame_evals, "KNNvK", eval_knnk)
+	add_endgame(endgame_evals, "KBNvK", eval_kbnk)
+	add_endgame(endgame_evals, "KRvKP", eval_krkp)
+	add_endgame(endgame_evals, "KRvKB", eval_krkb)
+	add_endgame(endgame_evals, "KRvKN", eval_krkn)
+	add_endgame(endgame_evals, "KQvKP", eval_kqkp)
+	add_endgame(endgame_evals, "KQvKR", eval_kqkr)
+
+	add_endgame(endgame_scales, "KPvKP", scale_kpkp)
+	add_endgame(endgame_scales, "KRPvKR", scale_krpkr)
+	add_endgame(endgame_scales, "KBPvKB", scale_kbpkb)
+}
+
+// Register the endgame for both colors. The code lists the pieces of the
+// strong side and then the weak side, like KRPvKR.
+func add_endgame[T any](endgames map[uint64]endgame[T], code string, function T) {
+	sides := strings.Split(code, "v")
+
+	var counts [2][7]int
+	for color, side := range sides {
+		for _, char := range side {
+			pt := chess.PieceType(strings.IndexRune("?KQRBNP", char))
+			counts[color][pt]++
+		}
+	}
+
+	flipped := [2][7]int{counts[chess.Black], counts[chess.White]}
+	symmetric := sides[0] == sides[1]
+
+	endgames[pack_material(&counts)] = endgame[T]{function, chess.White, symmetric}
+	endgames[pack_material(&flipped)] = endgame[T]{function, chess.Black, symmetric}
+}
+
+// Get the key of the material on the board, with 4 bits for the number of
+// each piece.
+func material_key(pieces *[2][7]chess.Bitboard) uint64 {
+	var counts [2][7]int
+	for color := chess.White; color <= chess.Black; color++ {
+		for pt := chess.Queen; pt <= chess.Pawn; pt++ {
+			counts[color][pt] = pieces[color][pt].CountBits()
+		}
+	}
+	return pack_material(&counts)
+}
+
+func pack_material(counts *[2][7]int) uint64 {
+	key := uint64(0)
+	for color := chess.White; color <= chess.Black; color++ {
+		for pt := chess.Queen; pt <= chess.Pawn; pt++ {
+			key = key<<4 | uint64(Min(counts[color][pt], 15))
+		}
+	}
+	return key
+}
+
+// Get the evaluation of a known endgame from the side to move's
+// perspective, if the position is one.
+func (ev *Evaluator) eval_endgame(turn chess.Color) (int, bool) {
+	strong := chess.NoColor
+	var function endgame_eval
+
+	if entry, ok := endgame_evals[material_key(&ev.pieces)]; ok {
+		strong, function = entry.strong, entry.function
+	} else if ev.is_kxk(chess.White) {
+		strong, function = chess.White, eval_kxk
+	} else if ev.is_kxk(chess.Black) {
+		strong, function = chess.Black, eval_kxk
+	} else {
+		return 0, false
+	}
+
+	eval := function(ev, strong, turn)
+	if strong != turn {
+		eval = -eval
+	}
+	return eval, true
+}
+
+// Scale the general evaluation, from the side to move's perspective, if the
+// position is an endgame that's likely drawn.
+func (ev *Evaluator) scale_endgame(turn chess.Color, eval int) int {
+	// The side the evaluation favors
+	favored := turn
+	if eval < 0 {
+		favored = turn.Other()
+	}
+
+	scale := ScaleFactorNormal
+	if entry, ok := endgame_scales[material_key(&ev.pieces)]; ok {
+		strong := entry.strong
+		if entry.symmetric {
+			strong = favored
+		}
+		if strong == favored {
+			scale = entry.function(ev, strong, turn)
+		}
+	} else if ev.is_kbpsk(favored) {
+		scale = scale_kbpsk(ev, favored, turn)
+	} else if ev.is_kpsk(favored) {
+		scale = scale_kpsk(ev, favored, turn)
+	} else if is_drawish(ev.pieces) {
+		return eval / DrawishScaleFactor
+	}
+
+	return eval * scale / ScaleFactorNormal
+}
+
+// Check if the strong side has enough material to mate a bare king.
+func (ev *Evaluator) is_kxk(strong chess.Color) bool {
+	weak := strong.Other()
+	for pt := chess.Queen; pt <= chess.Pawn; pt++ {
+		if ev.pieces[weak][pt] != 0 {
+			return false
+		}
+	}
+	return ev.non_pawn_material(strong) >= PVM_EG[chess.Rook]
+}
+
+// Check if the strong side has a bishop and pawns against a bare king.
+func (ev *Evaluator) is_kbpsk(strong chess.Color) bool {
+	return ev.pieces[strong][chess.Pawn] != 0 &&
+		ev.non_pawn_material(strong) == PVM_EG[chess.Bishop] &&
+		ev.pieces[strong][chess.Bishop] != 0 &&
+		ev.is_bare_king(strong.Other())
+}
+
+// Check if the strong side has pawns against a bare king.
+func (ev *Evaluator) is_kpsk(strong chess.Color) bool {
+	return ev.pieces[strong][chess.Pawn] != 0 &&
+		ev.non_pawn_material(strong) == 0 &&
+		ev.is_bare_king(strong.Other())
+}
+
+func (ev *Evaluator) is_bare_king(color chess.Color) bool {
+	return ev.pieces[color][chess.Pawn] == 0 && ev.non_pawn_material(color) == 0
+}
+
+// Get the endgame value of the pieces of the color, without pawns.
+func (ev *Evaluator) non_pawn_material(color chess.Color) int {
+	material := 0
+	for pt := chess.Queen; pt <= chess.Knight; pt++ {
+		material += ev.pieces[color][pt].CountBits() * PVM_EG[pt]
+	}
+	return material
+}
+
+// -----------------------------------------------------------------------------
+// 		Helpers
+// -----------------------------------------------------------------------------
+
+// Get the square of the only piece of the type and color.
+func (ev *Evaluator) square(color chess.Color, pt chess.PieceType) uint8 {
+	return ev.pieces[color][pt].Msb()
+}
+
+// Get the rank of the square from the color's side of the board.
+func relative_rank(color chess.Color, square uint8) uint8 {
+	if color == chess.Black {
+		return Rank8 - RankOf(square)
+	}
+	return RankOf(square)
+}
+
+// Get a bonus for driving a king to the edge of the board.
+func push_to_edge(square uint8) int {
+	rank_distance := int(Min(int(RankOf(square)), int(Rank8-RankOf(square))))
+	file_distance := int(Min(int(FileOf(square)), int(FileH-FileOf(square))))
+	return 90 - (7*file_distance*file_distance/2 + 7*rank_distance*rank_distance/2)
+}
+
+// Get a bonus for driving a king to the a1 or h8 corner.
+func push_to_corner(square uint8) int {
+	return abs(7 - int(RankOf(square)) - int(FileOf(square)))
+}
+
+// Get a bonus for bringing two pieces close to each other.
+func push_close(s1 uint8, s2 uint8) int {
+	return 140 - 20*distance(s1, s2)
+}
+
+// Get a bonus for driving two pieces away from each other.
+func push_away(s1 uint8, s2 uint8) int {
+	return 120 - push_close(s1, s2)
+}
+
+// -----------------------------------------------------------------------------
+// 		Evaluation Functions
+// -----------------------------------------------------------------------------
+
+// Mate a bare king with enough material, by driving it to the edge with the
+// other king close.
+func eval_kxk(ev *Evaluator, strong chess.Color, turn chess.Color) int {
+	weak := strong.Other()
+	strong_king := ev.square(strong, chess.King)
+	weak_king := ev.square(weak, chess.King)
+
+	eval := ev.non_pawn_material(strong) +
+		ev.pieces[strong][chess.Pawn].CountBits()*PVM_EG[chess.Pawn] +
+		push_to_edge(weak_king) +
+		push_close(strong_king, weak_king)
+
+	// Bishops on both colors
+	dark, light := false, false
+	bishops := ev.pieces[strong][chess.Bishop]
+	for bishops != 0 {
+		if isSqDark(bishops.PopBit()) {
+			dark = true
+		} else {
+			light = true
+		}
+	}
+
+	if ev.pieces[strong][chess.Queen] != 0 ||
+		ev.pieces[strong][chess.Rook] != 0 ||
+		(ev.pieces[strong][chess.Bishop] != 0 && ev.pieces[strong][chess.Knight] != 0) ||
+		(dark && light) {
+		eval += KnownWinValue
+	}
+
+	return eval
+}
+
+// Two knights can't force mate.
+func eval_knnk(ev *Evaluator, strong chess.Color, turn chess.Color) int {
+	return 0
+}
+
+// Mate with bishop and knight, which is only possible in a corner of the
+// bishop's color.
+func eval_kbnk(ev *Evaluator, strong chess.Color, turn chess.Color) int {
+	weak := strong.Other()
+	strong_king := ev.square(strong, chess.King)
+	weak_king := ev.square(weak, chess.King)
+	bishop := ev.square(strong, chess.Bishop)
+
+	// Mirror the board so the corners of the bishop's color are a1 and h8
+	corner_king := weak_king
+	if !isSqDark(bishop) {
+		corner_king ^= 7
+	}
+
+	return KnownWinValue + PVM_EG[chess.Bishop] + PVM_EG[chess.Knight] +
+		push_close(strong_king, weak_king) +
+		60*push_to_corner(corner_king)
+}
+
+// King and pawn against king is won or drawn according to the bitbase.
+func eval_kpk(ev *Evaluator, strong chess.Color, turn chess.Color) int {
+	weak := strong.Other()
+	pawn := ev.square(strong, chess.Pawn)
+
+	if !kpk_probe(
+		strong,
+		turn,
+		ev.square(strong, chess.King),
+		pawn,
+		ev.square(weak, chess.King),
+	) {
+		return 0
+	}
+
+	return KnownWinValue + PVM_EG[chess.Pawn] + int(relative_rank(strong, pawn))
+}
+
+// Rook against pawn is won if the strong king is in front of the pawn or
+// the weak king is too far away, and drawish if the pawn is far advanced
+// and supported.
+func eval_krkp(ev *Evaluator, strong chess.Color, turn chess.Color) int {
+	weak := strong.Other()
+	strong_king := ev.square(strong, chess.King)
+	weak_king := ev.square(weak, chess.King)
+	rook := ev.square(strong, chess.Rook)
+	pawn := ev.square(weak, chess.Pawn)
+
+	promotion := FileOf(pawn)
+	push := pawn - 8
+	if weak == chess.White {
+		promotion += 56
+		push = pawn + 8
+	}
+
+	weak_tempo, strong_tempo := 0, 0
+	if turn == weak {
+		weak_tempo = 1
+	} else {
+		strong_tempo = 1
+	}
+
+	in_front := FileOf(strong_king) == FileOf(pawn) &&
+		relative_rank(weak, strong_king) > relative_rank(weak, pawn)
+
+	if in_front {
+		return PVM_EG[chess.Rook] - distance(strong_king, pawn)
+	} else if distance(weak_king, pawn) >= 3+weak_tempo && distance(weak_king, rook) >= 3 {
+		return PVM_EG[chess.Rook] - distance(strong_king, pawn)
+	} else if relative_rank(strong, weak_king) <= Rank3 &&
+		distance(weak_king, pawn) == 1 &&
+		relative_rank(strong, strong_king) >= Rank4 &&
+		distance(strong_king, pawn) > 2+strong_tempo {
+		return 80 - 8*distance(strong_king, pawn)
+	}
+
+	return 200 - 8*(distance(strong_king, push)-
+		distance(weak_king, push)-
+		distance(pawn, promotion))
+}
+
+// Rook against bishop is usually a draw, but the rook side can try to drive
+// the king to the edge.
+func eval_krkb(ev *Evaluator, strong chess.Color, turn chess.Color) int {
+	return push_to_edge(ev.square(strong.Other(), chess.King))
+}
+
+// Rook against knight is usually a draw, but the rook side can try to drive
+// the king to the edge and away from the knight.
+func eval_krkn(ev *Evaluator, strong chess.Color, turn chess.Color) int {
+	weak := strong.Other()
+	weak_king := ev.square(weak, chess.King)
+	knight := ev.square(weak, chess.Knight)
+	return push_to_edge(weak_king) + push_away(weak_king, knight)
+}
+
+// Queen against pawn is won, unless a bishop or rook pawn on the seventh
+// rank is supported by its king.
+func eval_kqkp(ev *Evaluator, strong chess.Color, turn chess.Color) int {
+	weak := strong.Other()
+	strong_king := ev.square(strong, chess.King)
+	weak_king := ev.square(weak, chess.King)
+	pawn := ev.square(weak, chess.Pawn)
+
+	eval := push_close(strong_king, weak_king)
+
+	file := FileOf(pawn)
+	if relative_rank(weak, pawn) != Rank7 ||
+		distance(weak_king, pawn) != 1 ||
+		file == FileB || file == FileD || file == FileE || file == FileG {
+		eval += PVM_EG[chess.Queen] - PVM_EG[chess.Pawn]
+	}
+
+	return eval
+}
+
+// Queen against rook is won by driving the king to the edge.
+func eval_kqkr(ev *Evaluator, strong chess.Color, turn chess.Color) int {
+	weak := strong.Other()
+	strong_king := ev.square(strong, chess.King)
+	weak_king := ev.square(weak, chess.King)
+
+	return PVM_EG[chess.Queen] - PVM_EG[chess.Rook] +
+		push_to_edge(weak_king) +
+		push_close(strong_king, weak_king)
+}
+
+// -----------------------------------------------------------------------------
+// 		Scaling Functions
+// -----------------------------------------------------------------------------
+
+// Bishop and rook pawns are drawn if the bishop can't control the promotion
+// square and the weak king reaches it.
+func scale_kbpsk(ev *Evaluator, strong chess.Color, turn chess.Color) int {
+	weak := strong.Other()
+	pawns := ev.pieces[strong][chess.Pawn]
+
+	for _, file := range []uint8{FileA, FileH} {
+		if pawns&^MaskFile[file] != 0 {
+			continue
+		}
+
+		promotion := file
+		if strong == chess.White {
+			promotion += 56
+		}
+		bishop := ev.square(strong, chess.Bishop)
+		weak_king := ev.square(weak, chess.King)
+
+		if isSqDark(bishop) != isSqDark(promotion) && distance(weak_king, promotion) <= 1 {
+			return ScaleFactorDraw
+		}
+	}
+
+	return ScaleFactorNormal
+}
+
+// Rook pawns are drawn if the weak king reaches the promotion square.
+func scale_kpsk(ev *Evaluator, strong chess.Color, turn chess.Color) int {
+	weak := strong.Other()
+	pawns := ev.pieces[strong][chess.Pawn]
+
+	for _, file := range []uint8{FileA, FileH} {
+		if pawns&^MaskFile[file] != 0 {
+			continue
+		}
+
+		promotion := file
+		if strong == chess.White {
+			promotion += 56
+		}
+		if distance(ev.square(weak, chess.King), promotion) <= 1 {
+			return ScaleFactorDraw
+		}
+	}
+
+	return ScaleFactorNormal
+}
+
+// King and pawn against king and pawn is drawn if the strong side couldn't
+// win even without the weak pawn, unless the pawn is far enough advanced to
+// race.
+func scale_kpkp(ev *Evaluator, strong chess.Color, turn chess.Color) int {
+	weak := strong.Other()
+	pawn := ev.square(strong, chess.Pawn)
+
+	file := FileOf(pawn)
+	if relative_rank(strong, pawn) >= Rank5 && file != FileA && file != FileH {
+		return ScaleFactorNormal
+	}
+
+	if kpk_probe(
+		strong,
+		turn,
+		ev.square(strong, chess.King),
+		pawn,
+		ev.square(weak, chess.King),
+	) {
+		return ScaleFactorNormal
+	}
+	return ScaleFactorDraw
+}
+
+// Rook and pawn against rook is drawish if the weak king is in front of the
+// pawn and the strong king isn't ahead of it.
+func scale_krpkr(ev *Evaluator, strong chess.Color, turn chess.Color) int {
+	weak := strong.Other()
+	strong_king := ev.square(strong, chess.King)
+	weak_king := ev.square(weak, chess.King)
+	pawn := ev.square(strong, chess.Pawn)
+
+	if abs(int(FileOf(weak_king))-int(FileOf(pawn))) <= 1 &&
+		relative_rank(strong, weak_king) > relative_rank(strong, pawn) &&
+		relative_rank(strong, strong_king) <= relative_rank(strong, pawn) {
+		return ScaleFactorNormal / 8
+	}
+	return ScaleFactorNormal
+}
+
+// Bishop and pawn against bishop is drawn if the weak king blocks the pawn
+// on a square the strong bishop can't attack, or with bishops of opposite
+// colors if the weak side can give up the bishop for the pawn.
+func scale_kbpkb(ev *Evaluator, strong chess.Color, turn chess.Color) int {
+	weak := strong.Other()
+	strong_bishop := ev.square(strong, chess.Bishop)
+	weak_bishop := ev.square(weak, chess.Bishop)
+	weak_king := ev.square(weak, chess.King)
+	pawn := ev.square(strong, chess.Pawn)
+
+	if FileOf(weak_king) == FileOf(pawn) &&
+		relative_rank(strong, weak_king) > relative_rank(strong, pawn) &&
+		(isSqDark(weak_king) != isSqDark(strong_bishop) ||
+			relative_rank(strong, weak_king) <= Rank6) {
+		return ScaleFactorDraw
+	}
+
+	if isSqDark(strong_bishop) != isSqDark(weak_bishop) {
+		return ScaleFactorNormal / 8
+	}
+	return ScaleFactorNormal
+}
@@ -0,0 +1,55 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/Sidhant-Roymoulik/Light-Blue/chess"
+)
+
+func TestKPKEndgame(t *testing.T) {
+	// King and pawn against king positions with a known result
+	tests := []struct {
+		name string
+		fen  string
+		win  bool
+	}{
+		{"King in front of the pawn", "4k3/8/4K3/4P3/8/8/8/8 w - - 0 1", true},
+		{"Pawn in front of the king", "4k3/8/4P3/4K3/8/8/8/8 w - - 0 1", false},
+		{"Opposition to move", "4k3/8/8/4K3/4P3/8/8/8 w - - 0 1", true},
+		{"Opposition not to move", "4k3/8/8/4K3/4P3/8/8/8 b - - 0 1", false},
+		{"Rook pawn", "k7/8/8/8/8/8/P7/K7 w - - 0 1", false},
+		{"Unstoppable pawn", "8/4P3/8/8/8/8/k7/4K3 w - - 0 1", true},
+		{"Black pawn escorted by the king", "8/8/8/8/4p3/4k3/8/4K3 b - - 0 1", true},
+		{"Black opposition to move", "8/8/8/4p3/4k3/8/8/4K3 b - - 0 1", true},
+		{"Black opposition not to move", "8/8/8/4p3/4k3/8/8/4K3 w - - 0 1", false},
+	}
+
+	for _, test := range tests {
+		eval := eval_pos(game_from_fen(test.fen).Position())
+		if (eval != 0) != test.win {
+			t.Errorf("%s: got %d for %s, expected win %t", test.name, eval, test.fen, test.win)
+		}
+	}
+}
+
+func TestKBNKEndgame(t *testing.T) {
+	// The bishop and knight mate drives the king to a corner of the
+	// bishop's color
+	right := eval_pos(game_from_fen("k7/8/2K5/8/8/8/8/5BN1 w - - 0 1").Position())
+	wrong := eval_pos(game_from_fen("7k/8/5K2/8/8/8/8/5BN1 w - - 0 1").Position())
+	if right <= wrong {
+		t.Errorf("got %d in the bishop's corner and %d in the other corner", right, wrong)
+	}
+}
+
+func TestKQKREndgame(t *testing.T) {
+	// Queen against rook is winning for either color
+	white := eval_pos(game_from_fen("8/8/8/3k4/8/8/3r4/Q3K3 w - - 0 1").Position())
+	black := eval_pos(game_from_fen("q3k3/3R4/8/8/3K4/8/8/8 b - - 0 1").Position())
+	if white != black {
+		t.Errorf("got %d for white and %d for black", white, black)
+	}
+	if margin := PVM_EG[chess.Queen] - PVM_EG[chess.Rook]; white < margin {
+		t.Errorf("got %d, expected at least %d", white, margin)
+	}
+}
@@ -1,7 +1,7 @@
 package engine
 
 import (
-	"fmt"
+	"strings"
 	"time"
 
 	"github.com/Sidhant-Roymoulik/Light-Blue/chess"
@@ -13,12 +13,30 @@ type Engine struct {
 	start             time.Time
 	counters          EngineCounters
 	timer             TimeManager
-	tt                TransTable[SearchEntry]
+	tt                TransTable[SearchEntry, *SearchEntry]
 	age               uint8
 	zobristHistory    [1024]uint64
 	zobristHistoryPly uint16
 	prev_guess        int
-	killer_moves      [100][2]*chess.Move
+	killer_moves      [MAX_DEPTH][2]chess.Move
+	thread_id         int
+	helpers           []*Engine
+	nodes_published   uint64
+	excluded_moves    [MAX_DEPTH]*chess.Move
+	move_stack        [MAX_DEPTH]*chess.Move
+	history           [2][64][64]int
+	counter_moves     [64][64]chess.Move
+	multi_pv          int
+	root_excluded     []*chess.Move
+	ponder_move       *chess.Move
+	root_moves        []*chess.Move
+	mate_search       int
+	pickers           [MAX_PLY]move_picker
+	tb_hits_published uint64
+	eval              EvalBackend
+	pawn_tt           TransTable[PawnEntry, *PawnEntry]
+	pawn_tt_size      uint64
+	notation          chess.UCINotation
 }
 
 type EngineClass struct {
@@ -29,19 +47,26 @@ type EngineClass struct {
 
 type EngineUpgrades struct {
 	iterative_deepening bool
+	singular_extensions bool
 }
 
 type EngineCounters struct {
-	nodes_searched   uint64
-	q_nodes_searched uint64
-	hashes_used      uint64
-	check_extensions uint64
-	smp_pruned       uint64
-	nmp_pruned       uint64
-	razor_pruned     uint64
-	iid_move_found   uint64
-	lmp_pruned       uint64
-	futility_pruned  uint64
+	nodes_searched      uint64
+	q_nodes_searched    uint64
+	hashes_used         uint64
+	check_extensions    uint64
+	smp_pruned          uint64
+	nmp_pruned          uint64
+	razor_pruned        uint64
+	iid_move_found      uint64
+	lmp_pruned          uint64
+	futility_pruned     uint64
+	lmr_reduced         uint64
+	singular_extensions uint64
+	see_pruned          uint64
+	tb_hits             uint64
+	pawn_hash_probes    uint64
+	pawn_hash_hits      uint64
 }
 
 // -----------------------------------------------------------------------------
@@ -62,6 +87,11 @@ func (pvLine *PVLine) clear() {
 // and a new line of best play after the best move.
 func (pvLine *PVLine) update(move *chess.Move, newPVLine PVLine) {
 	pvLine.clear()
+	if move != nil {
+		// Copy the move, since the move picker reuses its buffer
+		pv_move := *move
+		move = &pv_move
+	}
 	pvLine.Moves = append(pvLine.Moves, move)
 	pvLine.Moves = append(pvLine.Moves, newPVLine.Moves...)
 }
@@ -74,9 +104,22 @@ func (pvLine *PVLine) getPVMove() *chess.Move {
 	return pvLine.Moves[0]
 }
 
-func (pvLine PVLine) String() string {
-	pv := fmt.Sprintf("%s", pvLine.Moves)
-	return pv[1 : len(pv)-1]
+// Get the expected reply to the best move from the principal variation line.
+func (pvLine *PVLine) getPonderMove() *chess.Move {
+	if len(pvLine.Moves) < 2 {
+		return nil
+	}
+	return pvLine.Moves[1]
+}
+
+// Get the moves of the principal variation line in the given notation,
+// separated by spaces.
+func (pvLine PVLine) format(notation chess.UCINotation) string {
+	moves := make([]string, len(pvLine.Moves))
+	for i, move := range pvLine.Moves {
+		moves[i] = notation.Encode(nil, move)
+	}
+	return strings.Join(moves, " ")
 }
 
 // -----------------------------------------------------------------------------
@@ -96,6 +139,11 @@ func (e *Engine) printSearchStats() {
 	print("Razor Prunes:", e.counters.razor_pruned)
 	print("Futility Prunes:", e.counters.futility_pruned)
 	print("IID Moves Found:", e.counters.iid_move_found)
+	print("LMR Reductions:", e.counters.lmr_reduced)
+	print("Singular Extensions:", e.counters.singular_extensions)
+	print("SEE Prunes:", e.counters.see_pruned)
+	print("Tablebase Hits:", e.counters.tb_hits)
+	print("Pawn Hash Hits:", e.counters.pawn_hash_hits, "/", e.counters.pawn_hash_probes)
 }
 
 func (e *Engine) setBenchmarkMode(ply int) {
@@ -114,12 +162,36 @@ func (e *Engine) resetCounters() {
 	e.counters.razor_pruned = 0
 	e.counters.futility_pruned = 0
 	e.counters.iid_move_found = 0
+	e.counters.lmr_reduced = 0
+	e.counters.singular_extensions = 0
+	e.counters.see_pruned = 0
+	e.counters.tb_hits = 0
+	e.counters.pawn_hash_probes = 0
+	e.counters.pawn_hash_hits = 0
 }
 
 func (e *Engine) resizeTT(sizeInMB uint64, entrySize uint64) {
 	e.tt.Resize(sizeInMB, entrySize)
 }
 
+// Resize the pawn hash table of this thread and its helpers.
+func (e *Engine) resizePawnTT(sizeInMB uint64) {
+	e.pawn_tt_size = sizeInMB
+	e.pawn_tt.Resize(sizeInMB, PawnEntrySize)
+	for _, helper := range e.helpers {
+		helper.resizePawnTT(sizeInMB)
+	}
+}
+
+// Clear the pawn hash table of this thread and its helpers, which must be
+// done when the evaluation parameters change.
+func (e *Engine) clearPawnTT() {
+	e.pawn_tt.Clear()
+	for _, helper := range e.helpers {
+		helper.clearPawnTT()
+	}
+}
+
 func (e *Engine) clearTT() {
 	e.tt.Clear()
 }
@@ -135,8 +207,10 @@ func (e *Engine) reset() {
 
 	e.resetCounters()
 	e.resetKillerMoves()
+	e.resetHistory()
 	e.resetZobrist()
 	e.tt.Clear()
 
 	e.resizeTT(DefaultTTSize, SearchEntrySize)
+	e.resizePawnTT(e.pawn_tt_size)
 }
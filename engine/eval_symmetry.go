@@ -0,0 +1,191 @@
+package engine
+
+import (
+	"bufio"
+	"fmt"
+	"math/rand"
+	"os"
+
+	"github.com/Sidhant-Roymoulik/Light-Blue/chess"
+	"github.com/bndr/gotabulate"
+)
+
+// eval_symmetry.go contains a check that the evaluation treats both colors
+// the same. A position and its color-flipped mirror must get the same
+// evaluation from the side to move, and every term of each color must match
+// the term of the other color in the mirror, which catches color bugs in the
+// FLIP tables and the rest of the evaluation.
+
+const (
+	DefaultSymmetryPositions int   = 10000
+	SymmetryPlayoutPlies     int   = 160
+	SymmetryPlayoutSeed      int64 = 1
+	SymmetryPrintMismatches  int   = 10
+)
+
+// A position whose evaluation doesn't match its mirror's.
+type symmetry_mismatch struct {
+	position *chess.Position
+	mirror   *chess.Position
+	trace    EvalTrace
+	flipped  EvalTrace
+}
+
+// -----------------------------------------------------------------------------
+// 		Symmetry Check
+// -----------------------------------------------------------------------------
+
+// Check the evaluation of the positions in the EPD file, or of positions from
+// random playouts if there's no file, against their mirrors. Prints the
+// first mismatches with the terms that differ, and returns how many
+// positions didn't match.
+func CheckEvalSymmetry(path string) (int, error) {
+	var positions []*chess.Position
+	if path == "" {
+		positions = playout_positions(DefaultSymmetryPositions, SymmetryPlayoutSeed)
+	} else {
+		var err error
+		if positions, err = load_epd_positions(path); err != nil {
+			return 0, err
+		}
+	}
+
+	mismatches := eval_symmetry_mismatches(positions)
+	for i := 0; i < Min(len(mismatches), SymmetryPrintMismatches); i++ {
+		print_symmetry_mismatch(mismatches[i])
+	}
+	print("Eval Symmetry:", len(mismatches), "mismatches in", len(positions), "positions")
+
+	return len(mismatches), nil
+}
+
+// Get the positions whose evaluation doesn't match their mirror's.
+func eval_symmetry_mismatches(positions []*chess.Position) []symmetry_mismatch {
+	mismatches := []symmetry_mismatch{}
+
+	for _, position := range positions {
+		mirror := position.FlipColors()
+		trace, flipped := TraceEval(position), TraceEval(mirror)
+
+		if eval_pos(position) != eval_pos(mirror) || !traces_mirrored(trace, flipped) {
+			mismatches = append(mismatches, symmetry_mismatch{position, mirror, trace, flipped})
+		}
+	}
+
+	return mismatches
+}
+
+// Check that the trace of a mirror has the terms of the original with the
+// colors swapped. Totals of traces are from white's side, so they flip sign.
+func traces_mirrored(trace EvalTrace, flipped EvalTrace) bool {
+	if trace.Eval != -flipped.Eval || trace.Note != flipped.Note {
+		return false
+	}
+	for term := 0; term < TermCount; term++ {
+		if trace.Terms[term][chess.White] != flipped.Terms[term][chess.Black] ||
+			trace.Terms[term][chess.Black] != flipped.Terms[term][chess.White] {
+			return false
+		}
+	}
+	return true
+}
+
+// Print the positions of a mismatch and a table of the terms that differ,
+// comparing each color with the other color of the mirror.
+func print_symmetry_mismatch(mismatch symmetry_mismatch) {
+	print("Position:", mismatch.position)
+	print("Mirror:  ", mismatch.mirror)
+	print("Evaluation:", mismatch.trace.Eval, "mirrored:", -mismatch.flipped.Eval, "(white side)")
+	if mismatch.trace.Note != mismatch.flipped.Note {
+		print("Notes:", mismatch.trace.Note, "/", mismatch.flipped.Note)
+	}
+
+	rows := [][]interface{}{}
+	for term := 0; term < TermCount; term++ {
+		for color := chess.White; color <= chess.Black; color++ {
+			score := mismatch.trace.Terms[term][color]
+			mirrored := mismatch.flipped.Terms[term][color.Other()]
+			if score == mirrored {
+				continue
+			}
+			rows = append(rows, []interface{}{
+				TermNames[term],
+				color.Name(),
+				score.MG, score.EG,
+				mirrored.MG, mirrored.EG,
+			})
+		}
+	}
+	if len(rows) == 0 {
+		return
+	}
+
+	t := gotabulate.Create(rows)
+	t.SetHeaders([]string{
+		"Term",
+		"Color",
+		"MG",
+		"EG",
+		"Mirrored MG",
+		"Mirrored EG",
+	})
+	fmt.Println(t.Render("grid"))
+}
+
+// -----------------------------------------------------------------------------
+// 		Positions
+// -----------------------------------------------------------------------------
+
+// Load the positions of an EPD file, skipping lines without a valid FEN.
+func load_epd_positions(path string) ([]*chess.Position, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	positions := []*chess.Position{}
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fen, ok := parse_epd_fen(scanner.Text())
+		if !ok {
+			continue
+		}
+
+		fen_option, err := chess.FEN(fen)
+		if err != nil {
+			continue
+		}
+		positions = append(positions, chess.NewGame(fen_option).Position().Copy())
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(positions) == 0 {
+		return nil, fmt.Errorf("no positions in %s", path)
+	}
+	return positions, nil
+}
+
+// Get positions from games of random moves from the starting position, so
+// every phase of the game and many endgames are covered.
+func playout_positions(count int, seed int64) []*chess.Position {
+	random := rand.New(rand.NewSource(seed))
+	positions := make([]*chess.Position, 0, count)
+
+	for len(positions) < count {
+		position := chess.StartingPosition().Copy()
+		for ply := 0; ply < SymmetryPlayoutPlies && len(positions) < count; ply++ {
+			moves := position.ValidMoves()
+			if len(moves) == 0 {
+				break
+			}
+			position.MakeMove(moves[random.Intn(len(moves))])
+			positions = append(positions, position.Copy())
+		}
+	}
+
+	return positions
+}
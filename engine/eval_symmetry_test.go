@@ -0,0 +1,48 @@
+package engine
+
+import "testing"
+
+// The number of playout positions checked with go test -short.
+const symmetryShortPositions = 1000
+
+func TestFlipColors(t *testing.T) {
+	fens := []string{
+		"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1",
+		"r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w Kq - 0 1",
+		"rnbqkbnr/ppp1p1pp/8/3pPp2/8/8/PPPP1PPP/RNBQKBNR w KQkq f6 0 3",
+		"8/2p5/3p4/KP5r/1R3p1k/8/4P1P1/8 b - - 3 40",
+	}
+
+	// Flipping twice must give back the position, and the flipped position
+	// must match the one parsed from its FEN
+	for _, fen := range fens {
+		position := game_from_fen(fen).Position()
+		mirror := position.FlipColors()
+
+		if back := mirror.FlipColors(); back.String() != fen || back.Key() != position.Key() {
+			t.Errorf("%s flipped back to %s", fen, back)
+		}
+		parsed := game_from_fen(mirror.String()).Position()
+		if parsed.Key() != mirror.Key() || parsed.InCheck() != mirror.InCheck() {
+			t.Errorf("%s flipped to %s, which doesn't match its FEN", fen, mirror)
+		}
+	}
+}
+
+func TestEvalSymmetry(t *testing.T) {
+	count := DefaultSymmetryPositions
+	if testing.Short() {
+		count = symmetryShortPositions
+	}
+
+	positions := playout_positions(count, SymmetryPlayoutSeed)
+	mismatches := eval_symmetry_mismatches(positions)
+	for i := 0; i < Min(len(mismatches), SymmetryPrintMismatches); i++ {
+		mismatch := mismatches[i]
+		t.Errorf("%s evaluates to %d, its mirror %s to %d (white side)",
+			mismatch.position, mismatch.trace.Eval, mismatch.mirror, -mismatch.flipped.Eval)
+	}
+	if len(mismatches) != 0 {
+		t.Errorf("%d of %d positions don't match their mirrors", len(mismatches), len(positions))
+	}
+}
@@ -0,0 +1,149 @@
+package engine
+
+import (
+	"fmt"
+
+	"github.com/Sidhant-Roymoulik/Light-Blue/chess"
+	"github.com/bndr/gotabulate"
+)
+
+// eval_trace.go contains the evaluation trace, which splits the handcrafted
+// evaluation of a position into its terms, for the eval command and tests.
+
+// The terms of the evaluation.
+const (
+	TermMaterial int = iota
+	TermPST
+	TermPawnStructure
+	TermPassedPawns
+	TermMobilityKnight
+	TermMobilityBishop
+	TermMobilityRook
+	TermMobilityQueen
+	TermOutposts
+	TermRooksAndQueens
+	TermKingSafety
+	TermBishopPair
+	TermTempo
+	TermCount
+)
+
+var TermNames = [TermCount]string{
+	"Material",
+	"PST",
+	"Pawn Structure",
+	"Passed Pawns",
+	"Knight Mobility",
+	"Bishop Mobility",
+	"Rook Mobility",
+	"Queen Mobility",
+	"Outposts",
+	"Rooks + Queens",
+	"King Safety",
+	"Bishop Pair",
+	"Tempo",
+}
+
+// A middlegame and endgame score.
+type Score struct {
+	MG int
+	EG int
+}
+
+// The evaluation of a position split into its terms. Totals are from
+// white's perspective.
+type EvalTrace struct {
+	// Scores by term and color
+	Terms [TermCount][2]Score
+
+	// The game phase, from 0 in the opening to 256 in the endgame
+	Phase int
+
+	// The middlegame and endgame totals, and the score tapered by phase
+	// before and after scaling drawish endgames
+	Total   Score
+	Tapered int
+	Scaled  int
+
+	// The final evaluation
+	Eval int
+
+	// Why the terms were skipped, if the evaluation returned early
+	Note string
+}
+
+// Evaluate the position, keeping every term of the evaluation.
+func TraceEval(position *chess.Position) EvalTrace {
+	trace := EvalTrace{}
+	ev := Evaluator{trace: &trace}
+
+	trace.Eval = ev.Evaluate(position)
+	if position.Turn() == chess.Black {
+		trace.Eval = -trace.Eval
+	}
+
+	return trace
+}
+
+// Add the scores of a term for a color.
+func (ev *Evaluator) add(color chess.Color, term int, mg int, eg int) {
+	ev.score_mg[color] += mg
+	ev.score_eg[color] += eg
+
+	if ev.trace != nil {
+		ev.trace.Terms[term][color].MG += mg
+		ev.trace.Terms[term][color].EG += eg
+	}
+}
+
+// Keep the phase and scores the evaluation was tapered and scaled with.
+func (trace *EvalTrace) record(turn chess.Color, phase int, mg int, eg int, tapered int, scaled int) {
+	if turn == chess.Black {
+		mg, eg, tapered, scaled = -mg, -eg, -tapered, -scaled
+	}
+	trace.Phase = phase
+	trace.Total = Score{mg, eg}
+	trace.Tapered = tapered
+	trace.Scaled = scaled
+}
+
+// Print the terms of the evaluation as a table.
+func print_eval_trace(trace EvalTrace) {
+	if trace.Note != "" {
+		print(trace.Note)
+		print("Final evaluation:", trace.Eval, "(white side)")
+		return
+	}
+
+	rows := [][]interface{}{}
+	for term := 0; term < TermCount; term++ {
+		white := trace.Terms[term][chess.White]
+		black := trace.Terms[term][chess.Black]
+		rows = append(rows, []interface{}{
+			TermNames[term],
+			white.MG, white.EG,
+			black.MG, black.EG,
+			white.MG - black.MG, white.EG - black.EG,
+		})
+	}
+	rows = append(rows, []interface{}{
+		"Total", "", "", "", "", trace.Total.MG, trace.Total.EG,
+	})
+
+	t := gotabulate.Create(rows)
+	t.SetHeaders([]string{
+		"Term",
+		"White MG",
+		"White EG",
+		"Black MG",
+		"Black EG",
+		"Total MG",
+		"Total EG",
+	})
+	fmt.Println(t.Render("grid"))
+
+	print("Phase:", trace.Phase, "/ 256")
+	print("Tapered evaluation:", trace.Tapered)
+	print("Scaled evaluation:", trace.Scaled)
+	print("Final evaluation:", trace.Eval, "(white side)")
+}
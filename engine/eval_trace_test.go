@@ -0,0 +1,52 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/Sidhant-Roymoulik/Light-Blue/chess"
+)
+
+func TestTraceEval(t *testing.T) {
+	tests := []struct {
+		name string
+		fen  string
+		note bool
+	}{
+		{"Start position", "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1", false},
+		{"Italian game", "r1bqk2r/pppp1ppp/2n2n2/2b1p3/2B1P3/5N2/PPPP1PPP/RNBQK2R w KQkq - 4 4", false},
+		{"Kiwipete black to move", "r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R b KQkq - 0 1", false},
+		{"Rook endgame", "8/2p5/3p4/KP5r/1R3p1k/8/4P1P1/8 w - - 0 1", false},
+		{"Specialised endgame", "8/8/8/4k3/8/8/8/KBN5 w - - 0 1", true},
+	}
+
+	for _, test := range tests {
+		position := game_from_fen(test.fen).Position()
+		trace := TraceEval(position)
+
+		// The trace must give the same evaluation as eval_pos, from white's
+		// perspective
+		eval := eval_pos(position)
+		if position.Turn() == chess.Black {
+			eval = -eval
+		}
+		if trace.Eval != eval {
+			t.Errorf("%s: traced %d, evaluated %d", test.name, trace.Eval, eval)
+		}
+		if (trace.Note != "") != test.note {
+			t.Errorf("%s: got note %q", test.name, trace.Note)
+		}
+		if trace.Note != "" {
+			continue
+		}
+
+		// The terms must add up to the totals
+		total := Score{}
+		for term := 0; term < TermCount; term++ {
+			total.MG += trace.Terms[term][chess.White].MG - trace.Terms[term][chess.Black].MG
+			total.EG += trace.Terms[term][chess.White].EG - trace.Terms[term][chess.Black].EG
+		}
+		if total != trace.Total {
+			t.Errorf("%s: terms add up to %v, not %v", test.name, total, trace.Total)
+		}
+	}
+}
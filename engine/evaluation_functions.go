@@ -4,19 +4,27 @@ import (
 	"github.com/Sidhant-Roymoulik/Light-Blue/chess"
 )
 
-var score_mg []int = []int{0, 0}
-var score_eg []int = []int{0, 0}
-
-var pieces [2][7]chess.Bitboard = [2][7]chess.Bitboard{
-	{0, 0, 0, 0, 0, 0, 0},
-	{0, 0, 0, 0, 0, 0, 0},
+// An Evaluator holds the scratch state used while evaluating a position.
+// Every call to eval_pos uses its own Evaluator, so positions can be
+// evaluated from many goroutines at once.
+type Evaluator struct {
+	score_mg           [2]int
+	score_eg           [2]int
+	pieces             [2][7]chess.Bitboard
+	king_zones         [2]KingZone
+	king_attack_points [2]int
+	king_attackers     [2]int
+	passed_pawns       [2]chess.Bitboard
+	pawn_tt            *TransTable[PawnEntry, *PawnEntry]
+	counters           *EngineCounters
+	trace              *EvalTrace
 }
 
 // -----------------------------------------------------------------------------
 // 		Bonuses + Penalties
 // -----------------------------------------------------------------------------
 
-const (
+var (
 	IsolatedPawnPenatlyMG int = 17
 	IsolatedPawnPenatlyEG int = 6
 
@@ -39,10 +47,10 @@ const (
 	SemiOpenFileNextToKingPenalty int = 4
 
 	TempoBonusMG int = 14
-
-	DrawishScaleFactor int = 16
 )
 
+const DrawishScaleFactor int = 16
+
 // -----------------------------------------------------------------------------
 // 		Tapered Evaluation Values
 // -----------------------------------------------------------------------------
@@ -73,10 +81,7 @@ type KingZone struct {
 // 		King Safety Stuff
 // -----------------------------------------------------------------------------
 
-var KingZones [2]KingZone
 var KingZonesMasks [64]KingZone
-var KingAttackPoints [2]int
-var KingAttackers [2]int
 
 var OuterRingAttackPoints = []int{0, 0, 1, 1, 0, 1}
 
@@ -319,9 +324,47 @@ var PassedPawn_EG = [64]int{
 
 // Best Evaluation
 func eval_pos(position *chess.Position) int {
-	board := position.Board()
+	var ev Evaluator
+	return ev.Evaluate(position)
+}
+
+// An EvalBackend evaluates positions during search. A backend is told about
+// every position the search reaches, so it can keep state that's updated
+// from the position one ply before, instead of being computed from scratch.
+type EvalBackend interface {
+	update(position *chess.Position, ply int)
+	evaluate(position *chess.Position, ply int) int
+}
+
+// The handcrafted evaluation, which only keeps the pawn hash table of its
+// thread between positions.
+type HCE struct {
+	pawn_tt  *TransTable[PawnEntry, *PawnEntry]
+	counters *EngineCounters
+}
 
-	pieces = [2][7]chess.Bitboard{
+func (hce HCE) update(position *chess.Position, ply int) {}
+
+func (hce HCE) evaluate(position *chess.Position, ply int) int {
+	ev := Evaluator{pawn_tt: hce.pawn_tt, counters: hce.counters}
+	return ev.Evaluate(position)
+}
+
+// Get the backend the search should use, keeping the current one if it
+// still fits the options.
+func (e *Engine) select_eval_backend() EvalBackend {
+	if !UseNNUE || nnue_network == nil {
+		return HCE{&e.pawn_tt, &e.counters}
+	}
+	if nnue, ok := e.eval.(*NNUE); ok && nnue.network == nnue_network {
+		return nnue
+	}
+	return new_nnue(nnue_network)
+}
+
+// Get the bitboards of the board by color and piece type.
+func board_pieces(board *chess.Board) [2][7]chess.Bitboard {
+	return [2][7]chess.Bitboard{
 		{
 			0,
 			board.BBWhiteKing,
@@ -341,19 +384,37 @@ func eval_pos(position *chess.Position) int {
 			board.BBBlackPawn,
 		},
 	}
+}
+
+// Evaluate the position from the perspective of the side to move.
+func (ev *Evaluator) Evaluate(position *chess.Position) int {
+	board := position.Board()
+
+	ev.pieces = board_pieces(board)
 
 	// Draw by Insufficient Material
-	if is_draw(&pieces) {
+	if is_draw(&ev.pieces) {
+		if ev.trace != nil {
+			ev.trace.Note = "Draw by insufficient material"
+		}
 		return 0
 	}
 
-	score_mg = []int{0, 0}
-	score_eg = []int{0, 0}
-
 	turn := position.Turn()
 
+	// Known Endgames
+	if eval, ok := ev.eval_endgame(turn); ok {
+		if ev.trace != nil {
+			ev.trace.Note = "Known endgame"
+		}
+		return eval
+	}
+
 	sides := [2]chess.Bitboard{board.WhiteSqs, board.BlackSqs}
 
+	// Pawn Structure
+	ev.eval_pawns(position)
+
 	squares := board.SquareMap()
 	all_bb := sides[chess.White] | sides[chess.Black]
 
@@ -362,45 +423,24 @@ func eval_pos(position *chess.Position) int {
 		piece := squares[chess.Square(square)]
 		color := piece.Color()
 
-		score_mg[color] += PVM_MG[piece.Type()]
-		score_mg[color] += PST_MG[piece.Type()][FLIP[color][square]]
-
-		score_eg[color] += PVM_EG[piece.Type()]
-		score_eg[color] += PST_EG[piece.Type()][FLIP[color][square]]
+		ev.add(color, TermMaterial, PVM_MG[piece.Type()], PVM_EG[piece.Type()])
+		ev.add(
+			color,
+			TermPST,
+			PST_MG[piece.Type()][FLIP[color][square]],
+			PST_EG[piece.Type()][FLIP[color][square]],
+		)
 
 		switch piece.Type() {
-		case chess.Pawn:
-			ally := pieces[color][chess.Pawn]
-			enemy := pieces[color^1][chess.Pawn]
-
-			// Isolated Pawns
-			if IsolatedPawnMasks[FileOf(square)]&ally != 0 {
-				score_mg[color] -= IsolatedPawnPenatlyMG
-				score_eg[color] -= IsolatedPawnPenatlyEG
-			}
-
-			// Doubled Pawns
-			if DoubledPawnMasks[color][square]&ally != 0 {
-				score_mg[color] -= DoubledPawnPenatlyMG
-				score_eg[color] -= DoubledPawnPenatlyEG
-			} else {
-				// Check for Passed Pawn only if not Doubled
-				if PassedPawnMasks[color][square]&enemy == 0 {
-					score_mg[color] += PassedPawn_MG[FLIP[color][square]]
-					score_eg[color] += PassedPawn_EG[FLIP[color][square]]
-				}
-			}
-
 		case chess.Knight:
-			ally := pieces[color][chess.Pawn]
-			enemy := pieces[color^1][chess.Pawn]
+			ally := ev.pieces[color][chess.Pawn]
+			enemy := ev.pieces[color^1][chess.Pawn]
 
 			// Check for Outposts
 			if OutpostMasks[color][square]&enemy == 0 &&
 				PawnAttacks[color][square]&ally != 0 &&
 				FlipRank[color][RankOf(square)] >= chess.Rank5 {
-				score_mg[color] += KnightOnOutpostBonusMG
-				score_eg[color] += KnightOnOutpostBonusEG
+				ev.add(color, TermOutposts, KnightOnOutpostBonusMG, KnightOnOutpostBonusEG)
 			}
 
 			moves := chess.BBKnightMoves[square] & ^sides[color]
@@ -414,31 +454,34 @@ func eval_pos(position *chess.Position) int {
 			}
 
 			mobility := safe_moves.CountBits()
-			score_mg[color] += (mobility - 4) * Mobility_MG[chess.Knight]
-			score_eg[color] += (mobility - 4) * Mobility_EG[chess.Knight]
+			ev.add(
+				color,
+				TermMobilityKnight,
+				(mobility-4)*Mobility_MG[chess.Knight],
+				(mobility-4)*Mobility_EG[chess.Knight],
+			)
 
 			// King Attacks
-			outer_ring_attacks := moves & KingZones[color^1].OuterRing
-			inner_ring_attacks := moves & KingZones[color^1].InnerRing
+			outer_ring_attacks := moves & ev.king_zones[color^1].OuterRing
+			inner_ring_attacks := moves & ev.king_zones[color^1].InnerRing
 
 			if outer_ring_attacks > 0 || inner_ring_attacks > 0 {
-				KingAttackers[color]++
-				KingAttackPoints[color] += outer_ring_attacks.CountBits() *
+				ev.king_attackers[color]++
+				ev.king_attack_points[color] += outer_ring_attacks.CountBits() *
 					OuterRingAttackPoints[chess.Knight]
-				KingAttackPoints[color] += inner_ring_attacks.CountBits() *
+				ev.king_attack_points[color] += inner_ring_attacks.CountBits() *
 					InnerRingAttackPoints[chess.Knight]
 			}
 
 		case chess.Bishop:
-			ally := pieces[color][chess.Pawn]
-			enemy := pieces[color^1][chess.Pawn]
+			ally := ev.pieces[color][chess.Pawn]
+			enemy := ev.pieces[color^1][chess.Pawn]
 
 			// Check for Outposts
 			if OutpostMasks[color][square]&enemy == 0 &&
 				PawnAttacks[color][square]&ally != 0 &&
 				FlipRank[color][RankOf(square)] >= chess.Rank5 {
-				score_mg[color] += BishopOutPostBonusMG
-				score_eg[color] += BishopOutPostBonusEG
+				ev.add(color, TermOutposts, BishopOutPostBonusMG, BishopOutPostBonusEG)
 			}
 
 			// Mobility Bonus
@@ -446,33 +489,37 @@ func eval_pos(position *chess.Position) int {
 			moves := chess.DiaAttack(full_bb, chess.Square(square)) & ^sides[color]
 
 			mobility := moves.CountBits()
-			score_mg[color] += (mobility - 7) * Mobility_MG[chess.Bishop]
-			score_eg[color] += (mobility - 7) * Mobility_EG[chess.Bishop]
+			ev.add(
+				color,
+				TermMobilityBishop,
+				(mobility-7)*Mobility_MG[chess.Bishop],
+				(mobility-7)*Mobility_EG[chess.Bishop],
+			)
 
 			// King Attacks
-			outer_ring_attacks := moves & KingZones[color^1].OuterRing
-			inner_ring_attacks := moves & KingZones[color^1].InnerRing
+			outer_ring_attacks := moves & ev.king_zones[color^1].OuterRing
+			inner_ring_attacks := moves & ev.king_zones[color^1].InnerRing
 
 			if outer_ring_attacks > 0 || inner_ring_attacks > 0 {
-				KingAttackers[color]++
-				KingAttackPoints[color] += outer_ring_attacks.CountBits() *
+				ev.king_attackers[color]++
+				ev.king_attack_points[color] += outer_ring_attacks.CountBits() *
 					OuterRingAttackPoints[chess.Bishop]
-				KingAttackPoints[color] += inner_ring_attacks.CountBits() *
+				ev.king_attack_points[color] += inner_ring_attacks.CountBits() *
 					InnerRingAttackPoints[chess.Bishop]
 			}
 
 		case chess.Rook:
 			// Seventh Rank Bonus
-			enemy_king := pieces[color^1][chess.King].Msb()
+			enemy_king := ev.pieces[color^1][chess.King].Msb()
 			if FlipRank[color][RankOf(square)] == chess.Rank7 &&
 				FlipRank[color][RankOf(enemy_king)] >= chess.Rank7 {
-				score_eg[color] += RookOrQueenOnSeventhBonusEG
+				ev.add(color, TermRooksAndQueens, 0, RookOrQueenOnSeventhBonusEG)
 			}
 
 			// Open File Bonus
-			pawns := pieces[color][chess.Pawn] | pieces[color^1][chess.Pawn]
+			pawns := ev.pieces[color][chess.Pawn] | ev.pieces[color^1][chess.Pawn]
 			if MaskFile[FileOf(square)]&pawns == 0 {
-				score_mg[color] += RookOnOpenFileBonusMG
+				ev.add(color, TermRooksAndQueens, RookOnOpenFileBonusMG, 0)
 			}
 
 			// Mobility Bonus
@@ -480,27 +527,31 @@ func eval_pos(position *chess.Position) int {
 			moves := chess.HvAttack(full_bb, chess.Square(square)) & ^sides[color]
 
 			mobility := moves.CountBits()
-			score_mg[color] += (mobility - 7) * Mobility_MG[chess.Rook]
-			score_eg[color] += (mobility - 7) * Mobility_EG[chess.Rook]
+			ev.add(
+				color,
+				TermMobilityRook,
+				(mobility-7)*Mobility_MG[chess.Rook],
+				(mobility-7)*Mobility_EG[chess.Rook],
+			)
 
 			// King Attacks
-			outer_ring_attacks := moves & KingZones[color^1].OuterRing
-			inner_ring_attacks := moves & KingZones[color^1].InnerRing
+			outer_ring_attacks := moves & ev.king_zones[color^1].OuterRing
+			inner_ring_attacks := moves & ev.king_zones[color^1].InnerRing
 
 			if outer_ring_attacks > 0 || inner_ring_attacks > 0 {
-				KingAttackers[color]++
-				KingAttackPoints[color] += outer_ring_attacks.CountBits() *
+				ev.king_attackers[color]++
+				ev.king_attack_points[color] += outer_ring_attacks.CountBits() *
 					OuterRingAttackPoints[chess.Rook]
-				KingAttackPoints[color] += inner_ring_attacks.CountBits() *
+				ev.king_attack_points[color] += inner_ring_attacks.CountBits() *
 					InnerRingAttackPoints[chess.Rook]
 			}
 
 		case chess.Queen:
 			// Seventh Rank Bonus
-			enemy_king := pieces[color^1][chess.King].Msb()
+			enemy_king := ev.pieces[color^1][chess.King].Msb()
 			if FlipRank[color][RankOf(square)] == chess.Rank7 &&
 				FlipRank[color][RankOf(enemy_king)] >= chess.Rank7 {
-				score_eg[color] += RookOrQueenOnSeventhBonusEG
+				ev.add(color, TermRooksAndQueens, 0, RookOrQueenOnSeventhBonusEG)
 			}
 
 			// Mobility Bonus
@@ -509,79 +560,149 @@ func eval_pos(position *chess.Position) int {
 				chess.HvAttack(full_bb, chess.Square(square))) & ^sides[color]
 
 			mobility := moves.CountBits()
-			score_mg[color] += (mobility - 14) * Mobility_MG[chess.Queen]
-			score_eg[color] += (mobility - 14) * Mobility_EG[chess.Queen]
+			ev.add(
+				color,
+				TermMobilityQueen,
+				(mobility-14)*Mobility_MG[chess.Queen],
+				(mobility-14)*Mobility_EG[chess.Queen],
+			)
 
 			// King Attacks
-			outer_ring_attacks := moves & KingZones[color^1].OuterRing
-			inner_ring_attacks := moves & KingZones[color^1].InnerRing
+			outer_ring_attacks := moves & ev.king_zones[color^1].OuterRing
+			inner_ring_attacks := moves & ev.king_zones[color^1].InnerRing
 
 			if outer_ring_attacks > 0 || inner_ring_attacks > 0 {
-				KingAttackers[color]++
-				KingAttackPoints[color] += outer_ring_attacks.CountBits() *
+				ev.king_attackers[color]++
+				ev.king_attack_points[color] += outer_ring_attacks.CountBits() *
 					OuterRingAttackPoints[chess.Queen]
-				KingAttackPoints[color] += inner_ring_attacks.CountBits() *
+				ev.king_attack_points[color] += inner_ring_attacks.CountBits() *
 					InnerRingAttackPoints[chess.Queen]
 			}
 		}
 	}
 
 	// King Evaluation
-	eval_king(&pieces, chess.White, uint8(board.WhiteKingSq))
-	eval_king(&pieces, chess.Black, uint8(board.BlackKingSq))
+	ev.eval_king(chess.White, uint8(board.WhiteKingSq))
+	ev.eval_king(chess.Black, uint8(board.BlackKingSq))
 
 	// Bishop Pair Bonus
-	if pieces[chess.White][chess.Bishop].CountBits() == 2 {
-		score_mg[chess.White] += BishopPairBonusMG
-		score_eg[chess.White] += BishopPairBonusEG
+	if ev.pieces[chess.White][chess.Bishop].CountBits() == 2 {
+		ev.add(chess.White, TermBishopPair, BishopPairBonusMG, BishopPairBonusEG)
 	}
-	if pieces[chess.Black][chess.Bishop].CountBits() == 2 {
-		score_mg[chess.Black] += BishopPairBonusMG
-		score_eg[chess.Black] += BishopPairBonusEG
+	if ev.pieces[chess.Black][chess.Bishop].CountBits() == 2 {
+		ev.add(chess.Black, TermBishopPair, BishopPairBonusMG, BishopPairBonusEG)
 	}
 
 	// Tempo Bonus
-	score_mg[turn] += TempoBonusMG
+	ev.add(turn, TermTempo, TempoBonusMG, 0)
 
 	// Tapered Evaluation
-	eval_mg := score_mg[turn] - score_mg[turn^1]
-	eval_eg := score_eg[turn] - score_eg[turn^1]
+	eval_mg := ev.score_mg[turn] - ev.score_mg[turn^1]
+	eval_eg := ev.score_eg[turn] - ev.score_eg[turn^1]
 
 	phase := TotalPhase
-	phase -= (pieces[chess.White][chess.Queen].CountBits() +
-		pieces[chess.Black][chess.Queen].CountBits()) * phases[chess.Queen]
-	phase -= (pieces[chess.White][chess.Rook].CountBits() +
-		pieces[chess.Black][chess.Rook].CountBits()) * phases[chess.Rook]
-	phase -= (pieces[chess.White][chess.Bishop].CountBits() +
-		pieces[chess.Black][chess.Bishop].CountBits()) * phases[chess.Bishop]
-	phase -= (pieces[chess.White][chess.Knight].CountBits() +
-		pieces[chess.Black][chess.Knight].CountBits()) * phases[chess.Knight]
-	phase -= (pieces[chess.White][chess.Pawn].CountBits() +
-		pieces[chess.Black][chess.Pawn].CountBits()) * phases[chess.Pawn]
+	phase -= (ev.pieces[chess.White][chess.Queen].CountBits() +
+		ev.pieces[chess.Black][chess.Queen].CountBits()) * phases[chess.Queen]
+	phase -= (ev.pieces[chess.White][chess.Rook].CountBits() +
+		ev.pieces[chess.Black][chess.Rook].CountBits()) * phases[chess.Rook]
+	phase -= (ev.pieces[chess.White][chess.Bishop].CountBits() +
+		ev.pieces[chess.Black][chess.Bishop].CountBits()) * phases[chess.Bishop]
+	phase -= (ev.pieces[chess.White][chess.Knight].CountBits() +
+		ev.pieces[chess.Black][chess.Knight].CountBits()) * phases[chess.Knight]
+	phase -= (ev.pieces[chess.White][chess.Pawn].CountBits() +
+		ev.pieces[chess.Black][chess.Pawn].CountBits()) * phases[chess.Pawn]
 
 	phase = (phase*256 + (TotalPhase / 2)) / TotalPhase
 
 	eval := ((eval_mg * (256 - phase)) + (eval_eg * phase)) / 256
 
 	// Check if position is likely a draw
-	if is_drawish(pieces) {
-		eval /= DrawishScaleFactor
+	scaled := ev.scale_endgame(turn, eval)
+
+	if ev.trace != nil {
+		ev.trace.record(turn, phase, eval_mg, eval_eg, eval, scaled)
 	}
 
-	return eval
+	return scaled
+}
+
+// Pawn Evaluation, which only depends on the pawns, so it's cached in the
+// pawn hash table if the evaluator has one.
+func (ev *Evaluator) eval_pawns(position *chess.Position) {
+	var hash uint64
+	if ev.pawn_tt != nil && ev.pawn_tt.size > 0 {
+		hash = position.PawnKey()
+		ev.counters.pawn_hash_probes++
+
+		if entry := ev.pawn_tt.Probe(hash); entry.Hash == hash {
+			ev.counters.pawn_hash_hits++
+			ev.add_pawn_entry(entry)
+			return
+		}
+	}
+
+	entry := PawnEntry{Hash: hash}
+
+	for color := chess.White; color <= chess.Black; color++ {
+		ally := ev.pieces[color][chess.Pawn]
+		enemy := ev.pieces[color^1][chess.Pawn]
+
+		pawns := ally
+		for pawns != 0 {
+			square := pawns.PopBit()
+
+			// Isolated Pawns
+			if IsolatedPawnMasks[FileOf(square)]&ally != 0 {
+				entry.Structure[color][0] -= int16(IsolatedPawnPenatlyMG)
+				entry.Structure[color][1] -= int16(IsolatedPawnPenatlyEG)
+			}
+
+			// Doubled Pawns
+			if DoubledPawnMasks[color][square]&ally != 0 {
+				entry.Structure[color][0] -= int16(DoubledPawnPenatlyMG)
+				entry.Structure[color][1] -= int16(DoubledPawnPenatlyEG)
+			} else {
+				// Check for Passed Pawn only if not Doubled
+				if PassedPawnMasks[color][square]&enemy == 0 {
+					entry.Passed[color] |= chess.SquareBB[square]
+					entry.PassedBonus[color][0] += int16(PassedPawn_MG[FLIP[color][square]])
+					entry.PassedBonus[color][1] += int16(PassedPawn_EG[FLIP[color][square]])
+				}
+			}
+		}
+	}
+
+	if ev.pawn_tt != nil && ev.pawn_tt.size > 0 {
+		*ev.pawn_tt.Store(hash, 0, 0) = entry
+	}
+	ev.add_pawn_entry(&entry)
+}
+
+func (ev *Evaluator) add_pawn_entry(entry *PawnEntry) {
+	for color := chess.White; color <= chess.Black; color++ {
+		ev.add(
+			color,
+			TermPawnStructure,
+			int(entry.Structure[color][0]),
+			int(entry.Structure[color][1]),
+		)
+		ev.add(
+			color,
+			TermPassedPawns,
+			int(entry.PassedBonus[color][0]),
+			int(entry.PassedBonus[color][1]),
+		)
+	}
+	ev.passed_pawns = entry.Passed
 }
 
 // King Evaluation
-func eval_king(
-	pieces *[2][7]chess.Bitboard,
-	color chess.Color,
-	square uint8,
-) {
-	enemyPoints := KingAttackPoints[color^1]
+func (ev *Evaluator) eval_king(color chess.Color, square uint8) {
+	enemyPoints := ev.king_attack_points[color^1]
 
 	// Evaluate semi-open files adjacent to the enemy king
 	kingFile := MaskFile[FileOf(square)]
-	ally := pieces[color][chess.Pawn]
+	ally := ev.pieces[color][chess.Pawn]
 
 	leftFile := ((kingFile & ClearFile[FileA]) << 1)
 	rightFile := ((kingFile & ClearFile[FileH]) >> 1)
@@ -601,8 +722,8 @@ func eval_king(
 	// Take all the king saftey points collected for the enemy,
 	// and see what kind of penatly we should get.
 	penatly := (enemyPoints * enemyPoints) / 4
-	if KingAttackers[color^1] >= 2 && pieces[color^1][chess.Queen] != 0 {
-		score_mg[color] -= penatly
+	if ev.king_attackers[color^1] >= 2 && ev.pieces[color^1][chess.Queen] != 0 {
+		ev.add(color, TermKingSafety, -penatly, 0)
 	}
 }
 
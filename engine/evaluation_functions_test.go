@@ -0,0 +1,38 @@
+package engine
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestPawnHash(t *testing.T) {
+	var pawn_tt TransTable[PawnEntry, *PawnEntry]
+	pawn_tt.Resize(1, PawnEntrySize)
+	counters := EngineCounters{}
+	hce := HCE{&pawn_tt, &counters}
+
+	// Evaluations with the pawn hash table must match the ones without it,
+	// both when the pawns are stored and when they're found again
+	random := rand.New(rand.NewSource(1))
+	for game := 0; game < 20; game++ {
+		position := game_from_opening("Start Position").Position().Copy()
+		for ply := 0; ply < 80; ply++ {
+			moves := position.ValidMoves()
+			if len(moves) == 0 {
+				break
+			}
+			position.MakeMove(moves[random.Intn(len(moves))])
+
+			for i := 0; i < 2; i++ {
+				if eval, expected := hce.evaluate(position, 0), eval_pos(position); eval != expected {
+					t.Fatalf("%s: got %d with the pawn hash table, %d without it", position, eval, expected)
+				}
+			}
+		}
+	}
+
+	// Every second probe finds the entry the first one stored
+	if counters.pawn_hash_hits*2 < counters.pawn_hash_probes {
+		t.Errorf("only %d hits in %d probes", counters.pawn_hash_hits, counters.pawn_hash_probes)
+	}
+}
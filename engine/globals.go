@@ -35,6 +35,8 @@ const (
 	TIME_LIMIT      time.Duration = 2 * time.Second // Time in sec
 	CHECKMATE_VALUE int           = 1000000
 	MATE_CUTOFF     int           = CHECKMATE_VALUE / 2
+	TB_WIN_VALUE    int           = MATE_CUTOFF - MAX_PLY
 	MAX_DEPTH       int           = 100
+	MAX_PLY         int           = 2 * MAX_DEPTH
 	TIMER_CHECK     uint64        = (1 << 10) - 1
 )
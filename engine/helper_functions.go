@@ -72,7 +72,7 @@ func getMateOrCPScore(score int) string {
 
 func abs(x int) int {
 	if x < 0 {
-		return -1
+		return -x
 	}
 	return x
 }
@@ -85,6 +85,14 @@ func RankOf(square uint8) uint8 {
 	return square / 8
 }
 
+// Get the number of king moves between the squares.
+func distance(s1 uint8, s2 uint8) int {
+	return Max(
+		abs(int(FileOf(s1))-int(FileOf(s2))),
+		abs(int(RankOf(s1))-int(RankOf(s2))),
+	)
+}
+
 func isSqDark(square uint8) bool {
 	return (square/8+square%8)%2 == 0
 }
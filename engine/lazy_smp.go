@@ -0,0 +1,144 @@
+package engine
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Sidhant-Roymoulik/Light-Blue/chess"
+)
+
+// lazy_smp.go contains the helper threads used for Lazy SMP. Every helper
+// searches its own copy of the root position with its own killer moves,
+// counters and zobrist history, and they only communicate through the
+// shared transposition table. The main thread is the only one that reports
+// info lines and the best move.
+//
+// https://www.chessprogramming.org/Lazy_SMP
+
+const (
+	DefaultThreads int = 1
+	MaxThreads     int = 256
+)
+
+// Set the number of search threads, including the main thread.
+func (e *Engine) setThreads(threads int) {
+	threads = Max(1, Min(threads, MaxThreads))
+
+	e.helpers = nil
+	for id := 1; id < threads; id++ {
+		helper := new_light_blue()
+		helper.thread_id = id
+		helper.resizePawnTT(e.pawn_tt_size)
+		e.helpers = append(e.helpers, &helper)
+	}
+}
+
+// Start every helper thread on the given root position. The returned wait
+// group is done once all helpers have stopped.
+func (e *Engine) startHelpers(position *chess.Position) *sync.WaitGroup {
+	wg := &sync.WaitGroup{}
+	if len(e.helpers) == 0 {
+		return wg
+	}
+
+	// Generate the root moves before copying the root position, so that the
+	// copies share the cached move list instead of each generating it.
+	position.ValidMoves()
+
+	for _, helper := range e.helpers {
+		// Copying the table shares its entries with the main thread. Entries
+		// are read and written atomically without locking, and an entry
+		// another thread is writing fails its hash check, so a thread never
+		// uses a score or depth from another position. The stored move is
+		// still checked to be playable before being used, since different
+		// positions can share a hash.
+		helper.tt = e.tt
+		helper.age = e.age
+		helper.prev_guess = e.prev_guess
+		helper.upgrades = e.upgrades
+		helper.root_moves = e.root_moves
+		helper.mate_search = e.mate_search
+		helper.zobristHistory = e.zobristHistory
+		helper.zobristHistoryPly = e.zobristHistoryPly
+		helper.eval = helper.select_eval_backend()
+
+		helper.resetCounters()
+		helper.resetKillerMoves()
+		atomic.StoreUint64(&helper.nodes_published, 0)
+		atomic.StoreUint64(&helper.tb_hits_published, 0)
+
+		// Helpers search until the main thread stops them.
+		helper.timer.Setup(
+			InfiniteTime,
+			NoValue,
+			NoValue,
+			int16(NoValue),
+			uint8(MAX_DEPTH),
+			math.MaxUint64,
+		)
+		helper.timer.Start()
+
+		wg.Add(1)
+		go helper.helperSearch(position.Copy(), wg)
+	}
+
+	return wg
+}
+
+// Stop every helper thread and wait for them to return.
+func (e *Engine) stopHelpers(wg *sync.WaitGroup) {
+	for _, helper := range e.helpers {
+		helper.timer.ForceStop()
+	}
+	wg.Wait()
+}
+
+// Iterative deepening loop run by a helper thread. Odd helpers start one ply
+// deeper than the rest so that the threads don't all search the same depth
+// at the same time.
+func (e *Engine) helperSearch(position *chess.Position, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	pvLine := PVLine{}
+
+	for depth := 1 + e.thread_id%2; depth <= MAX_DEPTH; depth++ {
+		pvLine.clear()
+
+		eval := e.aspiration_window(position, depth, &pvLine)
+
+		if e.timer.IsStopped() {
+			break
+		}
+
+		e.prev_guess = eval
+	}
+
+	e.publishNodes()
+}
+
+// Make this thread's node count and tablebase hits visible to the main
+// thread.
+func (e *Engine) publishNodes() {
+	atomic.StoreUint64(
+		&e.nodes_published,
+		e.counters.nodes_searched+e.counters.q_nodes_searched,
+	)
+	atomic.StoreUint64(&e.tb_hits_published, e.counters.tb_hits)
+}
+
+// Get the number of nodes searched by the helper threads so far.
+func (e *Engine) helperNodes() (nodes uint64) {
+	for _, helper := range e.helpers {
+		nodes += atomic.LoadUint64(&helper.nodes_published)
+	}
+	return nodes
+}
+
+// Get the number of tablebase hits of the helper threads so far.
+func (e *Engine) helperTBHits() (tb_hits uint64) {
+	for _, helper := range e.helpers {
+		tb_hits += atomic.LoadUint64(&helper.tb_hits_published)
+	}
+	return tb_hits
+}
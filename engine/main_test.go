@@ -0,0 +1,19 @@
+package engine
+
+import (
+	"os"
+	"testing"
+
+	"github.com/Sidhant-Roymoulik/Light-Blue/chess"
+)
+
+func TestMain(m *testing.M) {
+	chess.InitBitboards()
+	chess.InitZobrist()
+	InitTables()
+	InitSearchTables()
+	InitEvalBitboards()
+	InitEndgames()
+	InitSyzygyTables()
+	os.Exit(m.Run())
+}
@@ -8,9 +8,11 @@ import "github.com/Sidhant-Roymoulik/Light-Blue/chess"
 
 const (
 	MvvLvaOffset          int = 10000 - 256
-	PVMoveScore           int = 65
 	FirstKillerMoveScore  int = 10
 	SecondKillerMoveScore int = 20
+	CounterMoveScore      int = 30
+	MaxHistory            int = 8192
+	MaxHistoryBonus       int = 1200
 )
 
 // -----------------------------------------------------------------------------
@@ -32,77 +34,254 @@ func MVV_LVA(move *chess.Move, board *chess.Board) int {
 	return mvv_lva[board.Piece(move.S2()).Type()][board.Piece(move.S1()).Type()]
 }
 
+// A capture can only lose material when the capturing piece is worth more than
+// the captured one, so the exchange is only resolved in that case.
+func is_losing_capture(move *chess.Move, board *chess.Board) bool {
+	attacker := board.Piece(move.S1()).Type()
+	victim := board.Piece(move.S2()).Type()
+	return SEEPieceValues[attacker] > SEEPieceValues[victim] &&
+		SEE(move, board) < 0
+}
+
 // -----------------------------------------------------------------------------
-// 		Q-Move Stuff
+// 		Move Comparison
 // -----------------------------------------------------------------------------
 
-func is_q_move(move *chess.Move) bool {
-	return move.HasTag(chess.Capture) ||
-		move.HasTag(chess.EnPassant) ||
-		move.HasTag(chess.Check) ||
-		move.Promo() != chess.NoPieceType
+// Compare two moves by their squares and promotion, since moves generated in
+// different nodes are never the same pointer.
+func same_move(a *chess.Move, b *chess.Move) bool {
+	return a != nil && b != nil &&
+		a.S1() == b.S1() &&
+		a.S2() == b.S2() &&
+		a.Promo() == b.Promo()
 }
 
-func get_q_moves(position *chess.Position) []*chess.Move {
-	moves := position.ValidMoves()
-	n := 0
-	for _, move := range moves {
-		if is_q_move(move) {
-			moves[n] = move
-			n++
-		}
-	}
-	return moves[:n]
+// -----------------------------------------------------------------------------
+// 		Quiet Moves
+// -----------------------------------------------------------------------------
+
+func is_quiet(move *chess.Move) bool {
+	return !move.HasTag(chess.Capture) &&
+		!move.HasTag(chess.EnPassant) &&
+		move.Promo() == chess.NoPieceType
 }
 
 // -----------------------------------------------------------------------------
-// 		Best Move Picking
+// 		Staged Move Picking
 // -----------------------------------------------------------------------------
 
-type scored_move struct {
-	move *chess.Move
-	eval int
+// Stages of the move picker. The hash move is tried before any moves are
+// generated, and the quiet moves are only generated once every capture that
+// doesn't lose material has been tried without a cutoff. In check, every
+// evasion is generated and ordered at once instead.
+const (
+	StageHashMove int = iota
+	StageGenCaptures
+	StageGoodCaptures
+	StageGenQuiets
+	StageQuiets
+	StageBadCaptures
+	StageDone
+)
+
+// A move_picker generates the moves of one node into its own buffer, and
+// hands them out one at a time in order. Every ply of the search has its own
+// picker, so the moves it hands out stay valid until the node is finished.
+type move_picker struct {
+	stage         int
+	moves         [chess.MaxMoves]chess.Move
+	scores        [chess.MaxMoves]int
+	index         int
+	size          int
+	captures_end  int
+	bad_index     int
+	evasions      bool
+	captures_only bool
+	hash_move     chess.Move
+	has_hash_move bool
+	killer_moves  *[2]chess.Move
+	counter_move  *chess.Move
+	history       *[64][64]int
+
+	// Quiet moves searched without causing a cutoff, used to update the
+	// history scores
+	quiets_tried []*chess.Move
 }
 
-func get_move(moves []scored_move, start int) {
-	best_index := start
-	best_eval := moves[best_index].eval
-	for i := best_index; i < len(moves); i++ {
-		if moves[i].eval > best_eval {
-			best_index = i
-			best_eval = moves[i].eval
-		}
+// Prepare the picker for a node of the main search.
+func (picker *move_picker) reset(
+	position *chess.Position,
+	hash_move *chess.Move,
+	killer_moves *[2]chess.Move,
+	counter_move *chess.Move,
+	history *[64][64]int,
+) {
+	picker.stage = StageHashMove
+	picker.index, picker.size, picker.captures_end, picker.bad_index = 0, 0, 0, 0
+	picker.evasions = position.InCheck()
+	picker.captures_only = false
+	picker.has_hash_move = hash_move != nil
+	if hash_move != nil {
+		picker.hash_move = *hash_move
 	}
-	temp := moves[start]
-	moves[start] = moves[best_index]
-	moves[best_index] = temp
+	picker.killer_moves = killer_moves
+	picker.counter_move = counter_move
+	picker.history = history
+	picker.quiets_tried = picker.quiets_tried[:0]
+}
+
+// Prepare the picker for a node of the quiescence search, which only needs
+// the captures and promotions.
+func (picker *move_picker) reset_q() {
+	picker.stage = StageGenCaptures
+	picker.index, picker.size, picker.captures_end, picker.bad_index = 0, 0, 0, 0
+	picker.evasions = false
+	picker.captures_only = true
+	picker.has_hash_move = false
+	picker.killer_moves = nil
+	picker.counter_move = nil
+	picker.history = nil
 }
 
-// Adding Killer Moves
-func score_moves(moves []*chess.Move, board *chess.Board, killer_moves [2]*chess.Move, pv_move *chess.Move) []scored_move {
-	scores := make([]scored_move, len(moves))
-	for i := 0; i < len(moves); i++ {
-		if pv_move != nil &&
-			moves[i].S1() == pv_move.S1() &&
-			moves[i].S2() == pv_move.S2() {
-			scores[i] = scored_move{
-				moves[i], MvvLvaOffset + PVMoveScore,
+// Get the next move to search, or nil once every move has been handed out.
+// The moves are pseudo-legal, so they still have to be tested for legality
+// after being made.
+func (picker *move_picker) next(position *chess.Position) *chess.Move {
+	for {
+		switch picker.stage {
+		case StageHashMove:
+			picker.stage = StageGenCaptures
+			if picker.has_hash_move {
+				return &picker.hash_move
+			}
+
+		case StageGenCaptures:
+			var moves []chess.Move
+			if picker.evasions {
+				moves = position.GenerateEvasions(picker.moves[:0])
+			} else {
+				moves = position.GenerateCaptures(picker.moves[:0])
+			}
+			board := position.Board()
+			for i := range moves {
+				picker.scores[i] = picker.score(&moves[i], board)
+			}
+			picker.index = 0
+			picker.captures_end = len(moves)
+			picker.size = len(moves)
+			picker.stage = StageGoodCaptures
+
+		case StageGoodCaptures:
+			if picker.index == picker.captures_end {
+				picker.stage = StageGenQuiets
+				continue
+			}
+			picker.select_best(picker.captures_end)
+			// Every capture left loses material
+			if !picker.evasions && picker.scores[picker.index] < 0 {
+				picker.stage = StageGenQuiets
+				continue
+			}
+			if move := picker.pick(); move != nil {
+				return move
+			}
+
+		case StageGenQuiets:
+			picker.bad_index = picker.index
+			if picker.evasions || picker.captures_only {
+				picker.stage = StageBadCaptures
+				continue
+			}
+			end := picker.captures_end
+			moves := position.GenerateQuiets(picker.moves[end:end])
+			for i := range moves {
+				picker.scores[end+i] = picker.score_quiet(&moves[i])
+			}
+			picker.index = end
+			picker.size = end + len(moves)
+			picker.stage = StageQuiets
+
+		case StageQuiets:
+			if picker.index == picker.size {
+				picker.index = picker.bad_index
+				picker.stage = StageBadCaptures
+				continue
 			}
-		} else if moves[i].HasTag(chess.Capture) {
-			scores[i] = scored_move{
-				moves[i], MvvLvaOffset + MVV_LVA(moves[i], board),
+			picker.select_best(picker.size)
+			if move := picker.pick(); move != nil {
+				return move
 			}
-		} else if moves[i] == killer_moves[0] {
-			scores[i] = scored_move{
-				moves[i], MvvLvaOffset - FirstKillerMoveScore,
+
+		case StageBadCaptures:
+			if picker.index >= picker.captures_end {
+				picker.stage = StageDone
+				continue
 			}
-		} else if moves[i] == killer_moves[1] {
-			scores[i] = scored_move{
-				moves[i], MvvLvaOffset - SecondKillerMoveScore,
+			picker.select_best(picker.captures_end)
+			if move := picker.pick(); move != nil {
+				return move
 			}
-		} else {
-			scores[i] = scored_move{moves[i], 0}
+
+		default:
+			return nil
+		}
+	}
+}
+
+// Hand out the move at the current index, unless it is the hash move which
+// was already handed out.
+func (picker *move_picker) pick() *chess.Move {
+	move := &picker.moves[picker.index]
+	picker.index++
+	if picker.has_hash_move && same_move(move, &picker.hash_move) {
+		return nil
+	}
+	return move
+}
+
+// Move the best scored move between the current index and end to the current
+// index.
+func (picker *move_picker) select_best(end int) {
+	best_index := picker.index
+	for i := picker.index + 1; i < end; i++ {
+		if picker.scores[i] > picker.scores[best_index] {
+			best_index = i
 		}
 	}
-	return scores
+	i := picker.index
+	picker.moves[i], picker.moves[best_index] = picker.moves[best_index], picker.moves[i]
+	picker.scores[i], picker.scores[best_index] = picker.scores[best_index], picker.scores[i]
+}
+
+// Score a move generated in the capture stage, or an evasion. Captures that
+// lose material get a negative score.
+func (picker *move_picker) score(move *chess.Move, board *chess.Board) int {
+	switch {
+	case move.Promo() != chess.NoPieceType:
+		return MvvLvaOffset + MVV_LVA(move, board)
+	case move.HasTag(chess.EnPassant):
+		return MvvLvaOffset + mvv_lva[chess.Pawn][chess.Pawn]
+	case move.HasTag(chess.Capture):
+		if is_losing_capture(move, board) {
+			return -MvvLvaOffset + MVV_LVA(move, board)
+		}
+		return MvvLvaOffset + MVV_LVA(move, board)
+	}
+	return picker.score_quiet(move)
+}
+
+// Quiet moves that aren't killers or the counter move are ordered by their
+// history score, which stays below every other move score.
+func (picker *move_picker) score_quiet(move *chess.Move) int {
+	switch {
+	case picker.killer_moves != nil && same_move(move, &picker.killer_moves[0]):
+		return MvvLvaOffset - FirstKillerMoveScore
+	case picker.killer_moves != nil && same_move(move, &picker.killer_moves[1]):
+		return MvvLvaOffset - SecondKillerMoveScore
+	case same_move(move, picker.counter_move):
+		return MvvLvaOffset - CounterMoveScore
+	case picker.history != nil:
+		return picker.history[move.S1()][move.S2()]
+	}
+	return 0
 }
@@ -0,0 +1,32 @@
+package engine
+
+import "github.com/Sidhant-Roymoulik/Light-Blue/chess"
+
+// multi_pv.go contains the state used to search more than one principal
+// variation. Every iteration of iterative deepening searches the root once
+// per line, each time leaving out the root moves of the lines already found,
+// so line k is the best line that doesn't start with the first moves of
+// lines 1 to k-1.
+//
+// https://www.chessprogramming.org/Multiple_PV
+
+const (
+	DefaultMultiPV int = 1
+	MaxMultiPV     int = 256
+)
+
+// Set the number of principal variations to search.
+func (e *Engine) setMultiPV(lines int) {
+	e.multi_pv = Max(1, Min(lines, MaxMultiPV))
+}
+
+// Check if the move has already been searched as the first move of an earlier
+// line in this iteration.
+func (e *Engine) is_root_excluded(move *chess.Move) bool {
+	for _, excluded := range e.root_excluded {
+		if same_move(move, excluded) {
+			return true
+		}
+	}
+	return false
+}
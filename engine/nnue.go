@@ -0,0 +1,224 @@
+package engine
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+
+	"github.com/Sidhant-Roymoulik/Light-Blue/chess"
+)
+
+// nnue.go contains an efficiently updatable neural network evaluation. The
+// network is a 768->N->1 perspective net: every piece on every square is an
+// input feature, seen once from white's side and once from black's side of
+// the board. The hidden layer of each side, its accumulator, is updated with
+// only the pieces a move changes instead of being computed from scratch.
+//
+// The network file is little-endian 16 bit integers, in the order
+//
+//	feature weights [768][N]
+//	feature biases  [N]
+//	output weights  [2*N], side to move first
+//	output bias
+//
+// so the hidden size is found from the file size. Features are ordered by
+// piece color relative to the perspective, then pawn, knight, bishop, rook,
+// queen, king, then square from the perspective's side of the board.
+//
+// https://www.chessprogramming.org/NNUE
+
+const (
+	NNUEInputs int = 768
+
+	// Quantization of the hidden layer and the output weights
+	NNUEQA    int = 255
+	NNUEQB    int = 64
+	NNUEScale int = 400
+)
+
+type Network struct {
+	hidden          int
+	feature_weights []int16
+	feature_biases  []int16
+	output_weights  []int16
+	output_bias     int16
+}
+
+// The network loaded with the EvalFile option, used by the search if the
+// UseNNUE option is set.
+var nnue_network *Network
+var UseNNUE bool = false
+
+// Load a network from the file.
+func LoadNetwork(path string) (*Network, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	values := len(data) / 2
+	hidden := (values - 1) / (NNUEInputs + 3)
+	if len(data)%2 != 0 || hidden <= 0 || hidden*(NNUEInputs+3)+1 != values {
+		return nil, fmt.Errorf("%s is not a 768->N->1 network", path)
+	}
+
+	weights := make([]int16, values)
+	for i := range weights {
+		weights[i] = int16(binary.LittleEndian.Uint16(data[2*i:]))
+	}
+
+	network := &Network{hidden: hidden}
+	network.feature_weights, weights = weights[:NNUEInputs*hidden], weights[NNUEInputs*hidden:]
+	network.feature_biases, weights = weights[:hidden], weights[hidden:]
+	network.output_weights, weights = weights[:2*hidden], weights[2*hidden:]
+	network.output_bias = weights[0]
+
+	return network, nil
+}
+
+// Get the input feature of a piece on a square from the perspective's side
+// of the board.
+func nnue_feature(perspective chess.Color, color chess.Color, pt chess.PieceType, square uint8) int {
+	feature := (int(chess.Pawn) - int(pt)) * 64
+	if color != perspective {
+		feature += 384
+	}
+	if perspective == chess.Black {
+		square ^= 56
+	}
+	return feature + int(square)
+}
+
+// -----------------------------------------------------------------------------
+// 		Accumulators
+// -----------------------------------------------------------------------------
+
+// The hidden layer of both perspectives for a position, and the pieces of
+// the position to find what the next move changed.
+type accumulator struct {
+	values [2][]int16
+	pieces [2][7]chess.Bitboard
+}
+
+// An NNUE evaluates positions with a network, keeping an accumulator for
+// every ply of the search.
+type NNUE struct {
+	network      *Network
+	accumulators [MAX_PLY + 1]accumulator
+}
+
+func new_nnue(network *Network) *NNUE {
+	nnue := &NNUE{network: network}
+	for ply := range nnue.accumulators {
+		nnue.accumulators[ply].values[chess.White] = make([]int16, network.hidden)
+		nnue.accumulators[ply].values[chess.Black] = make([]int16, network.hidden)
+	}
+	return nnue
+}
+
+// Set the accumulator of the ply for the position. The position must follow
+// the position last set at the ply before, except at the root.
+func (nnue *NNUE) update(position *chess.Position, ply int) {
+	acc := &nnue.accumulators[ply]
+	pieces := board_pieces(position.Board())
+
+	if ply == 0 {
+		nnue.refresh(acc, &pieces)
+		return
+	}
+
+	// The position was already set at this ply, like when the quiescence
+	// search starts from a node of the main search
+	if acc.pieces == pieces {
+		return
+	}
+
+	parent := &nnue.accumulators[ply-1]
+	for color := chess.White; color <= chess.Black; color++ {
+		copy(acc.values[color], parent.values[color])
+	}
+
+	for color := chess.White; color <= chess.Black; color++ {
+		for pt := chess.King; pt <= chess.Pawn; pt++ {
+			removed := parent.pieces[color][pt] &^ pieces[color][pt]
+			for removed != 0 {
+				nnue.sub_feature(acc, color, pt, removed.PopBit())
+			}
+			added := pieces[color][pt] &^ parent.pieces[color][pt]
+			for added != 0 {
+				nnue.add_feature(acc, color, pt, added.PopBit())
+			}
+		}
+	}
+
+	acc.pieces = pieces
+}
+
+// Compute the accumulator from scratch.
+func (nnue *NNUE) refresh(acc *accumulator, pieces *[2][7]chess.Bitboard) {
+	for color := chess.White; color <= chess.Black; color++ {
+		copy(acc.values[color], nnue.network.feature_biases)
+	}
+
+	for color := chess.White; color <= chess.Black; color++ {
+		for pt := chess.King; pt <= chess.Pawn; pt++ {
+			bb := pieces[color][pt]
+			for bb != 0 {
+				nnue.add_feature(acc, color, pt, bb.PopBit())
+			}
+		}
+	}
+
+	acc.pieces = *pieces
+}
+
+func (nnue *NNUE) add_feature(acc *accumulator, color chess.Color, pt chess.PieceType, square uint8) {
+	hidden := nnue.network.hidden
+	for perspective := chess.White; perspective <= chess.Black; perspective++ {
+		feature := nnue_feature(perspective, color, pt, square)
+		weights := nnue.network.feature_weights[feature*hidden : (feature+1)*hidden]
+		values := acc.values[perspective]
+		for i, weight := range weights {
+			values[i] += weight
+		}
+	}
+}
+
+func (nnue *NNUE) sub_feature(acc *accumulator, color chess.Color, pt chess.PieceType, square uint8) {
+	hidden := nnue.network.hidden
+	for perspective := chess.White; perspective <= chess.Black; perspective++ {
+		feature := nnue_feature(perspective, color, pt, square)
+		weights := nnue.network.feature_weights[feature*hidden : (feature+1)*hidden]
+		values := acc.values[perspective]
+		for i, weight := range weights {
+			values[i] -= weight
+		}
+	}
+}
+
+// Evaluate the position set at the ply from the perspective of the side to
+// move.
+func (nnue *NNUE) evaluate(position *chess.Position, ply int) int {
+	acc := &nnue.accumulators[ply]
+	turn := position.Turn()
+	hidden := nnue.network.hidden
+
+	output := 0
+	us := acc.values[turn]
+	them := acc.values[turn.Other()]
+	for i := 0; i < hidden; i++ {
+		output += crelu(us[i]) * int(nnue.network.output_weights[i])
+		output += crelu(them[i]) * int(nnue.network.output_weights[hidden+i])
+	}
+	output += int(nnue.network.output_bias)
+
+	eval := output * NNUEScale / (NNUEQA * NNUEQB)
+
+	// Keep the evaluation out of the range of tablebase and mate scores
+	return Max(-TB_WIN_VALUE+1, Min(eval, TB_WIN_VALUE-1))
+}
+
+// Clipped ReLU activation.
+func crelu(value int16) int {
+	return Max(0, Min(int(value), NNUEQA))
+}
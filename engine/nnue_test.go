@@ -0,0 +1,104 @@
+package engine
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Sidhant-Roymoulik/Light-Blue/chess"
+)
+
+// Write a network with random weights to a file in the test's temporary
+// directory, and load it.
+func randomNetwork(t *testing.T, hidden int, random *rand.Rand) *Network {
+	t.Helper()
+	data := make([]byte, 2*(hidden*(NNUEInputs+3)+1))
+	for i := 0; i < len(data); i += 2 {
+		binary.LittleEndian.PutUint16(data[i:], uint16(int16(random.Intn(129)-64)))
+	}
+	path := filepath.Join(t.TempDir(), "random.nnue")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	network, err := LoadNetwork(path)
+	if err != nil {
+		t.Fatalf("failed to load the network: %s", err)
+	}
+	if network.hidden != hidden {
+		t.Fatalf("loaded %d hidden neurons, expected %d", network.hidden, hidden)
+	}
+	return network
+}
+
+func TestNNUEIncrementalUpdate(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	hidden := 32
+	network := randomNetwork(t, hidden, random)
+
+	// Walk random lines, taking moves back like the search does, and check
+	// the updated accumulators against accumulators computed from scratch
+	nnue := new_nnue(network)
+	fresh := new_nnue(network)
+	for game := 0; game < 20; game++ {
+		position := game_from_opening("Start Position").Position().Copy()
+		nnue.update(position, 0)
+
+		ply := 0
+		for step := 0; step < 200; step++ {
+			moves := position.ValidMoves()
+			if len(moves) == 0 || ply == MAX_DEPTH || (ply > 0 && random.Intn(4) == 0) {
+				if ply == 0 {
+					break
+				}
+				position.UnmakeMove()
+				ply--
+				continue
+			}
+
+			position.MakeMove(moves[random.Intn(len(moves))])
+			ply++
+			nnue.update(position, ply)
+
+			fresh.update(position, 0)
+			for color := chess.White; color <= chess.Black; color++ {
+				for i := 0; i < hidden; i++ {
+					if nnue.accumulators[ply].values[color][i] != fresh.accumulators[0].values[color][i] {
+						t.Fatalf("%s: updated accumulator doesn't match the refreshed one", position)
+					}
+				}
+			}
+			if updated, refreshed := nnue.evaluate(position, ply), fresh.evaluate(position, 0); updated != refreshed {
+				t.Fatalf("%s: updated accumulator evaluates to %d, refreshed to %d", position, updated, refreshed)
+			}
+		}
+	}
+}
+
+func TestLoadNetworkErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bad.nnue")
+
+	// Files that don't hold a whole network
+	tests := []struct {
+		name string
+		size int
+	}{
+		{"Empty", 0},
+		{"Odd size", 2*(32*(NNUEInputs+3)+1) + 1},
+		{"Truncated", 2 * (32*(NNUEInputs+3) + 1 - 2)},
+	}
+
+	for _, test := range tests {
+		if err := os.WriteFile(path, make([]byte, test.size), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadNetwork(path); err == nil {
+			t.Errorf("%s: loaded a network from %d bytes", test.name, test.size)
+		}
+	}
+	if _, err := LoadNetwork(filepath.Join(t.TempDir(), "missing.nnue")); err == nil {
+		t.Errorf("loaded a network from a missing file")
+	}
+}
@@ -0,0 +1,295 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"reflect"
+
+	"github.com/Sidhant-Roymoulik/Light-Blue/chess"
+)
+
+// params.go contains the registry of the evaluation and search parameters,
+// so they can be changed without recompiling. Parameters are loaded from a
+// JSON file with an entry for every parameter to override, like
+//
+//	{"TempoBonusMG": 14, "PVM_MG": [0, 20000, 787, 311, 214, 192, 96]}
+//
+// and some are also UCI spin options, for tuning through the protocol.
+
+// A parameter, which is a pointer to a number or a table of numbers.
+type param struct {
+	name  string
+	value any
+
+	// Only evaluation parameters are tuned by the tuner, and only the
+	// indexes of their tables that the evaluation uses
+	eval  bool
+	tuned func(index int) bool
+}
+
+// A parameter exposed as a UCI spin option.
+type spin_param struct {
+	name string
+	min  int
+	max  int
+}
+
+// The parameters loaded at startup, if the file exists.
+const DefaultParamsPath = "light_blue_params.json"
+
+// -----------------------------------------------------------------------------
+// 		Registry
+// -----------------------------------------------------------------------------
+
+// Filters for the indexes of the evaluation tables that are used.
+var (
+	tune_all = func(int) bool { return true }
+
+	// Skip the empty piece type and the king, whose value is never traded
+	tune_pieces = func(index int) bool {
+		return index > int(chess.King)
+	}
+
+	// Skip the empty piece type, and pawns on the first and last ranks
+	tune_squares = func(index int) bool {
+		pt, sq := index/64, index%64
+		return pt != int(chess.NoPieceType) && (pt != int(chess.Pawn) || tune_pawn_squares(sq))
+	}
+	tune_pawn_squares = func(index int) bool {
+		return index >= 8 && index < 56
+	}
+
+	// Only sliders and knights have mobility
+	tune_mobility = func(index int) bool {
+		return index > int(chess.King) && index < int(chess.Pawn)
+	}
+)
+
+var params = []param{
+	// Evaluation
+	{"IsolatedPawnPenatlyMG", &IsolatedPawnPenatlyMG, true, tune_all},
+	{"IsolatedPawnPenatlyEG", &IsolatedPawnPenatlyEG, true, tune_all},
+	{"DoubledPawnPenatlyMG", &DoubledPawnPenatlyMG, true, tune_all},
+	{"DoubledPawnPenatlyEG", &DoubledPawnPenatlyEG, true, tune_all},
+	{"KnightOnOutpostBonusMG", &KnightOnOutpostBonusMG, true, tune_all},
+	{"KnightOnOutpostBonusEG", &KnightOnOutpostBonusEG, true, tune_all},
+	{"BishopOutPostBonusMG", &BishopOutPostBonusMG, true, tune_all},
+	{"BishopOutPostBonusEG", &BishopOutPostBonusEG, true, tune_all},
+	{"RookOrQueenOnSeventhBonusEG", &RookOrQueenOnSeventhBonusEG, true, tune_all},
+	{"RookOnOpenFileBonusMG", &RookOnOpenFileBonusMG, true, tune_all},
+	{"BishopPairBonusMG", &BishopPairBonusMG, true, tune_all},
+	{"BishopPairBonusEG", &BishopPairBonusEG, true, tune_all},
+	{"SemiOpenFileNextToKingPenalty", &SemiOpenFileNextToKingPenalty, true, tune_all},
+	{"TempoBonusMG", &TempoBonusMG, true, tune_all},
+	{"OuterRingAttackPoints", &OuterRingAttackPoints, true, tune_pieces},
+	{"InnerRingAttackPoints", &InnerRingAttackPoints, true, tune_pieces},
+	{"PVM_MG", &PVM_MG, true, tune_pieces},
+	{"PVM_EG", &PVM_EG, true, tune_pieces},
+	{"Mobility_MG", &Mobility_MG, true, tune_mobility},
+	{"Mobility_EG", &Mobility_EG, true, tune_mobility},
+	{"PST_MG", &PST_MG, true, tune_squares},
+	{"PST_EG", &PST_EG, true, tune_squares},
+	{"PassedPawn_MG", &PassedPawn_MG, true, tune_pawn_squares},
+	{"PassedPawn_EG", &PassedPawn_EG, true, tune_pawn_squares},
+
+	// Search
+	{"Window", &Window, false, nil},
+	{"StaticNullMovePruningBaseMargin", &StaticNullMovePruningBaseMargin, false, nil},
+	{"NMR_Depth_Limit", &NMR_Depth_Limit, false, nil},
+	{"FutilityPruningDepthLimit", &FutilityPruningDepthLimit, false, nil},
+	{"IID_Depth_Limit", &IID_Depth_Limit, false, nil},
+	{"IID_Depth_Reduction", &IID_Depth_Reduction, false, nil},
+	{"LMR_Depth_Limit", &LMR_Depth_Limit, false, nil},
+	{"LMR_Move_Limit", &LMR_Move_Limit, false, nil},
+	{"SE_Depth_Limit", &SE_Depth_Limit, false, nil},
+	{"SE_TT_Depth_Margin", &SE_TT_Depth_Margin, false, nil},
+	{"SE_Margin", &SE_Margin, false, nil},
+	{"FutilityMargins", &FutilityMargins, false, nil},
+	{"LateMovePruningMargins", &LateMovePruningMargins, false, nil},
+}
+
+// The parameters exposed as UCI options. The limits keep the search in the
+// bounds of its tables.
+var spin_params = []spin_param{
+	{"Window", 8, 200},
+	{"StaticNullMovePruningBaseMargin", 0, 500},
+	{"NMR_Depth_Limit", 1, 10},
+	{"FutilityPruningDepthLimit", 0, len(FutilityMargins) - 1},
+	{"LMR_Depth_Limit", 1, 10},
+	{"LMR_Move_Limit", 1, 20},
+	{"SE_Margin", 0, 10},
+	{"TempoBonusMG", 0, 100},
+	{"BishopPairBonusMG", 0, 200},
+	{"BishopPairBonusEG", 0, 200},
+}
+
+// Get the parameter with the name.
+func find_param(name string) *param {
+	for i := range params {
+		if params[i].name == name {
+			return &params[i]
+		}
+	}
+	return nil
+}
+
+// Get pointers to every number of the parameter, in the order of its table.
+func param_values(value any) []*int {
+	var values []*int
+
+	var collect func(v reflect.Value)
+	collect = func(v reflect.Value) {
+		switch v.Kind() {
+		case reflect.Int:
+			values = append(values, v.Addr().Interface().(*int))
+		case reflect.Array, reflect.Slice:
+			for i := 0; i < v.Len(); i++ {
+				collect(v.Index(i))
+			}
+		}
+	}
+	collect(reflect.ValueOf(value).Elem())
+
+	return values
+}
+
+// -----------------------------------------------------------------------------
+// 		Loading + Saving
+// -----------------------------------------------------------------------------
+
+// Load the parameter file at startup, if there is one.
+func InitParams() {
+	if _, err := os.Stat(DefaultParamsPath); err != nil {
+		return
+	}
+	if err := LoadParams(DefaultParamsPath); err != nil {
+		fmt.Printf("info string Failed to load parameters: %s\n", err)
+	}
+}
+
+// Override the parameters in the JSON file. Nothing is changed if any entry
+// is unknown or doesn't have the shape of its parameter.
+func LoadParams(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var entries map[string]json.RawMessage
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+
+	// Decode every entry into a copy of its parameter first
+	type override struct {
+		param *param
+		value reflect.Value
+	}
+	overrides := []override{}
+
+	for name, raw := range entries {
+		p := find_param(name)
+		if p == nil {
+			return fmt.Errorf("unknown parameter %s", name)
+		}
+
+		var shape any
+		if err := json.Unmarshal(raw, &shape); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		current := reflect.ValueOf(p.value).Elem()
+		if err := check_param_shape(shape, current); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+
+		value := reflect.New(current.Type())
+		if err := json.Unmarshal(raw, value.Interface()); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		overrides = append(overrides, override{p, value.Elem()})
+	}
+
+	for _, o := range overrides {
+		reflect.ValueOf(o.param.value).Elem().Set(o.value)
+	}
+	return nil
+}
+
+// Check that a decoded JSON value is a number, or a list with the same
+// length as the table of the parameter.
+func check_param_shape(shape any, v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Int:
+		number, ok := shape.(float64)
+		if !ok || number != math.Trunc(number) {
+			return errors.New("expected an integer")
+		}
+	case reflect.Array, reflect.Slice:
+		list, ok := shape.([]any)
+		if !ok || len(list) != v.Len() {
+			return fmt.Errorf("expected a list of %d entries", v.Len())
+		}
+		for i := range list {
+			if err := check_param_shape(list[i], v.Index(i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Write the parameters to a JSON file that can be loaded back, with a line
+// for each parameter.
+func write_params(path string, eval_only bool) error {
+	var out bytes.Buffer
+	out.WriteString("{\n")
+
+	first := true
+	for _, p := range params {
+		if eval_only && !p.eval {
+			continue
+		}
+		value, err := json.Marshal(p.value)
+		if err != nil {
+			return err
+		}
+		if !first {
+			out.WriteString(",\n")
+		}
+		first = false
+		fmt.Fprintf(&out, "\t%q: %s", p.name, value)
+	}
+
+	out.WriteString("\n}\n")
+	return os.WriteFile(path, out.Bytes(), 0644)
+}
+
+// -----------------------------------------------------------------------------
+// 		UCI Options
+// -----------------------------------------------------------------------------
+
+// Print the parameters exposed as spin options, with their current values
+// as defaults.
+func print_spin_params() {
+	for _, spin := range spin_params {
+		value := *param_values(find_param(spin.name).value)[0]
+		fmt.Printf("option name %s type spin default %d min %d max %d\n",
+			spin.name, value, spin.min, spin.max)
+	}
+}
+
+// Set a parameter exposed as a spin option, returning false if there's no
+// such option.
+func set_spin_param(name string, value int) bool {
+	for _, spin := range spin_params {
+		if spin.name == name {
+			*param_values(find_param(spin.name).value)[0] = Max(spin.min, Min(value, spin.max))
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Sidhant-Roymoulik/Light-Blue/chess"
+)
+
+// Restore every parameter to its value before the test when it ends.
+func restoreParams(t *testing.T) {
+	values := map[*int]int{}
+	for _, p := range params {
+		for _, value := range param_values(p.value) {
+			values[value] = *value
+		}
+	}
+	t.Cleanup(func() {
+		for value, saved := range values {
+			*value = saved
+		}
+	})
+}
+
+func TestParamsRoundTrip(t *testing.T) {
+	restoreParams(t)
+	path := filepath.Join(t.TempDir(), "params.json")
+
+	// Saved parameters load back after being changed
+	if err := write_params(path, false); err != nil {
+		t.Fatal(err)
+	}
+	tempo, pawn, margin := TempoBonusMG, PST_MG[chess.Pawn][20], FutilityMargins[3]
+	TempoBonusMG, PST_MG[chess.Pawn][20], FutilityMargins[3] = 0, 0, 0
+	if err := LoadParams(path); err != nil {
+		t.Fatal(err)
+	}
+	if TempoBonusMG != tempo || PST_MG[chess.Pawn][20] != pawn || FutilityMargins[3] != margin {
+		t.Errorf("saved parameters didn't load back, got %d %d %d, expected %d %d %d",
+			TempoBonusMG, PST_MG[chess.Pawn][20], FutilityMargins[3], tempo, pawn, margin)
+	}
+}
+
+func TestLoadParamsErrors(t *testing.T) {
+	restoreParams(t)
+	path := filepath.Join(t.TempDir(), "params.json")
+
+	// Bad files don't change anything, not even their valid entries
+	tests := []struct {
+		name   string
+		params string
+	}{
+		{"Unknown parameter", `{"TempoBonusMG": 20, "NoSuchParam": 1}`},
+		{"Table too short", `{"TempoBonusMG": 20, "PVM_MG": [0, 1, 2]}`},
+		{"Not an integer", `{"TempoBonusMG": 20, "SE_Margin": 1.5}`},
+		{"Table too shallow", `{"TempoBonusMG": 20, "PST_MG": [[0]]}`},
+		{"Not JSON", `{"TempoBonusMG": 20`},
+	}
+
+	tempo := TempoBonusMG
+	for _, test := range tests {
+		if err := os.WriteFile(path, []byte(test.params), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := LoadParams(path); err == nil {
+			t.Errorf("%s: loaded %s", test.name, test.params)
+		}
+		if TempoBonusMG != tempo {
+			t.Errorf("%s: loading %s changed TempoBonusMG to %d", test.name, test.params, TempoBonusMG)
+			TempoBonusMG = tempo
+		}
+	}
+}
+
+func TestSetSpinParam(t *testing.T) {
+	restoreParams(t)
+
+	// Spin options are kept in their limits
+	tests := []struct {
+		name     string
+		value    int
+		expected int
+	}{
+		{"Window", 0, 8},
+		{"Window", 500, 200},
+		{"Window", 50, 50},
+		{"FutilityPruningDepthLimit", 100, len(FutilityMargins) - 1},
+	}
+
+	for _, test := range tests {
+		if !set_spin_param(test.name, test.value) {
+			t.Errorf("%s isn't a spin option", test.name)
+			continue
+		}
+		if value := *find_param(test.name).value.(*int); value != test.expected {
+			t.Errorf("setting %s to %d gave %d, expected %d", test.name, test.value, value, test.expected)
+		}
+	}
+	if set_spin_param("PST_MG", 0) {
+		t.Errorf("set PST_MG, which isn't a spin option")
+	}
+}
@@ -0,0 +1,74 @@
+package engine
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Sidhant-Roymoulik/Light-Blue/chess"
+)
+
+// perft.go contains perft, which counts the leaf nodes of the move tree of a
+// position to a given depth so the move generator can be checked against
+// known node counts. The node count of every subtree is stored in a
+// transposition table, so subtrees reached by transposition are only
+// counted once.
+//
+// https://www.chessprogramming.org/Perft
+
+// Default size of the perft transposition table, in MB.
+const DefaultPerftTTSize = 64
+
+// Count the leaf nodes of the move tree of the position to the given depth.
+func perft(position *chess.Position, depth uint8, tt *TransTable[PerftEntry, *PerftEntry]) uint64 {
+	if depth == 0 {
+		return 1
+	}
+
+	hash := position.Key()
+	if nodes, ok := tt.Probe(hash).Get(hash, depth); ok {
+		return nodes
+	}
+
+	var buffer [chess.MaxMoves]chess.Move
+	moves := position.GenerateMoves(buffer[:0])
+
+	nodes := uint64(0)
+	for i := range moves {
+		position.MakeMove(&moves[i])
+		if !position.KingAttacked(position.Turn().Other()) {
+			nodes += perft(position, depth-1, tt)
+		}
+		position.UnmakeMove()
+	}
+
+	tt.Store(hash, int(depth), 0).Set(hash, depth, nodes)
+	return nodes
+}
+
+// Print the node count below every legal move of the position, followed by
+// the total node count.
+func divide(position *chess.Position, depth uint8, tt *TransTable[PerftEntry, *PerftEntry]) uint64 {
+	start := time.Now()
+	position = position.Copy()
+
+	total := uint64(0)
+	for _, move := range position.ValidMoves() {
+		nodes := uint64(1)
+		if depth > 1 {
+			position.MakeMove(move)
+			nodes = perft(position, depth-1, tt)
+			position.UnmakeMove()
+		}
+		total += nodes
+		fmt.Printf("%v: %d\n", move, nodes)
+	}
+
+	print_perft_result(total, time.Since(start))
+	return total
+}
+
+func print_perft_result(nodes uint64, elapsed time.Duration) {
+	nps := nodes * 1000 / uint64(elapsed.Milliseconds()+1)
+	fmt.Printf("\nNodes searched: %d\n", nodes)
+	fmt.Printf("Time: %v (%d nps)\n\n", elapsed.Round(time.Millisecond), nps)
+}
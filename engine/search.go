@@ -12,7 +12,7 @@ import (
 //	Search Parameters
 // -----------------------------------------------------------------------------
 
-const (
+var (
 	Window int = 12
 
 	StaticNullMovePruningBaseMargin int = 85
@@ -20,8 +20,15 @@ const (
 	FutilityPruningDepthLimit       int = 8
 	IID_Depth_Limit                 int = 4
 	IID_Depth_Reduction             int = 2
+	LMR_Depth_Limit                 int = 3
+	LMR_Move_Limit                  int = 3
+	SE_Depth_Limit                  int = 8
+	SE_TT_Depth_Margin              int = 3
+	SE_Margin                       int = 2
 )
 
+const LMR_Max_Moves int = 64
+
 var FutilityMargins = [9]int{
 	0,
 	100, // depth 1
@@ -43,6 +50,19 @@ var LateMovePruningMargins = [6]int{
 	24, // depth 5
 }
 
+// Reductions for late moves, indexed by depth and move number.
+var LateMoveReductions [MAX_DEPTH][LMR_Max_Moves]int
+
+func InitSearchTables() {
+	for depth := 1; depth < MAX_DEPTH; depth++ {
+		for moveCount := 1; moveCount < LMR_Max_Moves; moveCount++ {
+			LateMoveReductions[depth][moveCount] = int(
+				0.75 + math.Log(float64(depth))*math.Log(float64(moveCount))/2.25,
+			)
+		}
+	}
+}
+
 // -----------------------------------------------------------------------------
 //	Engine Definition
 // -----------------------------------------------------------------------------
@@ -54,18 +74,37 @@ func new_light_blue() Engine {
 			author: author,
 			upgrades: EngineUpgrades{
 				iterative_deepening: true,
+				singular_extensions: true,
 			},
 		},
 		0,
 		time.Now(),
 		EngineCounters{},
 		TimeManager{},
-		TransTable[SearchEntry]{},
+		TransTable[SearchEntry, *SearchEntry]{},
 		0,
 		[1024]uint64{},
 		0,
 		0,
-		[MAX_DEPTH][2]*chess.Move{},
+		[MAX_DEPTH][2]chess.Move{},
+		0,
+		nil,
+		0,
+		[MAX_DEPTH]*chess.Move{},
+		[MAX_DEPTH]*chess.Move{},
+		[2][64][64]int{},
+		[64][64]chess.Move{},
+		DefaultMultiPV,
+		nil,
+		nil,
+		nil,
+		0,
+		[MAX_PLY]move_picker{},
+		0,
+		HCE{},
+		TransTable[PawnEntry, *PawnEntry]{},
+		DefaultPawnTTSize,
+		chess.UCINotation{},
 	}
 }
 
@@ -78,26 +117,90 @@ func (e *Engine) run(
 ) (best_eval int, best_move *chess.Move) {
 	e.resetCounters()
 	e.resetKillerMoves()
+	e.ponder_move = nil
+	e.eval = e.select_eval_backend()
 
-	e.Add_Zobrist_History(Zobrist.GenHash(position))
+	e.Add_Zobrist_History(position.Key())
 
 	pvLine := PVLine{}
 
+	// Only search the moves that keep the best tablebase result, so the
+	// search can't throw away a won ending or take too long to convert it
+	if tb_can_probe(position) {
+		allowed := e.root_moves
+		defer func() { e.root_moves = allowed }()
+		if tb_moves, ok := tb_root_moves(position, e.is_root_move); ok {
+			e.root_moves = tb_moves
+		}
+	}
+
+	// The search makes and unmakes moves in place, so it works on its own
+	// copy of the position
+	search_position := position.Copy()
+
 	if e.upgrades.iterative_deepening {
-		best_eval, best_move = e.iterative_deepening(position, &pvLine)
+		best_eval, best_move = e.iterative_deepening(search_position, &pvLine)
 	} else {
 		best_eval = e.aspiration_window(
-			position, int(e.timer.MaxDepth), &pvLine,
+			search_position, int(e.timer.MaxDepth), &pvLine,
 		)
 		best_move = pvLine.getPVMove()
 	}
 
+	// The search can be stopped before the first iteration is finished, for
+	// example by a stop while pondering, so fall back to any legal move
+	if best_move == nil {
+		for _, move := range position.ValidMoves() {
+			if e.is_root_move(move) {
+				best_move = move
+				break
+			}
+		}
+		if best_move == nil {
+			return
+		}
+	}
+
 	e.prev_guess = best_eval
-	e.Add_Zobrist_History(Zobrist.GenHash(position.Update(best_move)))
+	new_position := position.Update(best_move)
+	e.Add_Zobrist_History(new_position.Key())
+
+	if same_move(best_move, pvLine.getPVMove()) {
+		e.ponder_move = pvLine.getPonderMove()
+	}
+	if e.ponder_move == nil {
+		e.ponder_move = e.getHashMove(new_position)
+	}
 
 	return
 }
 
+// Get the legal move stored in the transposition table for the position, if
+// there is one.
+func (e *Engine) getHashMove(position *chess.Position) *chess.Move {
+	tt_move := e.probe_tt_move(position, position.Key())
+	for _, move := range position.ValidMoves() {
+		if same_move(move, tt_move) {
+			return move
+		}
+	}
+	return nil
+}
+
+// Get a copy of the best move stored in the table for the position, if the
+// piece it moves can make it here. A matching hash doesn't guarantee the
+// entry is for this position, so the move is dropped if it can't be played.
+func (e *Engine) probe_tt_move(position *chess.Position, hash uint64) *chess.Move {
+	data, ok := e.tt.Probe(hash).Load(hash)
+	if !ok {
+		return nil
+	}
+	if move, ok := position.PseudoLegal(data.Best); ok {
+		return &move
+	}
+	return nil
+}
+
 // Iterative Deepening
 func (e *Engine) iterative_deepening(
 	position *chess.Position, pvLine *PVLine,
@@ -106,37 +209,71 @@ func (e *Engine) iterative_deepening(
 	e.age ^= 1
 	e.timer.Start()
 
+	helpers := e.startHelpers(position)
+	defer e.stopHelpers(helpers)
+
+	// Can't search more lines than there are root moves
+	root_moves := 0
+	for _, move := range position.ValidMoves() {
+		if e.is_root_move(move) {
+			root_moves++
+		}
+	}
+	lines := Max(1, Min(e.multi_pv, root_moves))
+	line_evals := make([]int, lines)
+	defer func() { e.root_excluded = nil }()
+
 	for depth := 1; depth <= MAX_DEPTH &&
 		depth <= int(e.timer.MaxDepth) &&
 		e.timer.MaxNodeCount > 0; depth++ {
 
-		pvLine.clear()
+		e.root_excluded = e.root_excluded[:0]
 
-		new_eval := e.aspiration_window(position, depth, pvLine)
+		for line := 0; line < lines; line++ {
+			linePV := PVLine{}
+			if line > 0 {
+				// Center the aspiration window on the previous eval of the
+				// same line
+				e.prev_guess = line_evals[line]
+			}
 
-		if e.timer.IsStopped() {
-			break
+			new_eval := e.aspiration_window(position, depth, &linePV)
+
+			if e.timer.IsStopped() {
+				break
+			}
+
+			line_evals[line] = new_eval
+			e.root_excluded = append(e.root_excluded, linePV.getPVMove())
+
+			if line == 0 {
+				*pvLine = linePV
+				best_eval = new_eval
+				best_move = pvLine.getPVMove()
+				e.max_ply = depth
+			}
+
+			total_nodes := e.counters.nodes_searched +
+				e.counters.q_nodes_searched +
+				e.helperNodes()
+			total_time := time.Since(e.start).Milliseconds() + 1
+
+			fmt.Printf(
+				"info depth %d multipv %d score %s nodes %d nps %d tbhits %d time %d pv %s\n",
+				depth,
+				line+1,
+				getMateOrCPScore(new_eval),
+				total_nodes,
+				int64(total_nodes*1000)/total_time,
+				e.counters.tb_hits+e.helperTBHits(),
+				total_time,
+				linePV.format(e.notation),
+			)
 		}
 
-		best_eval = new_eval
 		e.prev_guess = best_eval
-		e.max_ply = depth
-
-		best_move = pvLine.getPVMove()
-		total_nodes := e.counters.nodes_searched + e.counters.q_nodes_searched
-		total_time := time.Since(e.start).Milliseconds() + 1
-
-		fmt.Printf(
-			"info depth %d score %s nodes %d nps %d time %d pv %s\n",
-			e.max_ply,
-			getMateOrCPScore(best_eval),
-			total_nodes,
-			int64(total_nodes*1000)/total_time,
-			total_time,
-			pvLine,
-		)
 
-		if best_eval >= MATE_CUTOFF {
+		if e.timer.IsStopped() || best_eval >= MATE_CUTOFF {
 			break
 		}
 	}
@@ -188,9 +325,10 @@ func (e *Engine) pv_search(
 	do_null bool,
 ) (eval int) {
 	e.counters.nodes_searched++
+	e.eval.update(position, ply)
 
 	if ply >= MAX_DEPTH {
-		return eval_pos(position)
+		return e.eval.evaluate(position, ply)
 	}
 
 	// Check if search is over
@@ -201,6 +339,7 @@ func (e *Engine) pv_search(
 	if (e.counters.nodes_searched+e.counters.q_nodes_searched)&
 		TIMER_CHECK == 0 {
 		e.timer.CheckIfTimeIsUp()
+		e.publishNodes()
 	}
 
 	if e.timer.IsStopped() {
@@ -208,7 +347,7 @@ func (e *Engine) pv_search(
 	}
 
 	// Generate hash for position
-	hash := Zobrist.GenHash(position)
+	hash := position.Key()
 
 	// Initialize variables
 	childPVLine := PVLine{}
@@ -216,6 +355,7 @@ func (e *Engine) pv_search(
 	isRoot := ply == 0
 	inCheck := position.InCheck()
 	canFutilityPrune := false
+	excluded_move := e.excluded_moves[ply]
 	var tt_move *chess.Move = nil
 
 	// Check Extension
@@ -229,7 +369,7 @@ func (e *Engine) pv_search(
 	// Start Q-Search
 	if depth <= 0 {
 		e.counters.nodes_searched--
-		return e.q_search(position, max_depth, alpha, beta)
+		return e.q_search(position, ply, max_depth, alpha, beta)
 	}
 
 	// Check for draw by repetition
@@ -238,31 +378,59 @@ func (e *Engine) pv_search(
 	if !isRoot &&
 		((position.HalfMoveClock() >= 100 && !possibleMateInOne) ||
 			e.Is_Draw_By_Repetition(hash)) {
-		entry := e.tt.Probe(hash)
-		_, _, tt_move := entry.Get(
-			hash,
-			ply,
-			depth,
-			-math.MaxInt,
-			math.MaxInt,
-		)
-		pvLine.update(tt_move, childPVLine)
+		if tt_move := e.probe_tt_move(position, hash); tt_move != nil {
+			pvLine.update(tt_move, childPVLine)
+		}
 		return 0
 	}
 
 	// Check for usable entry in transposition table
-	entry := e.tt.Probe(hash)
-	tt_eval, should_use, tt_move := entry.Get(
-		hash, ply, depth, alpha, beta,
-	)
-	if !isRoot && should_use {
-		e.counters.hashes_used++
-		return tt_eval
+	tt_data, tt_found := e.tt.Probe(hash).Load(hash)
+	if tt_found {
+		tt_eval, should_use := tt_data.Get(ply, depth, alpha, beta)
+		if !isRoot && should_use && excluded_move == nil {
+			e.counters.hashes_used++
+			return tt_eval
+		}
 	}
 
-	if !inCheck && !isPVNode {
+	// Drop the hash move if it can't be played here after an index collision
+	tt_score, tt_depth, tt_bound := tt_data.Score, tt_data.Depth, tt_data.Flag
+	if tt_found {
+		if move, ok := position.PseudoLegal(tt_data.Best); ok {
+			tt_move = &move
+		}
+	}
+
+	// Probe the tablebases right after a capture or pawn move, since the
+	// result is exact without knowing how many moves were played before
+	if !isRoot && excluded_move == nil &&
+		position.HalfMoveClock() == 0 && tb_can_probe(position) {
+		if wdl, ok := probe_wdl(position); ok {
+			e.counters.tb_hits++
+
+			tb_eval := wdl_to_eval(wdl, ply)
+			tb_flag := ExactFlag
+			if wdl > WDLDraw {
+				tb_flag = BetaFlag
+			} else if wdl < WDLDraw {
+				tb_flag = AlphaFlag
+			}
+
+			if tb_flag == ExactFlag ||
+				(tb_flag == BetaFlag && tb_eval >= beta) ||
+				(tb_flag == AlphaFlag && tb_eval <= alpha) {
+				entry := e.tt.Store(hash, depth, e.age)
+				entry.Set(hash, tb_eval, nil, ply, depth, tb_flag, e.age)
+				return tb_eval
+			}
+		}
+	}
+
+	static_eval, has_static_eval := 0, false
+	if !inCheck && !isPVNode && excluded_move == nil && e.mate_search == 0 {
 		// Static Eval Calculation for Pruning
-		static_eval := eval_pos(position)
+		static_eval, has_static_eval = e.eval.evaluate(position, ply), true
 
 		// Static Move Pruning
 		if abs(beta) < MATE_CUTOFF {
@@ -276,8 +444,10 @@ func (e *Engine) pv_search(
 		// Null Move Pruning
 		if do_null && depth >= NMR_Depth_Limit {
 			R := 3 + depth/6
+			e.move_stack[ply] = nil
+			position.MakeNullMove()
 			eval := -e.pv_search(
-				position.NullMove(),
+				position,
 				ply+1,
 				Max(max_depth-R, ply+1),
 				-beta,
@@ -285,6 +455,7 @@ func (e *Engine) pv_search(
 				&childPVLine,
 				false,
 			)
+			position.UnmakeNullMove()
 			childPVLine.clear()
 			if eval >= beta && abs(eval) < MATE_CUTOFF {
 				e.counters.nmp_pruned++
@@ -295,7 +466,7 @@ func (e *Engine) pv_search(
 		// Razoring
 		if depth <= 2 {
 			if static_eval+FutilityMargins[depth]*3 < beta {
-				eval := e.q_search(position, ply, alpha, beta)
+				eval := e.q_search(position, ply, ply, alpha, beta)
 				if eval < beta {
 					e.counters.razor_pruned++
 					return eval
@@ -312,95 +483,208 @@ func (e *Engine) pv_search(
 	}
 
 	// Internal Iterative Deepening
-	// if depth > IID_Depth_Limit &&
-	// 	(isPVNode || entry.GetFlag() == BetaFlag) &&
-	// 	tt_move == nil {
-	// 	e.pv_search(
-	// 		position,
-	// 		ply+1,
-	// 		max_depth-IID_Depth_Reduction,
-	// 		-beta,
-	// 		-alpha,
-	// 		&childPVLine,
-	// 		do_null,
-	// 	)
-	// 	if len(childPVLine.Moves) > 0 {
-	// 		e.counters.iid_move_found++
-	// 		tt_move = childPVLine.getPVMove()
-	// 		childPVLine.clear()
-	// 	}
-	// }
-
-	// Sort Moves
-	moves := score_moves(
-		position.ValidMoves(),
-		position.Board(),
-		e.killer_moves[ply],
+	// Without a hash move, search this node at reduced depth first so that
+	// the best move it finds can be searched first. Only done for PV nodes
+	// and for nodes whose static eval suggests they will fail high, if the
+	// static eval was computed.
+	if tt_move == nil &&
+		depth > IID_Depth_Limit &&
+		(isPVNode || (has_static_eval && static_eval >= beta)) {
+		// The check extension is applied again by the reduced search
+		iid_max_depth := max_depth - IID_Depth_Reduction
+		if inCheck {
+			iid_max_depth--
+		}
+
+		e.pv_search(
+			position,
+			ply,
+			iid_max_depth,
+			alpha,
+			beta,
+			&childPVLine,
+			do_null,
+		)
+		childPVLine.clear()
+
+		if iid_move := e.probe_tt_move(position, hash); iid_move != nil {
+			e.counters.iid_move_found++
+			tt_move = iid_move
+		}
+	}
+
+	// Singular Extension
+	// Search every move except the hash move at reduced depth. If none of
+	// them come close to the hash move's score, the hash move is singular
+	// and gets searched one ply deeper.
+	isSingular := false
+	if e.upgrades.singular_extensions &&
+		!isRoot &&
+		!inCheck &&
+		depth >= SE_Depth_Limit &&
+		tt_move != nil &&
+		excluded_move == nil &&
+		tt_bound != AlphaFlag &&
+		tt_depth >= depth-SE_TT_Depth_Margin &&
+		tt_score > -MATE_CUTOFF && tt_score < MATE_CUTOFF {
+		singular_beta := tt_score - SE_Margin*depth
+
+		e.excluded_moves[ply] = tt_move
+		eval := e.pv_search(
+			position,
+			ply,
+			ply+(depth-1)/2,
+			singular_beta-1,
+			singular_beta,
+			&childPVLine,
+			false,
+		)
+		e.excluded_moves[ply] = nil
+		childPVLine.clear()
+
+		if eval < singular_beta {
+			e.counters.singular_extensions++
+			isSingular = true
+		}
+	}
+
+	// Pick Moves
+	var counter_move *chess.Move = nil
+	if ply > 0 && e.move_stack[ply-1] != nil {
+		prev_move := e.move_stack[ply-1]
+		counter_move = &e.counter_moves[prev_move.S1()][prev_move.S2()]
+	}
+	picker := &e.pickers[ply]
+	picker.reset(
+		position,
 		tt_move,
+		&e.killer_moves[ply],
+		counter_move,
+		&e.history[position.Turn()],
 	)
 
 	// Initialize variables
 	var best_move *chess.Move = nil
 	var tt_flag = AlphaFlag
+	legal_moves := 0
+	moves_searched := 0
 
 	// Loop through moves
-	for i := 0; i < len(moves); i++ {
-		// Pick move
-		get_move(moves, i)
-		move := moves[i].move
+	for move := picker.next(position); move != nil; move = picker.next(position) {
+		// Make the move, and skip it if it leaves the king in check
+		position.MakeMove(move)
+		if position.KingAttacked(position.Turn().Other()) {
+			position.UnmakeMove()
+			continue
+		}
+		legal_moves++
+
+		// Skip the move being tested for singularity
+		if excluded_move != nil && same_move(move, excluded_move) {
+			position.UnmakeMove()
+			continue
+		}
+
+		// Skip root moves that start an earlier MultiPV line or that were
+		// left out by go searchmoves
+		if isRoot && (e.is_root_excluded(move) || !e.is_root_move(move)) {
+			position.UnmakeMove()
+			continue
+		}
+
+		// Skipped moves still count as legal moves for checkmate and
+		// stalemate, but not for the move index, so the first move searched
+		// gets the full window
+		i := moves_searched
+		moves_searched++
+		givesCheck := position.InCheck()
+		isQuiet := is_quiet(move) && !givesCheck
+
+		new_max_depth := max_depth
+		if isSingular && same_move(move, tt_move) {
+			new_max_depth++
+		}
 
 		// Late Move Pruning
-		if !isPVNode && !inCheck && depth <= 5 &&
+		if !isPVNode && !inCheck && depth <= 5 && e.mate_search == 0 &&
 			i >= LateMovePruningMargins[depth] {
-			if !move.HasTag(chess.Check) &&
-				move.Promo() == chess.NoPieceType {
+			if !givesCheck && move.Promo() == chess.NoPieceType {
 				e.counters.lmp_pruned++
+				position.UnmakeMove()
 				continue
 			}
 		}
 
 		// Futility Pruning
-		if canFutilityPrune && i > 0 && !is_q_move(move) {
+		if canFutilityPrune && i > 0 && isQuiet {
 			e.counters.futility_pruned++
+			position.UnmakeMove()
 			continue
 		}
 
-		// Generate new position
-		new_position := position.Update(move)
-
 		// Add to move history
-		e.Add_Zobrist_History(Zobrist.GenHash(new_position))
+		e.Add_Zobrist_History(position.Key())
+		e.move_stack[ply] = move
 
 		new_eval := 0
 
 		if i == 0 {
 			// Principal-Variation Search
 			new_eval = -e.pv_search(
-				new_position,
+				position,
 				ply+1,
-				max_depth,
+				new_max_depth,
 				-beta,
 				-alpha,
 				&childPVLine,
 				do_null,
 			)
 		} else {
+			// Late Move Reduction
+			reduction := 0
+			if depth >= LMR_Depth_Limit && i >= LMR_Move_Limit && !inCheck &&
+				e.mate_search == 0 &&
+				isQuiet && !is_killer(move, &e.killer_moves[ply]) {
+				reduction = LateMoveReductions[Min(depth, MAX_DEPTH-1)][Min(i, LMR_Max_Moves-1)]
+				if isPVNode {
+					reduction--
+				}
+				reduction = Max(0, Min(reduction, depth-1))
+			}
+
 			// Null-Window Search
 			new_eval = -e.pv_search(
-				new_position,
+				position,
 				ply+1,
-				max_depth,
+				new_max_depth-reduction,
 				-(alpha + 1),
 				-alpha,
 				&childPVLine,
 				true,
 			)
+
+			// Re-search at full depth if the reduced search beats alpha
+			if reduction > 0 {
+				e.counters.lmr_reduced++
+				if new_eval > alpha {
+					new_eval = -e.pv_search(
+						position,
+						ply+1,
+						new_max_depth,
+						-(alpha + 1),
+						-alpha,
+						&childPVLine,
+						true,
+					)
+				}
+			}
+
 			if new_eval > alpha && new_eval < beta {
 				// Principal-Variation Search
 				new_eval = -e.pv_search(
-					new_position,
+					position,
 					ply+1,
-					max_depth,
+					new_max_depth,
 					-beta,
 					-new_eval,
 					&childPVLine,
@@ -409,8 +693,9 @@ func (e *Engine) pv_search(
 			}
 		}
 
-		// Clear move from history
+		// Clear move from history and unmake it
 		e.Remove_Zobrist_History()
+		position.UnmakeMove()
 
 		if new_eval > alpha {
 			best_move = move
@@ -419,6 +704,17 @@ func (e *Engine) pv_search(
 				// Add killer move
 				e.addKillerMove(move, ply)
 
+				// Reward the cutoff move and punish the quiets before it
+				if is_quiet(move) {
+					e.updateHistory(
+						position.Turn(), move, picker.quiets_tried, depth,
+					)
+					if ply > 0 && e.move_stack[ply-1] != nil {
+						prev_move := e.move_stack[ply-1]
+						e.counter_moves[prev_move.S1()][prev_move.S2()] = *move
+					}
+				}
+
 				alpha = beta
 				tt_flag = BetaFlag
 
@@ -430,19 +726,24 @@ func (e *Engine) pv_search(
 			pvLine.update(move, childPVLine)
 		}
 
+		if is_quiet(move) {
+			picker.quiets_tried = append(picker.quiets_tried, move)
+		}
+
 		childPVLine.clear()
 	}
 
 	// If there are no moves return either checkmate or draw
-	if len(moves) == 0 {
+	if legal_moves == 0 {
 		if inCheck {
 			return -CHECKMATE_VALUE + ply
 		}
 		return 0
 	}
 
-	// Save position to transposition table
-	if !e.timer.IsStopped() {
+	// Save position to transposition table, unless a move was left out
+	if !e.timer.IsStopped() && excluded_move == nil &&
+		!(isRoot && (len(e.root_excluded) > 0 || e.root_moves != nil)) {
 		entry := e.tt.Store(
 			hash, depth, e.age,
 		)
@@ -457,11 +758,13 @@ func (e *Engine) pv_search(
 // Quiescence Search
 func (e *Engine) q_search(
 	position *chess.Position,
+	ply int,
 	depth int,
 	alpha int,
 	beta int,
 ) int {
 	e.counters.q_nodes_searched++
+	e.eval.update(position, ply)
 
 	// Check if search is over
 	if e.counters.nodes_searched+e.counters.q_nodes_searched >=
@@ -471,17 +774,18 @@ func (e *Engine) q_search(
 	if (e.counters.nodes_searched+e.counters.q_nodes_searched)&
 		TIMER_CHECK == 0 {
 		e.timer.CheckIfTimeIsUp()
+		e.publishNodes()
 	}
 
 	if e.timer.IsStopped() {
 		return 0
 	}
 
-	if depth <= 0 {
-		return eval_pos(position)
+	if depth <= 0 || ply >= MAX_PLY {
+		return e.eval.evaluate(position, ply)
 	}
 
-	eval := eval_pos(position)
+	eval := e.eval.evaluate(position, ply)
 
 	// Delta Pruning
 	if eval >= beta {
@@ -489,21 +793,25 @@ func (e *Engine) q_search(
 	}
 	alpha = Max(alpha, eval)
 
-	// Sort Moves
-	moves := score_moves(
-		get_q_moves(position),
-		position.Board(),
-		[2]*chess.Move{nil, nil},
-		nil,
-	)
+	// Pick Moves
+	picker := &e.pickers[ply]
+	picker.reset_q()
 
-	for i := 0; i < len(moves); i++ {
-		get_move(moves, i)
-		move := moves[i].move
+	for move := picker.next(position); move != nil; move = picker.next(position) {
+		// Skip captures that lose material
+		if picker.stage == StageBadCaptures &&
+			move.Promo() == chess.NoPieceType {
+			e.counters.see_pruned++
+			continue
+		}
 
-		new_eval := -e.q_search(
-			position.Update(move), depth-1, -beta, -alpha,
-		)
+		position.MakeMove(move)
+		if position.KingAttacked(position.Turn().Other()) {
+			position.UnmakeMove()
+			continue
+		}
+		new_eval := -e.q_search(position, ply+1, depth-1, -beta, -alpha)
+		position.UnmakeMove()
 
 		alpha = Max(alpha, new_eval)
 
@@ -545,20 +853,76 @@ func (e *Engine) resetZobrist() {
 	e.zobristHistoryPly = 0
 }
 
+// -----------------------------------------------------------------------------
+// 		Root Moves
+// -----------------------------------------------------------------------------
+
+// Check if the move is allowed at the root. The search is restricted to the
+// root moves given by go searchmoves, or allowed every move if there are none.
+func (e *Engine) is_root_move(move *chess.Move) bool {
+	if e.root_moves == nil {
+		return true
+	}
+	for _, root_move := range e.root_moves {
+		if same_move(move, root_move) {
+			return true
+		}
+	}
+	return false
+}
+
 // -----------------------------------------------------------------------------
 // 		Killer Moves
 // -----------------------------------------------------------------------------
 
+// Killer moves are stored by value, since the move picker reuses its buffer.
 func (e *Engine) addKillerMove(move *chess.Move, ply int) {
-	if !move.HasTag(chess.Capture) && move != e.killer_moves[ply][0] {
+	if !move.HasTag(chess.Capture) && !same_move(move, &e.killer_moves[ply][0]) {
 		e.killer_moves[ply][1] = e.killer_moves[ply][0]
-		e.killer_moves[ply][0] = move
+		e.killer_moves[ply][0] = *move
 	}
 }
 
+func is_killer(move *chess.Move, killer_moves *[2]chess.Move) bool {
+	return same_move(move, &killer_moves[0]) || same_move(move, &killer_moves[1])
+}
+
 func (e *Engine) resetKillerMoves() {
-	for i := 0; i < len(e.killer_moves); i++ {
-		e.killer_moves[i][0] = nil
-		e.killer_moves[i][1] = nil
+	e.killer_moves = [MAX_DEPTH][2]chess.Move{}
+}
+
+// -----------------------------------------------------------------------------
+// 		History Heuristic and Counter Moves
+// -----------------------------------------------------------------------------
+
+// Add the bonus to a history score, scaled down as the score gets closer to
+// MaxHistory so that scores stay within [-MaxHistory, MaxHistory].
+func add_history_bonus(score *int, bonus int) {
+	abs_bonus := bonus
+	if abs_bonus < 0 {
+		abs_bonus = -abs_bonus
+	}
+	*score += bonus - *score*abs_bonus/MaxHistory
+}
+
+// Reward a quiet move that caused a beta-cutoff and punish the quiet moves
+// that were searched before it without causing one.
+func (e *Engine) updateHistory(
+	color chess.Color,
+	move *chess.Move,
+	quiets_tried []*chess.Move,
+	depth int,
+) {
+	bonus := Min(depth*depth, MaxHistoryBonus)
+
+	add_history_bonus(&e.history[color][move.S1()][move.S2()], bonus)
+	for _, quiet := range quiets_tried {
+		add_history_bonus(&e.history[color][quiet.S1()][quiet.S2()], -bonus)
 	}
 }
+
+func (e *Engine) resetHistory() {
+	e.history = [2][64][64]int{}
+	e.counter_moves = [64][64]chess.Move{}
+	e.move_stack = [MAX_DEPTH]*chess.Move{}
+}
@@ -0,0 +1,105 @@
+package engine
+
+import "github.com/Sidhant-Roymoulik/Light-Blue/chess"
+
+// -----------------------------------------------------------------------------
+// 		Static Exchange Evaluation
+// 		https://www.chessprogramming.org/Static_Exchange_Evaluation
+// -----------------------------------------------------------------------------
+
+// Piece values used when resolving exchanges, indexed by piece type. The king
+// is worth more than everything else combined so it is only ever used as the
+// last attacker.
+var SEEPieceValues = [7]int{0, 20000, 900, 500, 330, 320, 100}
+
+// Get every piece of either color attacking the square, given the occupancy.
+// Sliders are generated from the occupancy, so removing a piece from it
+// uncovers the x-ray attackers behind it.
+func attackers_to(board *chess.Board, sq chess.Square, occupied chess.Bitboard) chess.Bitboard {
+	queens := board.BBWhiteQueen | board.BBBlackQueen
+	diagonal := board.BBWhiteBishop | board.BBBlackBishop | queens
+	straight := board.BBWhiteRook | board.BBBlackRook | queens
+
+	return (PawnAttacks[chess.Black][sq] & board.BBWhitePawn) |
+		(PawnAttacks[chess.White][sq] & board.BBBlackPawn) |
+		(chess.BBKnightMoves[sq] & (board.BBWhiteKnight | board.BBBlackKnight)) |
+		(chess.BBKingMoves[sq] & (board.BBWhiteKing | board.BBBlackKing)) |
+		(chess.DiaAttack(occupied, sq) & diagonal) |
+		(chess.HvAttack(occupied, sq) & straight)
+}
+
+// Get the square and type of the least valuable piece of the given color
+// among the attackers.
+func least_valuable_attacker(
+	board *chess.Board,
+	attackers chess.Bitboard,
+	color chess.Color,
+) (chess.Square, chess.PieceType) {
+	for piece_type := chess.Pawn; piece_type >= chess.King; piece_type-- {
+		bb := attackers & board.BBForPiece(chess.NewPiece(piece_type, color))
+		if bb != 0 {
+			return chess.Square(bb.Msb()), piece_type
+		}
+	}
+	return chess.NoSquare, chess.NoPieceType
+}
+
+// Get the material won or lost by the side to move after playing the capture
+// and letting both sides keep recapturing on the target square with their
+// least valuable piece, stopping whenever recapturing would lose material.
+// Promotions are not taken into account.
+func SEE(move *chess.Move, board *chess.Board) int {
+	var gain [32]int
+
+	from, to := move.S1(), move.S2()
+	piece := board.Piece(from)
+	color := piece.Color()
+	attacker := piece.Type()
+
+	occupied := ^board.EmptySqs
+	occupied &^= chess.BBForSquare(from)
+
+	if move.HasTag(chess.EnPassant) {
+		gain[0] = SEEPieceValues[chess.Pawn]
+		if color == chess.White {
+			occupied &^= chess.BBForSquare(to - 8)
+		} else {
+			occupied &^= chess.BBForSquare(to + 8)
+		}
+	} else {
+		gain[0] = SEEPieceValues[board.Piece(to).Type()]
+	}
+
+	attackers := attackers_to(board, to, occupied) & occupied
+
+	d := 0
+	for d < len(gain)-1 {
+		d++
+		color = color.Other()
+
+		// Value of the piece standing on the square if it gets captured
+		gain[d] = SEEPieceValues[attacker] - gain[d-1]
+
+		// Neither side can improve by continuing the exchange
+		if Max(-gain[d-1], gain[d]) < 0 {
+			break
+		}
+
+		var sq chess.Square
+		sq, attacker = least_valuable_attacker(board, attackers, color)
+		if sq == chess.NoSquare {
+			break
+		}
+
+		occupied &^= chess.BBForSquare(sq)
+		attackers = attackers_to(board, to, occupied) & occupied
+	}
+
+	// The last capture in the list never happens, either because there was no
+	// piece left to make it or because it was cut off above.
+	for d--; d > 0; d-- {
+		gain[d-1] = -Max(-gain[d-1], gain[d])
+	}
+
+	return gain[0]
+}
@@ -0,0 +1,37 @@
+package engine
+
+import "testing"
+
+func TestSEE(t *testing.T) {
+	tests := []struct {
+		name  string
+		fen   string
+		move  string
+		score int
+	}{
+		{"Undefended pawn", "4k3/8/8/3p4/8/8/8/3QK3 w - - 0 1", "d1d5", 100},
+		{"Pawn defended by a pawn", "4k3/8/4p3/3p4/8/8/8/3QK3 w - - 0 1", "d1d5", 100 - 900},
+		{"Rook behind the capturing rook", "3rk3/8/8/3p4/8/8/3R4/3RK3 w - - 0 1", "d2d5", 100},
+		{"Knight takes a pawn defended by a bishop", "4k3/8/8/3p4/4b3/2N5/8/4K3 w - - 0 1", "c3d5", 100 - 320},
+		{"Bishop takes a knight defended by a pawn", "4k3/2p5/3n4/8/8/8/7B/4K3 w - - 0 1", "h2d6", 320 - 330},
+		{"En passant defended by a rook", "3rk3/8/8/3pP3/8/8/8/4K3 w - d6 0 1", "e5d6", 0},
+	}
+
+	for _, test := range tests {
+		position := game_from_fen(test.fen).Position()
+
+		found := false
+		for _, move := range position.ValidMoves() {
+			if move.String() != test.move {
+				continue
+			}
+			found = true
+			if score := SEE(move, position.Board()); score != test.score {
+				t.Errorf("%s: got %d for %s in %s, expected %d", test.name, score, test.move, test.fen, test.score)
+			}
+		}
+		if !found {
+			t.Errorf("%s: %s is not a legal move in %s", test.name, test.move, test.fen)
+		}
+	}
+}
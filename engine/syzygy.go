@@ -0,0 +1,1242 @@
+package engine
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Sidhant-Roymoulik/Light-Blue/chess"
+)
+
+// syzygy.go contains a reader for Syzygy endgame tablebases. For every
+// combination of up to 7 pieces there is a WDL table (.rtbw), which stores
+// whether the side to move wins, draws or loses, and a DTZ table (.rtbz),
+// which stores the number of plies to the next capture or pawn move on the
+// way to the result. Wins and losses that take more than 50 moves to
+// convert are stored as cursed wins and blessed losses, since they are
+// draws under the fifty move rule.
+//
+// Tables are found by file name when the path is set, and only read into
+// memory the first time they are probed. The indexing and decompression
+// follow the probing code in Stockfish.
+//
+// https://www.chessprogramming.org/Syzygy_Bases
+// https://github.com/official-stockfish/Stockfish/blob/master/src/syzygy/tbprobe.cpp
+
+// The most pieces a table can have.
+const TBPieces = 7
+
+// The results stored in WDL tables, from the perspective of the side to
+// move.
+const (
+	WDLLoss        int = -2
+	WDLBlessedLoss int = -1
+	WDLDraw        int = 0
+	WDLCursedWin   int = 1
+	WDLWin         int = 2
+)
+
+// The states a probe can end in. A probe fails if a table is missing. DTZ
+// tables only store one side to move, so probing the other side changes the
+// side to move with a 1-ply search. And DTZ tables don't store a useful value
+// when the best move is a capture or pawn move, which has to be found by
+// searching the captures and pawn moves.
+const (
+	tb_change_stm        int = -1
+	tb_fail              int = 0
+	tb_ok                int = 1
+	tb_zeroing_best_move int = 2
+)
+
+// Flags stored for every subtable.
+const (
+	tb_stm          uint8 = 1
+	tb_mapped       uint8 = 2
+	tb_win_plies    uint8 = 4
+	tb_loss_plies   uint8 = 8
+	tb_wide         uint8 = 16
+	tb_single_value uint8 = 128
+)
+
+// The first four bytes of every WDL and DTZ file.
+const (
+	tb_wdl_magic uint32 = 0x5d23e871
+	tb_dtz_magic uint32 = 0xa50c66d7
+)
+
+// Precomputed tables used to compute the index of a position.
+var (
+	tb_map_pawns       [64]int
+	tb_map_b1h1h7      [64]int
+	tb_map_a1d1d4      [64]int
+	tb_map_kk          [10][64]int
+	tb_binomial        [TBPieces][64]uint64
+	tb_lead_pawn_idx   [TBPieces][64]uint64
+	tb_lead_pawns_size [TBPieces][4]uint64
+)
+
+// -----------------------------------------------------------------------------
+// 		Tables
+// -----------------------------------------------------------------------------
+
+// The decompression and indexing information for one subtable. A table has
+// a subtable for each side to move, unless both sides have the same pieces,
+// and a subtable for each file of the leading pawn from a to d if it has
+// pawns.
+type tb_pairs struct {
+	flags        uint8
+	max_sym_len  uint8
+	min_sym_len  uint8
+	num_blocks   uint32
+	block_size   uint64
+	span         uint64
+	lowest_sym   int
+	btree        int
+	block_length int
+	block_len_sz int
+	sparse_index int
+	sparse_size  uint64
+	data         int
+	base64       []uint64
+	sym_len      []uint8
+	pieces       [TBPieces]uint8
+	group_idx    [TBPieces + 1]uint64
+	group_len    [TBPieces + 1]int
+	map_idx      [4]int
+}
+
+// A WDL or DTZ file, which is read the first time it is probed.
+type tb_file struct {
+	path  string
+	magic uint32
+	once  sync.Once
+	data  []byte
+	ready bool
+	pairs [2][4]tb_pairs
+}
+
+// A table for one combination of pieces, with the stronger side as white.
+type tb_table struct {
+	key               string
+	key2              string
+	piece_count       int
+	has_pawns         bool
+	has_unique_pieces bool
+	pawn_count        [2]int
+	wdl               tb_file
+	dtz               tb_file
+}
+
+// Every table found in the tablebase path.
+type syzygy_tables struct {
+	tables     map[string]*tb_table
+	max_pieces int
+}
+
+var syzygy syzygy_tables
+
+// Get the subtable of the file for the side to move and the file of the
+// leading pawn.
+func (table *tb_table) pairs(file *tb_file, stm int, f int) *tb_pairs {
+	if !table.has_pawns {
+		f = 0
+	}
+	if file.magic == tb_dtz_magic {
+		stm = 0
+	}
+	return &file.pairs[stm][f]
+}
+
+// Get the material key of the position, which lists the pieces of white and
+// then black like the table file names do, for example KRPvKR.
+func tb_material_key(board *chess.Board, white chess.Color) string {
+	key := ""
+	for _, c := range []chess.Color{white, white.Other()} {
+		if key != "" {
+			key += "v"
+		}
+		for _, pt := range chess.PieceTypes() {
+			count := board.BBForPiece(chess.NewPiece(pt, c)).CountBits()
+			key += strings.Repeat(strings.ToUpper(pt.String()), count)
+		}
+	}
+	return key
+}
+
+// Create the table with the given file name without its extension, for
+// example KRvK. Returns nil if the name isn't a valid combination of pieces.
+func new_tb_table(name string, path string) *tb_table {
+	sides := strings.Split(name, "v")
+	if len(sides) != 2 ||
+		len(name)-1 > TBPieces ||
+		!strings.HasPrefix(sides[0], "K") ||
+		!strings.HasPrefix(sides[1], "K") {
+		return nil
+	}
+
+	table := &tb_table{
+		key:         name,
+		key2:        sides[1] + "v" + sides[0],
+		piece_count: len(name) - 1,
+	}
+	table.wdl.path = filepath.Join(path, name+".rtbw")
+	table.wdl.magic = tb_wdl_magic
+	table.dtz.path = filepath.Join(path, name+".rtbz")
+	table.dtz.magic = tb_dtz_magic
+
+	for _, side := range sides {
+		for i, char := range side {
+			if !strings.ContainsRune("KQRBNP", char) || (i > 0 && char == 'K') {
+				return nil
+			}
+			if char != 'K' && strings.Count(side, string(char)) == 1 {
+				table.has_unique_pieces = true
+			}
+		}
+	}
+
+	// The leading color is the side with fewer pawns, if it has any, since
+	// that compresses better
+	pawns := [2]int{strings.Count(sides[0], "P"), strings.Count(sides[1], "P")}
+	table.has_pawns = pawns[0]+pawns[1] > 0
+	if pawns[1] == 0 || (pawns[0] > 0 && pawns[1] >= pawns[0]) {
+		table.pawn_count = [2]int{pawns[0], pawns[1]}
+	} else {
+		table.pawn_count = [2]int{pawns[1], pawns[0]}
+	}
+
+	return table
+}
+
+// Find the tables in the path, which can list several directories, and
+// return the number of tables found. Only the WDL file of a table has to be
+// there, the DTZ file is only needed to rank the root moves.
+func init_syzygy(path string) int {
+	syzygy = syzygy_tables{tables: map[string]*tb_table{}}
+
+	if path == "" || path == "<empty>" {
+		return 0
+	}
+
+	found := 0
+	for _, dir := range filepath.SplitList(path) {
+		files, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, file := range files {
+			name, ok := strings.CutSuffix(file.Name(), ".rtbw")
+			if !ok || syzygy.tables[name] != nil {
+				continue
+			}
+			table := new_tb_table(name, dir)
+			if table == nil {
+				continue
+			}
+			syzygy.tables[table.key] = table
+			syzygy.tables[table.key2] = table
+			syzygy.max_pieces = Max(syzygy.max_pieces, table.piece_count)
+			found++
+		}
+	}
+	return found
+}
+
+// Precompute the tables used to index positions.
+func InitSyzygyTables() {
+	// Squares below the a1-h8 diagonal map to 0..27
+	code := 0
+	for sq := 0; sq < 64; sq++ {
+		if tb_off_diagonal(sq) < 0 {
+			tb_map_b1h1h7[sq] = code
+			code++
+		}
+	}
+
+	// Squares in the a1-d1-d4 triangle map to 0..9, with the diagonal last
+	code = 0
+	diagonal := []int{}
+	for _, sq := range []int{0, 1, 2, 3, 8, 9, 10, 11, 16, 17, 18, 19, 24, 25, 26, 27} {
+		if tb_off_diagonal(sq) < 0 {
+			tb_map_a1d1d4[sq] = code
+			code++
+		} else if tb_off_diagonal(sq) == 0 {
+			diagonal = append(diagonal, sq)
+		}
+	}
+	for _, sq := range diagonal {
+		tb_map_a1d1d4[sq] = code
+		code++
+	}
+
+	// The 462 legal placements of two kings with the first in the a1-d1-d4
+	// triangle. If the first king is on the diagonal, the second can't be
+	// above it, and placements with both on the diagonal come last.
+	code = 0
+	both_on_diagonal := [][2]int{}
+	for idx := 0; idx < 10; idx++ {
+		for s1 := 0; s1 < 28; s1++ {
+			if tb_map_a1d1d4[s1] != idx || (idx == 0 && s1 != int(chess.B1)) {
+				continue
+			}
+			for s2 := 0; s2 < 64; s2++ {
+				if distance(uint8(s1), uint8(s2)) <= 1 {
+					continue
+				} else if tb_off_diagonal(s1) == 0 && tb_off_diagonal(s2) > 0 {
+					continue
+				} else if tb_off_diagonal(s1) == 0 && tb_off_diagonal(s2) == 0 {
+					both_on_diagonal = append(both_on_diagonal, [2]int{idx, s2})
+				} else {
+					tb_map_kk[idx][s2] = code
+					code++
+				}
+			}
+		}
+	}
+	for _, kk := range both_on_diagonal {
+		tb_map_kk[kk[0]][kk[1]] = code
+		code++
+	}
+
+	// The number of ways to choose k squares out of n
+	tb_binomial[0][0] = 1
+	for n := 1; n < 64; n++ {
+		for k := 0; k < TBPieces && k <= n; k++ {
+			tb_binomial[k][n] = 0
+			if k > 0 {
+				tb_binomial[k][n] += tb_binomial[k-1][n-1]
+			}
+			if k < n {
+				tb_binomial[k][n] += tb_binomial[k][n-1]
+			}
+		}
+	}
+
+	// Pawn squares from a2 to h7 map to 47..0, going from the edge files to
+	// the center and up the ranks, so the leading pawn is the one with the
+	// highest value. The value is also the number of squares left for the
+	// other pawns of the leading group.
+	available := 47
+	for leads := 1; leads < TBPieces-1; leads++ {
+		for f := 0; f < 4; f++ {
+			idx := uint64(0)
+			for r := 1; r < 7; r++ {
+				sq := r*8 + f
+				if leads == 1 {
+					tb_map_pawns[sq] = available
+					available--
+					tb_map_pawns[sq^7] = available
+					available--
+				}
+				tb_lead_pawn_idx[leads][sq] = idx
+				idx += tb_binomial[leads-1][tb_map_pawns[sq]]
+			}
+			tb_lead_pawns_size[leads][f] = idx
+		}
+	}
+}
+
+// Get how far the square is above the a1-h8 diagonal.
+func tb_off_diagonal(sq int) int {
+	return sq/8 - sq%8
+}
+
+// -----------------------------------------------------------------------------
+// 		Reading Tables
+// -----------------------------------------------------------------------------
+
+// Read the file and its subtables the first time it is probed. Returns false
+// if the file is missing or corrupted.
+func (table *tb_table) load(file *tb_file) bool {
+	file.once.Do(func() {
+		data, err := os.ReadFile(file.path)
+		if err != nil || len(data) < 8 ||
+			binary.LittleEndian.Uint32(data) != file.magic {
+			return
+		}
+
+		// The first byte after the magic says whether the table is split by
+		// side to move and whether it has pawns
+		split := data[4]&1 != 0
+		if (data[4]&2 != 0) != table.has_pawns ||
+			(file.magic == tb_wdl_magic && split != (table.key != table.key2)) {
+			return
+		}
+
+		// Pad the data, since the last block can be read past its end
+		file.data = append(data, make([]byte, 8)...)
+		table.init_pairs(file, 5)
+		file.ready = true
+	})
+	return file.ready
+}
+
+// Set up the subtables of the file from its header, which starts at pos.
+func (table *tb_table) init_pairs(file *tb_file, pos int) {
+	data := file.data
+
+	sides := 1
+	if file.magic == tb_wdl_magic && table.key != table.key2 {
+		sides = 2
+	}
+	files := 1
+	if table.has_pawns {
+		files = 4
+	}
+	both_pawns := table.has_pawns && table.pawn_count[1] > 0
+
+	// The order the groups of pieces are encoded in, and the pieces of each
+	// subtable, with the white to move subtable in the low nibbles
+	for f := 0; f < files; f++ {
+		order := [2][2]int{{int(data[pos] & 0xf), 0xf}, {int(data[pos] >> 4), 0xf}}
+		if both_pawns {
+			order[0][1] = int(data[pos+1] & 0xf)
+			order[1][1] = int(data[pos+1] >> 4)
+			pos++
+		}
+		pos++
+
+		for k := 0; k < table.piece_count; k, pos = k+1, pos+1 {
+			for i := 0; i < sides; i++ {
+				piece := data[pos] & 0xf
+				if i == 1 {
+					piece = data[pos] >> 4
+				}
+				file.pairs[i][f].pieces[k] = piece
+			}
+		}
+
+		for i := 0; i < sides; i++ {
+			table.set_groups(&file.pairs[i][f], order[i], f)
+		}
+	}
+	pos += pos & 1
+
+	for f := 0; f < files; f++ {
+		for i := 0; i < sides; i++ {
+			pos = file.pairs[i][f].set_sizes(data, pos)
+		}
+	}
+
+	// DTZ tables can map the stored values back to the real ones for each
+	// result
+	if file.magic == tb_dtz_magic {
+		for f := 0; f < files; f++ {
+			pairs := &file.pairs[0][f]
+			if pairs.flags&tb_mapped == 0 {
+				continue
+			}
+			if pairs.flags&tb_wide != 0 {
+				pos += pos & 1
+				for i := 0; i < 4; i++ {
+					pairs.map_idx[i] = pos + 2
+					pos += 2*int(binary.LittleEndian.Uint16(data[pos:])) + 2
+				}
+			} else {
+				for i := 0; i < 4; i++ {
+					pairs.map_idx[i] = pos + 1
+					pos += int(data[pos]) + 1
+				}
+			}
+		}
+		pos += pos & 1
+	}
+
+	for f := 0; f < files; f++ {
+		for i := 0; i < sides; i++ {
+			file.pairs[i][f].sparse_index = pos
+			pos += int(file.pairs[i][f].sparse_size) * 6
+		}
+	}
+	for f := 0; f < files; f++ {
+		for i := 0; i < sides; i++ {
+			file.pairs[i][f].block_length = pos
+			pos += file.pairs[i][f].block_len_sz * 2
+		}
+	}
+	for f := 0; f < files; f++ {
+		for i := 0; i < sides; i++ {
+			pos = (pos + 0x3f) &^ 0x3f
+			file.pairs[i][f].data = pos
+			pos += int(file.pairs[i][f].num_blocks) * int(file.pairs[i][f].block_size)
+		}
+	}
+}
+
+// Group the pieces that are encoded together. The leading group is the
+// leading pawns, or without pawns the first three pieces if there is a
+// unique piece apart from the kings, or the two kings otherwise. Every other
+// group is the pieces of the same type and color, for example KRvKN is
+// grouped as KRK + N and KPPvKP as P + PP + K + K.
+func (table *tb_table) set_groups(pairs *tb_pairs, order [2]int, f int) {
+	n := 0
+	first_len := 2
+	if table.has_pawns {
+		first_len = 0
+	} else if table.has_unique_pieces {
+		first_len = 3
+	}
+
+	pairs.group_len[n] = 1
+	for i := 1; i < table.piece_count; i++ {
+		first_len--
+		if first_len > 0 || pairs.pieces[i] == pairs.pieces[i-1] {
+			pairs.group_len[n]++
+		} else {
+			n++
+			pairs.group_len[n] = 1
+		}
+	}
+	n++
+	pairs.group_len[n] = 0
+
+	// The groups are encoded in the order given by the table, so the index
+	// of the position is g1 * N(g2) * N(g3) + g2 * N(g3) + g3 where N(g) is
+	// the number of ways to place the pieces of group g
+	both_pawns := table.has_pawns && table.pawn_count[1] > 0
+	next := 1
+	free_squares := 64 - pairs.group_len[0]
+	if both_pawns {
+		next = 2
+		free_squares -= pairs.group_len[1]
+	}
+
+	idx := uint64(1)
+	for k := 0; next < n || k == order[0] || k == order[1]; k++ {
+		if k == order[0] {
+			// Leading pawns or pieces
+			pairs.group_idx[0] = idx
+			if table.has_pawns {
+				idx *= tb_lead_pawns_size[pairs.group_len[0]][f]
+			} else if table.has_unique_pieces {
+				idx *= 31332
+			} else {
+				idx *= 462
+			}
+		} else if k == order[1] {
+			// Remaining pawns
+			pairs.group_idx[1] = idx
+			idx *= tb_binomial[pairs.group_len[1]][48-pairs.group_len[0]]
+		} else {
+			// Remaining pieces
+			pairs.group_idx[next] = idx
+			idx *= tb_binomial[pairs.group_len[next]][free_squares]
+			free_squares -= pairs.group_len[next]
+			next++
+		}
+	}
+	pairs.group_idx[n] = idx
+}
+
+// Read the sizes of the subtable and its canonical Huffman code, starting at
+// pos. Returns the position after them.
+func (pairs *tb_pairs) set_sizes(data []byte, pos int) int {
+	pairs.flags = data[pos]
+	pos++
+
+	// Every position of the subtable has the same value
+	if pairs.flags&tb_single_value != 0 {
+		pairs.min_sym_len = data[pos]
+		return pos + 1
+	}
+
+	// The last group index is the number of positions in the subtable
+	tb_size := uint64(0)
+	for i := range pairs.group_len {
+		if pairs.group_len[i] == 0 {
+			tb_size = pairs.group_idx[i]
+			break
+		}
+	}
+
+	pairs.block_size = 1 << data[pos]
+	pairs.span = 1 << data[pos+1]
+	pairs.sparse_size = (tb_size + pairs.span - 1) / pairs.span
+	padding := int(data[pos+2])
+	pairs.num_blocks = binary.LittleEndian.Uint32(data[pos+3:])
+	pairs.block_len_sz = int(pairs.num_blocks) + padding
+	pairs.max_sym_len = data[pos+7]
+	pairs.min_sym_len = data[pos+8]
+	pos += 9
+	pairs.lowest_sym = pos
+
+	// Longer codes have lower values in a canonical Huffman code, so the
+	// lowest code of every length, left aligned in 64 bits, decreases with
+	// the length. The length of a code is found by comparing against them.
+	lengths := int(pairs.max_sym_len-pairs.min_sym_len) + 1
+	pairs.base64 = make([]uint64, lengths)
+	for i := lengths - 2; i >= 0; i-- {
+		pairs.base64[i] = (pairs.base64[i+1] +
+			uint64(binary.LittleEndian.Uint16(data[pos+2*i:])) -
+			uint64(binary.LittleEndian.Uint16(data[pos+2*i+2:]))) / 2
+	}
+	for i := 0; i < lengths; i++ {
+		pairs.base64[i] <<= 64 - i - int(pairs.min_sym_len)
+	}
+	pos += 2 * lengths
+
+	// Every symbol is either a value or a pair of other symbols, so find the
+	// number of values each symbol expands to
+	symbols := int(binary.LittleEndian.Uint16(data[pos:]))
+	pos += 2
+	pairs.btree = pos
+	pairs.sym_len = make([]uint8, symbols)
+	visited := make([]bool, symbols)
+	for sym := 0; sym < symbols; sym++ {
+		if !visited[sym] {
+			pairs.sym_len[sym] = pairs.set_sym_len(data, sym, visited)
+		}
+	}
+
+	return pos + 3*symbols + symbols&1
+}
+
+// Get the number of values the symbol expands to, minus one.
+func (pairs *tb_pairs) set_sym_len(data []byte, sym int, visited []bool) uint8 {
+	visited[sym] = true
+	right := pairs.right(data, sym)
+	if right == 0xfff {
+		return 0
+	}
+
+	left := pairs.left(data, sym)
+	if !visited[left] {
+		pairs.sym_len[left] = pairs.set_sym_len(data, left, visited)
+	}
+	if !visited[right] {
+		pairs.sym_len[right] = pairs.set_sym_len(data, right, visited)
+	}
+	return pairs.sym_len[left] + pairs.sym_len[right] + 1
+}
+
+// Get the first symbol of the pair the symbol stands for, which is the
+// stored value if the symbol isn't a pair.
+func (pairs *tb_pairs) left(data []byte, sym int) int {
+	pos := pairs.btree + 3*sym
+	return int(data[pos+1]&0xf)<<8 | int(data[pos])
+}
+
+// Get the second symbol of the pair the symbol stands for.
+func (pairs *tb_pairs) right(data []byte, sym int) int {
+	pos := pairs.btree + 3*sym
+	return int(data[pos+2])<<4 | int(data[pos+1]>>4)
+}
+
+// Get the value stored at the index of the subtable. The values are split
+// into blocks of Huffman coded symbols, where each block stores up to 65536
+// values and the sparse index points at the block of every span-th value.
+func (pairs *tb_pairs) decompress(data []byte, idx uint64) int {
+	if pairs.flags&tb_single_value != 0 {
+		return int(pairs.min_sym_len)
+	}
+
+	// Find the block with the value, starting from the nearest sparse index
+	// entry, which points at the middle value of its span
+	entry := pairs.sparse_index + 6*int(idx/pairs.span)
+	block := int(binary.LittleEndian.Uint32(data[entry:]))
+	offset := int(binary.LittleEndian.Uint16(data[entry+4:]))
+	offset += int(idx%pairs.span) - int(pairs.span/2)
+
+	block_length := func(block int) int {
+		return int(binary.LittleEndian.Uint16(data[pairs.block_length+2*block:]))
+	}
+	for offset < 0 {
+		block--
+		offset += block_length(block) + 1
+	}
+	for offset > block_length(block) {
+		offset -= block_length(block) + 1
+		block++
+	}
+
+	// Read symbols until reaching the one that expands to the value
+	pos := pairs.data + block*int(pairs.block_size)
+	buffer := binary.BigEndian.Uint64(data[pos:])
+	buffer_size := 64
+	pos += 8
+
+	sym := 0
+	for {
+		length := 0
+		for buffer < pairs.base64[length] {
+			length++
+		}
+		sym = int((buffer - pairs.base64[length]) >> (64 - length - int(pairs.min_sym_len)))
+		sym += int(binary.LittleEndian.Uint16(data[pairs.lowest_sym+2*length:]))
+
+		if offset < int(pairs.sym_len[sym])+1 {
+			break
+		}
+		offset -= int(pairs.sym_len[sym]) + 1
+
+		length += int(pairs.min_sym_len)
+		buffer <<= length
+		buffer_size -= length
+		if buffer_size <= 32 {
+			buffer_size += 32
+			buffer |= uint64(binary.BigEndian.Uint32(data[pos:])) << (64 - buffer_size)
+			pos += 4
+		}
+	}
+
+	// Expand the symbol down to the value, the pairs of a symbol stand for
+	// adjacent values
+	for pairs.sym_len[sym] != 0 {
+		left := pairs.left(data, sym)
+		if offset < int(pairs.sym_len[left])+1 {
+			sym = left
+		} else {
+			offset -= int(pairs.sym_len[left]) + 1
+			sym = pairs.right(data, sym)
+		}
+	}
+
+	return pairs.left(data, sym)
+}
+
+// -----------------------------------------------------------------------------
+// 		Probing Tables
+// -----------------------------------------------------------------------------
+
+// Get the WDL or DTZ value stored for the position. DTZ values need the WDL
+// result of the position to be decoded.
+func (table *tb_table) probe(position *chess.Position, file *tb_file, wdl int) (int, int) {
+	if !table.load(file) {
+		return 0, tb_fail
+	}
+
+	pairs, f, idx, state := table.index(position.Board(), position.Turn(), file)
+	if state != tb_ok {
+		return 0, state
+	}
+
+	value := pairs.decompress(file.data, idx)
+	if file.magic == tb_wdl_magic {
+		return value - 2, tb_ok
+	}
+	return table.map_dtz(file, f, value, wdl), tb_ok
+}
+
+// Get the subtable of the file the position is stored in, the file of its
+// leading pawn, and its index in the subtable.
+func (table *tb_table) index(board *chess.Board, turn chess.Color, file *tb_file) (*tb_pairs, int, uint64, int) {
+
+	// Tables are stored with the stronger side as white, and tables with the
+	// same pieces on both sides only store white to move, so swap the colors
+	// and flip the board when needed
+	stm := int(turn)
+	flip := tb_material_key(board, chess.White) != table.key ||
+		(table.key == table.key2 && turn == chess.Black)
+	flip_color, flip_squares := uint8(0), 0
+	if flip {
+		flip_color, flip_squares = 8, 56
+		stm ^= 1
+	}
+
+	var squares [TBPieces]int
+	var pieces [TBPieces]uint8
+	size, lead_pawns_count, tb_file := 0, 0, 0
+	lead_pawns := chess.EmptyBB
+
+	// Tables with pawns have a subtable for each file of the leading pawn,
+	// which is the one furthest toward the edge and then lowest
+	if table.has_pawns {
+		lead := table.pairs(file, 0, 0).pieces[0] ^ flip_color
+		lead_color := chess.Color(lead >> 3)
+		lead_pawns = board.BBForPiece(chess.NewPiece(chess.Pawn, lead_color))
+
+		pawns := lead_pawns
+		for pawns != 0 {
+			squares[size] = int(pawns.PopBit()) ^ flip_squares
+			pieces[size] = lead
+			size++
+		}
+		lead_pawns_count = size
+
+		lead_index := 0
+		for i := 1; i < lead_pawns_count; i++ {
+			if tb_map_pawns[squares[i]] > tb_map_pawns[squares[lead_index]] {
+				lead_index = i
+			}
+		}
+		squares[0], squares[lead_index] = squares[lead_index], squares[0]
+
+		tb_file = Min(squares[0]%8, 7-squares[0]%8)
+	}
+
+	// DTZ tables only store one side to move
+	if file.magic == tb_dtz_magic {
+		flags := table.pairs(file, 0, tb_file).flags
+		if int(flags&tb_stm) != stm && (table.key != table.key2 || table.has_pawns) {
+			return nil, 0, 0, tb_change_stm
+		}
+	}
+
+	occupied := ^board.EmptySqs &^ lead_pawns
+	for occupied != 0 {
+		sq := chess.Square(occupied.PopBit())
+		piece := board.Piece(sq)
+		squares[size] = int(sq) ^ flip_squares
+		pieces[size] = tb_piece(piece) ^ flip_color
+		size++
+	}
+
+	pairs := table.pairs(file, stm, tb_file)
+
+	// Order the pieces like the subtable does
+	for i := lead_pawns_count; i < size-1; i++ {
+		for j := i + 1; j < size; j++ {
+			if pairs.pieces[i] == pieces[j] {
+				pieces[i], pieces[j] = pieces[j], pieces[i]
+				squares[i], squares[j] = squares[j], squares[i]
+				break
+			}
+		}
+	}
+
+	// Mirror the board so the leading piece is on the a to d files
+	if squares[0]%8 > 3 {
+		for i := 0; i < size; i++ {
+			squares[i] ^= 7
+		}
+	}
+
+	idx := uint64(0)
+	if table.has_pawns {
+		// Encode the leading pawns in ascending order after the leading one
+		idx = tb_lead_pawn_idx[lead_pawns_count][squares[0]]
+		others := squares[1:lead_pawns_count]
+		sort.SliceStable(others, func(i, j int) bool {
+			return tb_map_pawns[others[i]] < tb_map_pawns[others[j]]
+		})
+		for i := 1; i < lead_pawns_count; i++ {
+			idx += tb_binomial[i][tb_map_pawns[squares[i]]]
+		}
+	} else {
+		idx = table.lead_pieces_index(&squares, size, pairs)
+	}
+
+	// Encode the remaining groups, each in ascending order of squares. A
+	// square is mapped down for every square of an earlier group it comes
+	// after, and the other pawns can't be on the first or last rank.
+	idx *= pairs.group_idx[0]
+	group := pairs.group_len[0]
+	remaining_pawns := table.has_pawns && table.pawn_count[1] > 0
+	for next := 1; pairs.group_len[next] != 0; next++ {
+		group_squares := squares[group : group+pairs.group_len[next]]
+		sort.Ints(group_squares)
+
+		n := uint64(0)
+		for i, sq := range group_squares {
+			adjust := 0
+			for _, earlier := range squares[:group] {
+				if sq > earlier {
+					adjust++
+				}
+			}
+			if remaining_pawns {
+				adjust += 8
+			}
+			n += tb_binomial[i+1][sq-adjust]
+		}
+
+		remaining_pawns = false
+		idx += n * pairs.group_idx[next]
+		group += pairs.group_len[next]
+	}
+
+	return pairs, tb_file, idx, tb_ok
+}
+
+// Get the index of the leading group of a table without pawns. The board is
+// mirrored so the leading piece is in the a1-d1-d4 triangle, and the first
+// piece off the a1-h8 diagonal is below it.
+func (table *tb_table) lead_pieces_index(squares *[TBPieces]int, size int, pairs *tb_pairs) uint64 {
+	if squares[0]/8 > 3 {
+		for i := 0; i < size; i++ {
+			squares[i] ^= 56
+		}
+	}
+	for i := 0; i < pairs.group_len[0]; i++ {
+		if tb_off_diagonal(squares[i]) == 0 {
+			continue
+		}
+		if tb_off_diagonal(squares[i]) > 0 {
+			for j := i; j < size; j++ {
+				squares[j] = ((squares[j] >> 3) | (squares[j] << 3)) & 63
+			}
+		}
+		break
+	}
+
+	// Without a unique piece apart from the kings, only the kings lead
+	if !table.has_unique_pieces {
+		return uint64(tb_map_kk[tb_map_a1d1d4[squares[0]]][squares[1]])
+	}
+
+	// The three leading pieces are encoded together, with the second and
+	// third mapped down for every earlier piece they come after
+	s0, s1, s2 := squares[0], squares[1], squares[2]
+	adjust1, adjust2 := 0, 0
+	if s1 > s0 {
+		adjust1 = 1
+	}
+	if s2 > s0 {
+		adjust2++
+	}
+	if s2 > s1 {
+		adjust2++
+	}
+
+	if tb_off_diagonal(s0) != 0 {
+		// First piece below the diagonal
+		return uint64((tb_map_a1d1d4[s0]*63+(s1-adjust1))*62 + s2 - adjust2)
+	} else if tb_off_diagonal(s1) != 0 {
+		// First piece on the diagonal, second below it
+		return uint64((6*63+(s0/8)*28+tb_map_b1h1h7[s1])*62 + s2 - adjust2)
+	} else if tb_off_diagonal(s2) != 0 {
+		// First two pieces on the diagonal, third below it
+		return uint64(6*63*62 + 4*28*62 +
+			(s0/8)*7*28 +
+			(s1/8-adjust1)*28 +
+			tb_map_b1h1h7[s2])
+	}
+	// All three pieces on the diagonal
+	return uint64(6*63*62 + 4*28*62 + 4*7*28 +
+		(s0/8)*7*6 +
+		(s1/8-adjust1)*6 +
+		(s2/8 - adjust2))
+}
+
+// Map the value stored in a DTZ table back to the number of plies to the
+// next capture or pawn move. Values are stored in moves rather than plies
+// unless the table says otherwise.
+func (table *tb_table) map_dtz(file *tb_file, f int, value int, wdl int) int {
+	// The map to use for each result, starting from a loss
+	wdl_map := [5]int{1, 3, 0, 2, 0}
+
+	pairs := table.pairs(file, 0, f)
+	if pairs.flags&tb_mapped != 0 {
+		start := pairs.map_idx[wdl_map[wdl+2]]
+		if pairs.flags&tb_wide != 0 {
+			value = int(binary.LittleEndian.Uint16(file.data[start+2*value:]))
+		} else {
+			value = int(file.data[start+value])
+		}
+	}
+
+	if (wdl == WDLWin && pairs.flags&tb_win_plies == 0) ||
+		(wdl == WDLLoss && pairs.flags&tb_loss_plies == 0) ||
+		wdl == WDLCursedWin ||
+		wdl == WDLBlessedLoss {
+		value *= 2
+	}
+	return value + 1
+}
+
+// Get the piece code used by the tables, which numbers the pieces from pawn
+// to king starting at 1, plus 8 for black.
+func tb_piece(piece chess.Piece) uint8 {
+	return uint8(7-piece.Type()) | uint8(piece.Color())<<3
+}
+
+// Probe the table of the position, if there is one.
+func tb_probe_table(position *chess.Position, dtz bool, wdl int) (int, int) {
+	board := position.Board()
+	if (^board.EmptySqs).CountBits() == 2 {
+		return WDLDraw, tb_ok
+	}
+
+	table := syzygy.tables[tb_material_key(board, chess.White)]
+	if table == nil {
+		return 0, tb_fail
+	}
+	if dtz {
+		return table.probe(position, &table.dtz, wdl)
+	}
+	return table.probe(position, &table.wdl, wdl)
+}
+
+// -----------------------------------------------------------------------------
+// 		Probing Positions
+// -----------------------------------------------------------------------------
+
+// Check if the position has few enough pieces and no castling rights, so it
+// can be probed.
+func tb_can_probe(position *chess.Position) bool {
+	return syzygy.max_pieces > 0 &&
+		position.CastleRights() == chess.NoCastleRights &&
+		(^position.Board().EmptySqs).CountBits() <= syzygy.max_pieces
+}
+
+// Check if the move resets the fifty move counter.
+func is_zeroing(position *chess.Position, move *chess.Move) bool {
+	return move.HasTag(chess.Capture) ||
+		move.HasTag(chess.EnPassant) ||
+		position.Board().Piece(move.S1()).Type() == chess.Pawn
+}
+
+// Check if the side to move has a legal move.
+func has_legal_move(position *chess.Position) bool {
+	var buffer [chess.MaxMoves]chess.Move
+	moves := position.GenerateMoves(buffer[:0])
+	for i := range moves {
+		position.MakeMove(&moves[i])
+		legal := !position.KingAttacked(position.Turn().Other())
+		position.UnmakeMove()
+		if legal {
+			return true
+		}
+	}
+	return false
+}
+
+// Get the WDL result of the position. Tables store whatever value compresses
+// best for positions where the side to move has a winning capture, and can
+// store a loss for positions where a capture draws, so the captures have to
+// be searched too. With check_zeroing pawn moves are searched as well, since
+// DTZ tables don't store a useful value when the best move is one.
+func tb_search(position *chess.Position, check_zeroing bool) (int, int) {
+	best, state := WDLLoss, tb_ok
+
+	var buffer [chess.MaxMoves]chess.Move
+	moves := position.GenerateMoves(buffer[:0])
+	legal_moves, searched := 0, 0
+
+	for i := range moves {
+		move := &moves[i]
+		capture := move.HasTag(chess.Capture) || move.HasTag(chess.EnPassant)
+		pawn_move := position.Board().Piece(move.S1()).Type() == chess.Pawn
+
+		position.MakeMove(move)
+		if position.KingAttacked(position.Turn().Other()) {
+			position.UnmakeMove()
+			continue
+		}
+		legal_moves++
+		if !capture && !(check_zeroing && pawn_move) {
+			position.UnmakeMove()
+			continue
+		}
+		searched++
+
+		value, child_state := tb_search(position, false)
+		value = -value
+		position.UnmakeMove()
+
+		if child_state == tb_fail {
+			return WDLDraw, tb_fail
+		}
+		if value > best {
+			best = value
+			if value >= WDLWin {
+				return value, tb_zeroing_best_move
+			}
+		}
+	}
+
+	// If every legal move was searched the table isn't needed, and it could
+	// be wrong since tables don't know about en passant
+	no_more_moves := searched > 0 && searched == legal_moves
+	value := best
+	if !no_more_moves {
+		value, state = tb_probe_table(position, false, WDLDraw)
+		if state == tb_fail {
+			return WDLDraw, tb_fail
+		}
+	}
+
+	if best >= value {
+		if best > WDLDraw || no_more_moves {
+			return best, tb_zeroing_best_move
+		}
+		return best, tb_ok
+	}
+	return value, tb_ok
+}
+
+// Get the WDL result of the position from the side to move's perspective.
+func probe_wdl(position *chess.Position) (int, bool) {
+	wdl, state := tb_search(position, false)
+	return wdl, state != tb_fail
+}
+
+// Get the DTZ of a position right before a capture or pawn move with the
+// given result.
+func dtz_before_zeroing(wdl int) int {
+	switch wdl {
+	case WDLWin:
+		return 1
+	case WDLCursedWin:
+		return 101
+	case WDLBlessedLoss:
+		return -101
+	case WDLLoss:
+		return -1
+	}
+	return 0
+}
+
+// Get the number of plies to the next capture or pawn move with best play,
+// positive if the side to move wins and negative if it loses. Cursed wins
+// and blessed losses are 100 plies further from zero, and draws are 0.
+func probe_dtz(position *chess.Position) (int, bool) {
+	wdl, state := tb_search(position, true)
+	if state == tb_fail {
+		return 0, false
+	}
+	if wdl == WDLDraw {
+		return 0, true
+	}
+	if state == tb_zeroing_best_move {
+		return dtz_before_zeroing(wdl), true
+	}
+
+	dtz, state := tb_probe_table(position, true, wdl)
+	if state == tb_fail {
+		return 0, false
+	}
+	if state != tb_change_stm {
+		if wdl == WDLCursedWin || wdl == WDLBlessedLoss {
+			dtz += 100
+		}
+		if wdl < 0 {
+			dtz = -dtz
+		}
+		return dtz, true
+	}
+
+	// The table only stores the other side to move, so find the best DTZ
+	// with a 1-ply search
+	min_dtz := 0xffff
+
+	var buffer [chess.MaxMoves]chess.Move
+	moves := position.GenerateMoves(buffer[:0])
+	for i := range moves {
+		move := &moves[i]
+		zeroing := is_zeroing(position, move)
+
+		position.MakeMove(move)
+		if position.KingAttacked(position.Turn().Other()) {
+			position.UnmakeMove()
+			continue
+		}
+
+		// For a capture or pawn move, the DTZ right before making it
+		ok := true
+		if zeroing {
+			child_wdl, child_state := tb_search(position, false)
+			dtz, ok = -dtz_before_zeroing(child_wdl), child_state != tb_fail
+		} else {
+			dtz, ok = probe_dtz(position)
+			dtz = -dtz
+		}
+
+		// A mating move is always the best
+		if dtz == 1 && position.InCheck() && !has_legal_move(position) {
+			min_dtz = 1
+		}
+
+		if !zeroing {
+			dtz += sign(dtz)
+		}
+
+		// Skip draws, and only pick wins if winning
+		if dtz < min_dtz && sign(dtz) == sign(wdl) {
+			min_dtz = dtz
+		}
+
+		position.UnmakeMove()
+		if !ok {
+			return 0, false
+		}
+	}
+
+	// Without a legal move the position is mate
+	if min_dtz == 0xffff {
+		return -1, true
+	}
+	return min_dtz, true
+}
+
+func sign(x int) int {
+	if x > 0 {
+		return 1
+	} else if x < 0 {
+		return -1
+	}
+	return 0
+}
+
+// Get the best root moves according to the DTZ tables, which are the moves
+// that convert a win the fastest, hold a draw, or delay a loss the longest.
+// Returns false if a table is missing.
+func tb_root_moves(position *chess.Position, allowed func(*chess.Move) bool) ([]*chess.Move, bool) {
+	best_moves := []*chess.Move{}
+	best_rank := 0
+
+	for _, move := range position.ValidMoves() {
+		if !allowed(move) {
+			continue
+		}
+
+		// Get the DTZ after the move, counted from the root
+		child := position.Update(move)
+		dtz := 0
+		if child.HalfMoveClock() == 0 {
+			wdl, ok := probe_wdl(child)
+			if !ok {
+				return nil, false
+			}
+			dtz = dtz_before_zeroing(-wdl)
+		} else {
+			child_dtz, ok := probe_dtz(child)
+			if !ok {
+				return nil, false
+			}
+			dtz = -child_dtz + sign(-child_dtz)
+		}
+
+		// A mating move always has a DTZ of 1
+		if child.InCheck() && dtz == 2 && len(child.ValidMoves()) == 0 {
+			dtz = 1
+		}
+
+		// Wins rank higher the sooner they convert and losses the longer they
+		// hold out, with draws in between
+		rank := 0
+		if dtz > 0 {
+			rank = 1000 - dtz
+		} else if dtz < 0 {
+			rank = -1000 - dtz
+		}
+
+		if len(best_moves) == 0 || rank > best_rank {
+			best_moves = []*chess.Move{move}
+			best_rank = rank
+		} else if rank == best_rank {
+			best_moves = append(best_moves, move)
+		}
+	}
+
+	return best_moves, len(best_moves) > 0
+}
+
+// Get the score for a WDL result found ply plies from the root. Wins are
+// scored below mate scores, and sooner wins score higher.
+func wdl_to_eval(wdl int, ply int) int {
+	switch wdl {
+	case WDLWin:
+		return TB_WIN_VALUE - ply
+	case WDLLoss:
+		return -TB_WIN_VALUE + ply
+	case WDLCursedWin:
+		return 1
+	case WDLBlessedLoss:
+		return -1
+	}
+	return 0
+}
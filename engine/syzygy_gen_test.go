@@ -0,0 +1,1559 @@
+package engine
+
+import (
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"unicode"
+)
+
+// syzygy_gen_test.go generates the Syzygy tables in testdata/syzygy. It
+// shares no code with the probing code in syzygy.go: positions are solved
+// with a move generator of its own, every index of a subtable is decoded back
+// into a position following the format rather than encoded with the index
+// function of the reader, and the values are compressed like the official
+// generator does, with pairs of symbols and a canonical Huffman code. Run
+//
+//	go test ./engine -run TestGenerateSyzygy -syzygy.generate -timeout 60m
+//
+// to write the tables again.
+
+var generateSyzygy = flag.Bool("syzygy.generate", false, "write the Syzygy tables in testdata/syzygy")
+
+// The tables generated, in the order they're solved, since captures and
+// promotions lead into the tables before them. The pieces are listed in the
+// order the subtables store them, white in upper case, and order gives where
+// the leading group is encoded for each side to move. Longest is the longest
+// DTZ of a win with white to move where published endgame statistics give
+// it: the longest mates of KQvK, KRvK and KBNvK are 10, 16 and 33 moves, and
+// the longest win of KQvKR takes 31 moves to capture the rook or mate.
+var tbGenTables = []struct {
+	name    string
+	pieces  string
+	order   [2]int
+	longest int
+}{
+	{"KQvK", "KQk", [2]int{0, 0}, 19},
+	{"KRvK", "RKk", [2]int{0, 0}, 31},
+	{"KBvK", "kBK", [2]int{0, 0}, 0},
+	{"KNvK", "KkN", [2]int{0, 0}, 0},
+	{"KPvK", "PKk", [2]int{2, 1}, 0},
+	{"KQvKR", "KkQr", [2]int{1, 0}, 61},
+	{"KRRvK", "KkRR", [2]int{0, 1}, 0},
+	{"KBNvK", "BNKk", [2]int{1, 1}, 65},
+}
+
+// The most pieces of a table the generator solves.
+const tbGenMaxPieces = 4
+
+// Limits of the compression. Symbols are numbered in 12 bits with the last
+// number marking a value, a symbol can expand to at most 256 values, a pair
+// has to save more than it costs to store, and a block stores at most 32768
+// values so the sparse index can point past the last one.
+const (
+	tbGenMaxSymbols     = 0xfff
+	tbGenMaxSymbolLen   = 256
+	tbGenMinPairs       = 8
+	tbGenPairsPerRound  = 32
+	tbGenMaxBlockValues = 1 << 15
+)
+
+// The magic numbers of the files and the flags of a subtable, as the format
+// gives them.
+const (
+	tbGenWDLMagic    uint32 = 0x5d23e871
+	tbGenDTZMagic    uint32 = 0xa50c66d7
+	tbGenMapped      uint8  = 2
+	tbGenWinPlies    uint8  = 4
+	tbGenLossPlies   uint8  = 8
+	tbGenWide        uint8  = 16
+	tbGenSingleValue uint8  = 128
+)
+
+// Piece codes of the tables, which number the pieces from pawn to king
+// starting at 1, plus 8 for black.
+const (
+	tbGenPawn   uint8 = 1
+	tbGenKnight uint8 = 2
+	tbGenBishop uint8 = 3
+	tbGenRook   uint8 = 4
+	tbGenQueen  uint8 = 5
+	tbGenKing   uint8 = 6
+	tbGenBlack  uint8 = 8
+)
+
+// The states of a position while it is solved.
+const (
+	tbGenInvalid uint8 = iota
+	tbGenUnknown
+	tbGenWin
+	tbGenLoss
+	tbGenDraw
+)
+
+// Attacks and rays from every square.
+var (
+	tbGenKingAttacks   [64]uint64
+	tbGenKnightAttacks [64]uint64
+	tbGenPawnAttacks   [2][64]uint64
+	tbGenRays          [64][8][]int
+	tbGenBetween       [64][64]uint64
+	tbGenLine          [64][64]uint8
+)
+
+func tbGenInitGeometry() {
+	steps := [][2]int{{1, 0}, {-1, 0}, {0, 1}, {0, -1}, {1, 1}, {1, -1}, {-1, 1}, {-1, -1}}
+	jumps := [][2]int{{1, 2}, {2, 1}, {2, -1}, {1, -2}, {-1, -2}, {-2, -1}, {-2, 1}, {-1, 2}}
+	on_board := func(file, rank int) bool {
+		return file >= 0 && file < 8 && rank >= 0 && rank < 8
+	}
+
+	for sq := 0; sq < 64; sq++ {
+		file, rank := sq%8, sq/8
+		for d, step := range steps {
+			if on_board(file+step[0], rank+step[1]) {
+				tbGenKingAttacks[sq] |= 1 << ((rank+step[1])*8 + file + step[0])
+			}
+
+			// Slide along the ray, the first four directions are the rook's
+			between := uint64(0)
+			for f, r := file+step[0], rank+step[1]; on_board(f, r); f, r = f+step[0], r+step[1] {
+				to := r*8 + f
+				tbGenRays[sq][d] = append(tbGenRays[sq][d], to)
+				tbGenBetween[sq][to] = between
+				tbGenLine[sq][to] = 1 + uint8(d/4)
+				between |= 1 << to
+			}
+		}
+		for _, jump := range jumps {
+			if on_board(file+jump[0], rank+jump[1]) {
+				tbGenKnightAttacks[sq] |= 1 << ((rank+jump[1])*8 + file + jump[0])
+			}
+		}
+		for _, df := range []int{-1, 1} {
+			if on_board(file+df, rank+1) {
+				tbGenPawnAttacks[0][sq] |= 1 << ((rank+1)*8 + file + df)
+			}
+			if on_board(file+df, rank-1) {
+				tbGenPawnAttacks[1][sq] |= 1 << ((rank-1)*8 + file + df)
+			}
+		}
+	}
+}
+
+// Check if the piece on the square attacks the target square.
+func tbGenAttacks(code uint8, from int, to int, occupied uint64) bool {
+	switch code & 7 {
+	case tbGenKing:
+		return tbGenKingAttacks[from]>>to&1 != 0
+	case tbGenKnight:
+		return tbGenKnightAttacks[from]>>to&1 != 0
+	case tbGenPawn:
+		return tbGenPawnAttacks[code>>3][from]>>to&1 != 0
+	}
+
+	line := tbGenLine[from][to]
+	if line == 0 || tbGenBetween[from][to]&occupied != 0 {
+		return false
+	}
+	return code&7 == tbGenQueen || (code&7 == tbGenRook) == (line == 1)
+}
+
+// Get the piece codes of a table name, white then black, like KRvKN.
+func tbGenCodes(name string) []uint8 {
+	codes := []uint8{}
+	for side, pieces := range strings.Split(name, "v") {
+		for _, char := range pieces {
+			codes = append(codes, uint8(strings.IndexRune("PNBRQK", char)+1)|uint8(side)*tbGenBlack)
+		}
+	}
+	return codes
+}
+
+// A position of a table being solved, with the square of every piece of
+// the table, or -1 once it is captured.
+type tbGenPos struct {
+	stm     int
+	squares [tbGenMaxPieces]int
+}
+
+// A move of a position, which leaves the table if it captures or promotes.
+type tbGenMove struct {
+	piece     int
+	to        int
+	captured  int
+	promotion uint8
+}
+
+// A legal move of a position with the position it leads to, which is an
+// index of the table, or -1 with its WDL result if the move leaves the
+// table.
+type tbGenChild struct {
+	move    tbGenMove
+	idx     int
+	wdl     int
+	zeroing bool
+}
+
+// A table with its solved results, indexed by the side to move and then the
+// square of every piece.
+type tbGenTable struct {
+	name  string
+	codes []uint8
+	size  int
+	valid []bool
+	mated []bool
+	wdl   []int8
+	dtz   []int16
+}
+
+func newTBGenTable(name string) *tbGenTable {
+	table := &tbGenTable{name: name, codes: tbGenCodes(name)}
+	table.size = 1 << (6 * len(table.codes))
+	return table
+}
+
+func (table *tbGenTable) encode(pos *tbGenPos) int {
+	idx := pos.stm
+	for i := range table.codes {
+		idx = idx*64 + pos.squares[i]
+	}
+	return idx
+}
+
+func (table *tbGenTable) decode(idx int) tbGenPos {
+	pos := tbGenPos{}
+	for i := len(table.codes) - 1; i >= 0; i-- {
+		pos.squares[i] = idx % 64
+		idx /= 64
+	}
+	pos.stm = idx
+	return pos
+}
+
+func (table *tbGenTable) occupied(pos *tbGenPos) uint64 {
+	occupied := uint64(0)
+	for i := range table.codes {
+		if pos.squares[i] >= 0 {
+			occupied |= 1 << pos.squares[i]
+		}
+	}
+	return occupied
+}
+
+// Check if the king of the color is attacked.
+func (table *tbGenTable) inCheck(pos *tbGenPos, color int) bool {
+	occupied := table.occupied(pos)
+	king := -1
+	for i, code := range table.codes {
+		if code == tbGenKing|uint8(color)*tbGenBlack {
+			king = pos.squares[i]
+		}
+	}
+	for i, code := range table.codes {
+		if int(code>>3) != color && pos.squares[i] >= 0 &&
+			tbGenAttacks(code, pos.squares[i], king, occupied) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check if the position can be reached: no two pieces on a square, no pawn
+// on the first or last rank, and the side that just moved isn't in check.
+func (table *tbGenTable) isValid(pos *tbGenPos) bool {
+	occupied := uint64(0)
+	for i, code := range table.codes {
+		sq := pos.squares[i]
+		if occupied>>sq&1 != 0 || (code&7 == tbGenPawn && (sq < 8 || sq >= 56)) {
+			return false
+		}
+		occupied |= 1 << sq
+	}
+	return !table.inCheck(pos, pos.stm^1)
+}
+
+// Get the pseudo-legal moves of the side to move.
+func (table *tbGenTable) moves(pos *tbGenPos, moves []tbGenMove) []tbGenMove {
+	occupied, own := uint64(0), uint64(0)
+	piece_on := [64]int{}
+	for i, code := range table.codes {
+		if sq := pos.squares[i]; sq >= 0 {
+			occupied |= 1 << sq
+			piece_on[sq] = i + 1
+			if int(code>>3) == pos.stm {
+				own |= 1 << sq
+			}
+		}
+	}
+	add := func(piece int, to int, promotes bool) {
+		move := tbGenMove{piece, to, piece_on[to] - 1, 0}
+		if !promotes {
+			moves = append(moves, move)
+			return
+		}
+		for _, promotion := range []uint8{tbGenQueen, tbGenRook, tbGenBishop, tbGenKnight} {
+			move.promotion = promotion | uint8(pos.stm)*tbGenBlack
+			moves = append(moves, move)
+		}
+	}
+
+	for i, code := range table.codes {
+		from := pos.squares[i]
+		if from < 0 || int(code>>3) != pos.stm {
+			continue
+		}
+
+		switch code & 7 {
+		case tbGenKing, tbGenKnight:
+			targets := tbGenKingAttacks[from]
+			if code&7 == tbGenKnight {
+				targets = tbGenKnightAttacks[from]
+			}
+			for targets &^= own; targets != 0; targets &= targets - 1 {
+				add(i, bits.TrailingZeros64(targets), false)
+			}
+		case tbGenPawn:
+			forward, start, last := 8, 1, 7
+			if pos.stm == 1 {
+				forward, start, last = -8, 6, 0
+			}
+			to := from + forward
+			if occupied>>to&1 == 0 {
+				add(i, to, to/8 == last)
+				if from/8 == start && occupied>>(to+forward)&1 == 0 {
+					add(i, to+forward, false)
+				}
+			}
+			targets := tbGenPawnAttacks[pos.stm][from] & occupied &^ own
+			for ; targets != 0; targets &= targets - 1 {
+				to := bits.TrailingZeros64(targets)
+				add(i, to, to/8 == last)
+			}
+		default:
+			first, last := 0, 8
+			if code&7 == tbGenRook {
+				last = 4
+			} else if code&7 == tbGenBishop {
+				first = 4
+			}
+			for d := first; d < last; d++ {
+				for _, to := range tbGenRays[from][d] {
+					if own>>to&1 != 0 {
+						break
+					}
+					add(i, to, false)
+					if occupied>>to&1 != 0 {
+						break
+					}
+				}
+			}
+		}
+	}
+	return moves
+}
+
+// Call back with every position the side that didn't move could have moved
+// from to reach the position, without captures or promotions, and only with
+// pieces other than pawns unless pawns is set.
+func (table *tbGenTable) unmoves(pos *tbGenPos, pawns bool, callback func(*tbGenPos)) {
+	occupied := table.occupied(pos)
+	prev := *pos
+	prev.stm ^= 1
+
+	for i, code := range table.codes {
+		at := pos.squares[i]
+		if int(code>>3) != prev.stm {
+			continue
+		}
+		from := func(sq int) {
+			prev.squares[i] = sq
+			callback(&prev)
+			prev.squares[i] = at
+		}
+
+		switch code & 7 {
+		case tbGenKing, tbGenKnight:
+			targets := tbGenKingAttacks[at]
+			if code&7 == tbGenKnight {
+				targets = tbGenKnightAttacks[at]
+			}
+			for targets &^= occupied; targets != 0; targets &= targets - 1 {
+				from(bits.TrailingZeros64(targets))
+			}
+		case tbGenPawn:
+			if !pawns {
+				continue
+			}
+			// A pawn comes from the rank behind it, or two ranks behind it
+			// from its starting rank
+			back, start := -8, 1
+			if prev.stm == 1 {
+				back, start = 8, 6
+			}
+			sq := at + back
+			if occupied>>sq&1 != 0 {
+				continue
+			}
+			if sq/8 != start+back/8 {
+				from(sq)
+			}
+			if (sq+back)/8 == start && occupied>>(sq+back)&1 == 0 {
+				from(sq + back)
+			}
+		default:
+			first, last := 0, 8
+			if code&7 == tbGenRook {
+				last = 4
+			} else if code&7 == tbGenBishop {
+				first = 4
+			}
+			for d := first; d < last; d++ {
+				for _, sq := range tbGenRays[at][d] {
+					if occupied>>sq&1 != 0 {
+						break
+					}
+					from(sq)
+				}
+			}
+		}
+	}
+}
+
+// The tables solved so far, by name.
+type tbGenerator struct {
+	t      *testing.T
+	tables map[string]*tbGenTable
+}
+
+func TestGenerateSyzygy(t *testing.T) {
+	if !*generateSyzygy {
+		t.Skip("run with -syzygy.generate to write the tables")
+	}
+	tbGenInitGeometry()
+	tbGenInitIndexes(t)
+
+	gen := &tbGenerator{t, map[string]*tbGenTable{}}
+	dir := filepath.Join("testdata", "syzygy")
+	for _, spec := range tbGenTables {
+		table := gen.solve(spec.name)
+
+		// The longest win must match the published statistics
+		longest, idx := 0, 0
+		for i := 0; i < table.size; i++ {
+			if table.wdl[i] == 2 && int(table.dtz[i]) > longest {
+				longest, idx = int(table.dtz[i]), i
+			}
+		}
+		pos := table.decode(idx)
+		t.Logf("%s: longest win of %d plies in %s", spec.name, longest, table.fen(&pos))
+		if spec.longest != 0 && longest != spec.longest {
+			t.Errorf("%s: the longest win takes %d plies, expected %d", spec.name, longest, spec.longest)
+		}
+
+		for _, ext := range []string{".rtbw", ".rtbz"} {
+			data := gen.write(spec.name, spec.pieces, spec.order, table, ext == ".rtbz")
+			if err := os.WriteFile(filepath.Join(dir, spec.name+ext), data, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// Only the WDL results are needed to solve the larger tables
+		table.valid, table.mated, table.dtz = nil, nil, nil
+		gen.tables[spec.name] = table
+	}
+}
+
+// Get the FEN of a position of the table.
+func (table *tbGenTable) fen(pos *tbGenPos) string {
+	var board [64]byte
+	for i, code := range table.codes {
+		board[pos.squares[i]] = "PNBRQK"[code&7-1] + (code>>3)*('a'-'A')
+	}
+
+	fen := ""
+	for rank := 7; rank >= 0; rank-- {
+		empty := 0
+		for file := 0; file < 8; file++ {
+			if board[rank*8+file] == 0 {
+				empty++
+				continue
+			}
+			if empty > 0 {
+				fen += fmt.Sprint(empty)
+				empty = 0
+			}
+			fen += string(board[rank*8+file])
+		}
+		if empty > 0 {
+			fen += fmt.Sprint(empty)
+		}
+		if rank > 0 {
+			fen += "/"
+		}
+	}
+	return fen + []string{" w", " b"}[pos.stm] + " - - 0 1"
+}
+
+// Get the WDL result of a position that left the table for a smaller one,
+// for its side to move.
+func (gen *tbGenerator) exitWDL(codes []uint8, squares []int, stm int) int {
+	sides := [2]string{}
+	count := 0
+	for kind := tbGenKing; kind >= tbGenPawn; kind-- {
+		for i, code := range codes {
+			if squares[i] >= 0 && code&7 == kind {
+				sides[code>>3] += string("PNBRQK"[kind-1])
+				count++
+			}
+		}
+	}
+	if count == 2 {
+		return WDLDraw
+	}
+
+	// Tables are solved with the stronger side as white
+	flip := 0
+	table := gen.tables[sides[0]+"v"+sides[1]]
+	if table == nil {
+		flip = 1
+		table = gen.tables[sides[1]+"v"+sides[0]]
+	}
+	if table == nil {
+		gen.t.Fatalf("%sv%s isn't solved", sides[0], sides[1])
+	}
+
+	pos := tbGenPos{stm: stm ^ flip}
+	used := [tbGenMaxPieces]bool{}
+	for slot, code := range table.codes {
+		for i := range codes {
+			if !used[i] && squares[i] >= 0 && codes[i]^uint8(flip)*tbGenBlack == code {
+				pos.squares[slot] = squares[i] ^ flip*56
+				used[i] = true
+				break
+			}
+		}
+	}
+	return int(table.wdl[table.encode(&pos)])
+}
+
+// Get the legal moves of the position with the positions they lead to.
+func (gen *tbGenerator) children(table *tbGenTable, pos *tbGenPos, moves []tbGenMove, children []tbGenChild) []tbGenChild {
+	for _, move := range table.moves(pos, moves[:0]) {
+		child := *pos
+		child.stm ^= 1
+		child.squares[move.piece] = move.to
+		if move.captured >= 0 {
+			child.squares[move.captured] = -1
+		}
+		if table.inCheck(&child, pos.stm) {
+			continue
+		}
+
+		zeroing := move.captured >= 0 || table.codes[move.piece]&7 == tbGenPawn
+		if move.captured < 0 && move.promotion == 0 {
+			children = append(children, tbGenChild{move, table.encode(&child), 0, zeroing})
+			continue
+		}
+
+		var codes [tbGenMaxPieces]uint8
+		copy(codes[:], table.codes)
+		if move.promotion != 0 {
+			codes[move.piece] = move.promotion
+		}
+		n := len(table.codes)
+		wdl := gen.exitWDL(codes[:n], child.squares[:n], child.stm)
+		children = append(children, tbGenChild{move, -1, wdl, zeroing})
+	}
+	return children
+}
+
+// Get the WDL result of the position a move leads to, for the side to move
+// there.
+func (table *tbGenTable) childWDL(child *tbGenChild) int {
+	if child.idx < 0 {
+		return child.wdl
+	}
+	return int(table.wdl[child.idx])
+}
+
+// Solve every position of the table by retrograde analysis, first the WDL
+// results ignoring the fifty move rule and then the DTZ of the wins and
+// losses.
+func (gen *tbGenerator) solve(name string) *tbGenTable {
+	table := newTBGenTable(name)
+	total := 2 * table.size
+	table.valid = make([]bool, total)
+	table.mated = make([]bool, total)
+	state := make([]uint8, total)
+	count := make([]uint8, total)
+	draws := make([]bool, total)
+	queue := []int32{}
+	moves := make([]tbGenMove, 0, 256)
+	children := make([]tbGenChild, 0, 256)
+
+	// Decide the positions without moves, and the ones where a move out of
+	// the table wins or every move leaves it. The rest are decided by the
+	// moves inside the table, which are counted.
+	for idx := 0; idx < total; idx++ {
+		pos := table.decode(idx)
+		if !table.isValid(&pos) {
+			continue
+		}
+		table.valid[idx] = true
+		state[idx] = tbGenUnknown
+
+		children = gen.children(table, &pos, moves, children[:0])
+		inside, best := 0, WDLLoss
+		for i := range children {
+			if children[i].idx >= 0 {
+				inside++
+			} else {
+				best = Max(best, -children[i].wdl)
+			}
+		}
+
+		switch {
+		case len(children) == 0 && table.inCheck(&pos, pos.stm):
+			state[idx] = tbGenLoss
+			table.mated[idx] = true
+		case len(children) == 0:
+			state[idx] = tbGenDraw
+		case best == WDLWin:
+			state[idx] = tbGenWin
+		case inside == 0 && best == WDLDraw:
+			state[idx] = tbGenDraw
+		case inside == 0:
+			state[idx] = tbGenLoss
+		}
+		count[idx] = uint8(inside)
+		draws[idx] = best == WDLDraw
+		if state[idx] == tbGenWin || state[idx] == tbGenLoss {
+			queue = append(queue, int32(idx))
+		}
+	}
+
+	// A position that can move to a loss is won, and one where every move
+	// goes to a win is lost, unless a move out of the table draws
+	for head := 0; head < len(queue); head++ {
+		pos := table.decode(int(queue[head]))
+		lost := state[queue[head]] == tbGenLoss
+		table.unmoves(&pos, true, func(prev *tbGenPos) {
+			idx := table.encode(prev)
+			if state[idx] != tbGenUnknown {
+				return
+			}
+			if lost {
+				state[idx] = tbGenWin
+				queue = append(queue, int32(idx))
+				return
+			}
+			count[idx]--
+			if count[idx] == 0 && !draws[idx] {
+				state[idx] = tbGenLoss
+				queue = append(queue, int32(idx))
+			}
+		})
+	}
+
+	table.wdl = make([]int8, total)
+	for idx, s := range state {
+		if s == tbGenWin {
+			table.wdl[idx] = int8(WDLWin)
+		} else if s == tbGenLoss {
+			table.wdl[idx] = int8(WDLLoss)
+		}
+	}
+
+	// A win takes one ply if a capture, pawn move or mate wins, and a loss
+	// takes one ply if it has only captures and pawn moves. Otherwise a win
+	// takes one ply more than the shortest loss it can move to, and a loss
+	// one ply more than the longest win it has to move to.
+	table.dtz = make([]int16, total)
+	dtz := table.dtz
+	queue = queue[:0]
+	for idx := 0; idx < total; idx++ {
+		if table.wdl[idx] == 0 {
+			continue
+		}
+		pos := table.decode(idx)
+		children = gen.children(table, &pos, moves, children[:0])
+
+		if table.wdl[idx] == int8(WDLWin) {
+			for i := range children {
+				child := &children[i]
+				if table.childWDL(child) == WDLLoss &&
+					(child.zeroing || table.mated[child.idx]) {
+					dtz[idx] = 1
+				}
+			}
+		} else {
+			quiet := 0
+			for i := range children {
+				if !children[i].zeroing {
+					quiet++
+				}
+			}
+			count[idx] = uint8(quiet)
+			if quiet == 0 {
+				dtz[idx] = -1
+			}
+		}
+		if dtz[idx] != 0 {
+			queue = append(queue, int32(idx))
+		}
+	}
+
+	for head := 0; head < len(queue); head++ {
+		pos := table.decode(int(queue[head]))
+		d := dtz[queue[head]]
+		table.unmoves(&pos, false, func(prev *tbGenPos) {
+			idx := table.encode(prev)
+			if dtz[idx] != 0 {
+				return
+			}
+			if d < 0 && table.wdl[idx] == int8(WDLWin) {
+				dtz[idx] = -d + 1
+				queue = append(queue, int32(idx))
+			} else if d > 0 && table.wdl[idx] == int8(WDLLoss) {
+				count[idx]--
+				if count[idx] == 0 {
+					dtz[idx] = -d - 1
+					queue = append(queue, int32(idx))
+				}
+			}
+		})
+	}
+
+	for idx := 0; idx < total; idx++ {
+		if table.wdl[idx] != 0 && (dtz[idx] == 0 || abs(int(dtz[idx])) > 100) {
+			pos := table.decode(idx)
+			gen.t.Fatalf("%s: %s has a DTZ of %d, cursed results aren't generated",
+				name, table.fen(&pos), dtz[idx])
+		}
+	}
+
+	return table
+}
+
+// Squares in the order the leading pieces of tables without pawns are
+// numbered: the a1-d1-d4 triangle below the a1-h8 diagonal and then on it,
+// the squares below the diagonal, and the pairs of kings with the first one
+// in the triangle and the second one not above the diagonal if the first is
+// on it, with the pairs on the diagonal last.
+var (
+	tbGenTriangle = [10]int{1, 2, 3, 10, 11, 19, 0, 9, 18, 27}
+	tbGenBelow    []int
+	tbGenKK       [][2]int
+)
+
+func tbGenInitIndexes(t *testing.T) {
+	above := func(sq int) int { return sq/8 - sq%8 }
+
+	tbGenBelow = nil
+	for sq := 0; sq < 64; sq++ {
+		if above(sq) < 0 {
+			tbGenBelow = append(tbGenBelow, sq)
+		}
+	}
+
+	tbGenKK = nil
+	diagonal := [][2]int{}
+	for _, k1 := range tbGenTriangle {
+		for k2 := 0; k2 < 64; k2++ {
+			switch {
+			case k2 == k1 || tbGenKingAttacks[k1]>>k2&1 != 0:
+			case above(k1) == 0 && above(k2) > 0:
+			case above(k1) == 0 && above(k2) == 0:
+				diagonal = append(diagonal, [2]int{k1, k2})
+			default:
+				tbGenKK = append(tbGenKK, [2]int{k1, k2})
+			}
+		}
+	}
+	tbGenKK = append(tbGenKK, diagonal...)
+
+	if len(tbGenBelow) != 28 || len(tbGenKK) != 462 {
+		t.Fatalf("%d squares below the diagonal and %d pairs of kings", len(tbGenBelow), len(tbGenKK))
+	}
+}
+
+// Get the n-th number, counting from 0, that isn't taken.
+func tbGenSkip(n int, taken ...int) int {
+	sort.Ints(taken)
+	for _, sq := range taken {
+		if n >= sq {
+			n++
+		}
+	}
+	return n
+}
+
+func tbGenBinomial(n int, k int) int {
+	if k > n {
+		return 0
+	}
+	result := 1
+	for i := 0; i < k; i++ {
+		result = result * (n - i) / (i + 1)
+	}
+	return result
+}
+
+// Get the squares of the three leading pieces of a table without pawns with
+// a unique piece, which are numbered with the first piece below the a1-h8
+// diagonal, then on it with the second below it, then with the first two on
+// it and the third below it, and last with all three on it. A later piece
+// skips the squares of the earlier ones.
+func tbGenLeadSquares(idx int) (int, int, int) {
+	if idx < 6*63*62 {
+		s0 := tbGenTriangle[idx/(63*62)]
+		s1 := tbGenSkip(idx/62%63, s0)
+		return s0, s1, tbGenSkip(idx%62, s0, s1)
+	}
+	idx -= 6 * 63 * 62
+	if idx < 4*28*62 {
+		s0, s1 := idx/(28*62)*9, tbGenBelow[idx/62%28]
+		return s0, s1, tbGenSkip(idx%62, s0, s1)
+	}
+	idx -= 4 * 28 * 62
+	if idx < 4*7*28 {
+		d0 := idx / (7 * 28)
+		return d0 * 9, tbGenSkip(idx/28%7, d0) * 9, tbGenBelow[idx%28]
+	}
+	idx -= 4 * 7 * 28
+	d0 := idx / 42
+	d1 := tbGenSkip(idx/6%7, d0)
+	return d0 * 9, d1 * 9, tbGenSkip(idx%6, d0, d1) * 9
+}
+
+// How the positions of a subtable are numbered: the pieces in the order the
+// subtable stores them, the groups of pieces encoded together with the
+// leading group first, and the number of ways to place each group and what
+// its index is multiplied by.
+type tbGenLayout struct {
+	pieces []uint8
+	slots  []int
+	groups []int
+	sizes  []int
+	factor []int
+	size   int
+	pawns  bool
+	unique bool
+	file   int
+}
+
+func (gen *tbGenerator) layout(table *tbGenTable, pieces string, order int, file int) *tbGenLayout {
+	layout := &tbGenLayout{file: file}
+	for _, char := range pieces {
+		code := uint8(strings.IndexRune("PNBRQK", unicode.ToUpper(char)) + 1)
+		if unicode.IsLower(char) {
+			code |= tbGenBlack
+		}
+		layout.pieces = append(layout.pieces, code)
+		layout.pawns = layout.pawns || code&7 == tbGenPawn
+	}
+	for _, code := range layout.pieces {
+		count := 0
+		for _, other := range layout.pieces {
+			if other == code {
+				count++
+			}
+		}
+		layout.unique = layout.unique || (code&7 != tbGenKing && count == 1)
+	}
+
+	// The piece of the solved table each stored piece stands for
+	used := make([]bool, len(table.codes))
+	for _, code := range layout.pieces {
+		slot := 0
+		for slot < len(table.codes) && (used[slot] || table.codes[slot] != code) {
+			slot++
+		}
+		if slot == len(table.codes) {
+			gen.t.Fatalf("%s: the pieces %s aren't the table's", table.name, pieces)
+		}
+		used[slot] = true
+		layout.slots = append(layout.slots, slot)
+	}
+
+	// The leading group is a single leading pawn, three pieces if one is
+	// unique, or else the two kings, and the other groups are the pieces of
+	// the same type and color
+	lead := 2
+	if layout.pawns {
+		lead = 1
+		if layout.pieces[0]&7 != tbGenPawn || layout.pieces[1] == layout.pieces[0] {
+			gen.t.Fatalf("%s: only one leading pawn is supported, listed first", table.name)
+		}
+		for _, code := range layout.pieces {
+			if code&7 == tbGenPawn && code != layout.pieces[0] {
+				gen.t.Fatalf("%s: pawns of both colors aren't supported", table.name)
+			}
+		}
+	} else if layout.unique {
+		lead = 3
+	} else if layout.pieces[0]&7 != tbGenKing || layout.pieces[1]&7 != tbGenKing {
+		gen.t.Fatalf("%s: the kings have to lead without a unique piece", table.name)
+	}
+	if lead < len(layout.pieces) && layout.pieces[lead] == layout.pieces[lead-1] {
+		gen.t.Fatalf("%s: a piece after the leading group can't be the same as the last one", table.name)
+	}
+	layout.groups = []int{lead}
+	for i := lead; i < len(layout.pieces); i++ {
+		if i > lead && layout.pieces[i] == layout.pieces[i-1] {
+			layout.groups[len(layout.groups)-1]++
+		} else {
+			layout.groups = append(layout.groups, 1)
+		}
+	}
+
+	free := 64
+	for g, n := range layout.groups {
+		switch {
+		case g > 0:
+			layout.sizes = append(layout.sizes, tbGenBinomial(free, n))
+		case layout.pawns:
+			layout.sizes = append(layout.sizes, 6)
+		case layout.unique:
+			layout.sizes = append(layout.sizes, 31332)
+		default:
+			layout.sizes = append(layout.sizes, 462)
+		}
+		free -= n
+	}
+
+	// The leading group is encoded in the given place, counting from the
+	// least significant, and the others in order around it
+	if order >= len(layout.groups) {
+		gen.t.Fatalf("%s: can't encode the leading group after %d groups", table.name, len(layout.groups))
+	}
+	layout.factor = make([]int, len(layout.groups))
+	layout.size = 1
+	for k, next := 0, 1; k < len(layout.groups); k++ {
+		g := next
+		if k == order {
+			g = 0
+		} else {
+			next++
+		}
+		layout.factor[g] = layout.size
+		layout.size *= layout.sizes[g]
+	}
+
+	return layout
+}
+
+// Get the position at an index of the subtable.
+func (layout *tbGenLayout) position(idx int, stm int) tbGenPos {
+	var squares [tbGenMaxPieces]int
+	digit := func(g int) int {
+		return idx / layout.factor[g] % layout.sizes[g]
+	}
+
+	lead := digit(0)
+	switch {
+	case layout.pawns:
+		squares[0] = layout.file + 8*(lead+1)
+	case layout.unique:
+		squares[0], squares[1], squares[2] = tbGenLeadSquares(lead)
+	default:
+		squares[0], squares[1] = tbGenKK[lead][0], tbGenKK[lead][1]
+	}
+
+	// The other groups are numbered by the combinations of the squares the
+	// earlier groups leave free, in ascending order
+	placed := layout.groups[0]
+	for g := 1; g < len(layout.groups); g++ {
+		n := digit(g)
+		size := layout.groups[g]
+		for i := size - 1; i >= 0; i-- {
+			c := i
+			for tbGenBinomial(c+1, i+1) <= n {
+				c++
+			}
+			n -= tbGenBinomial(c, i+1)
+			squares[placed+i] = c
+		}
+		for i := 0; i < size; i++ {
+			squares[placed+i] = tbGenSkip(squares[placed+i], append([]int{}, squares[:placed]...)...)
+		}
+		placed += size
+	}
+
+	pos := tbGenPos{stm: stm}
+	for k, slot := range layout.slots {
+		pos.squares[slot] = squares[k]
+	}
+	return pos
+}
+
+// How much a value of a subtable matters: it has to be stored, it can be
+// anything, or it can be anything up to the result, since a capture gets
+// the result anyway.
+const (
+	tbGenCare uint8 = iota
+	tbGenAny
+	tbGenAtMost
+)
+
+// Get the values of a subtable and how much each matters. WDL values are
+// the results plus 2, and DTZ values the DTZ in plies.
+func (gen *tbGenerator) values(table *tbGenTable, layout *tbGenLayout, stm int, dtz bool) ([]int, []uint8) {
+	values := make([]int, layout.size)
+	modes := make([]uint8, layout.size)
+	moves := make([]tbGenMove, 0, 256)
+	children := make([]tbGenChild, 0, 256)
+
+	for i := range values {
+		pos := layout.position(i, stm)
+		idx := table.encode(&pos)
+		if !table.valid[idx] {
+			modes[i] = tbGenAny
+			continue
+		}
+		wdl := int(table.wdl[idx])
+		if dtz && wdl == WDLDraw {
+			modes[i] = tbGenAny
+			continue
+		}
+
+		children = gen.children(table, &pos, moves, children[:0])
+		captures, zeroing, zeroing_win := WDLLoss-1, 0, false
+		for j := range children {
+			child := &children[j]
+			value := -table.childWDL(child)
+			if child.move.captured >= 0 {
+				captures = Max(captures, value)
+			}
+			if child.zeroing {
+				zeroing++
+				zeroing_win = zeroing_win || value == WDLWin
+			}
+		}
+
+		if !dtz {
+			values[i] = wdl + 2
+			if captures >= wdl {
+				modes[i] = tbGenAtMost
+			}
+			continue
+		}
+
+		// The DTZ isn't probed when a capture or pawn move wins, or when a
+		// loss has nothing else
+		values[i] = int(table.dtz[idx])
+		if (wdl == WDLWin && zeroing_win) ||
+			(wdl == WDLLoss && len(children) > 0 && zeroing == len(children)) {
+			modes[i] = tbGenAny
+		}
+	}
+	return values, modes
+}
+
+// Fill in the values that don't matter with the value before them, which
+// compresses best, or the highest value allowed.
+func tbGenFill(values []int, modes []uint8) {
+	prev := 0
+	for i := range values {
+		if modes[i] == tbGenCare {
+			prev = values[i]
+			break
+		}
+	}
+	for i := range values {
+		switch modes[i] {
+		case tbGenAny:
+			values[i] = prev
+		case tbGenAtMost:
+			values[i] = Min(values[i], prev)
+		}
+		prev = values[i]
+	}
+}
+
+// Map the DTZ values of a subtable to the values stored, which are moves
+// rather than plies for the wins or losses if they're all odd, numbered in
+// a map for each result unless every value is stored.
+func tbGenMapDTZ(values []int, modes []uint8, stm int) ([]int, uint8, [4][]int) {
+	flags := uint8(stm)
+	odd := [2]bool{true, true}
+	for i, value := range values {
+		if modes[i] == tbGenCare && abs(value)%2 == 0 {
+			odd[(1-sign(value))/2] = false
+		}
+	}
+	if !odd[0] {
+		flags |= tbGenWinPlies
+	}
+	if !odd[1] {
+		flags |= tbGenLossPlies
+	}
+
+	stored := func(value int) int {
+		if odd[(1-sign(value))/2] {
+			return (abs(value) - 1) / 2
+		}
+		return abs(value) - 1
+	}
+
+	// The maps list the stored values of wins and losses in order
+	var maps [4][]int
+	seen := [2]map[int]bool{{}, {}}
+	for i, value := range values {
+		result := (1 - sign(value)) / 2
+		if modes[i] == tbGenCare && !seen[result][stored(value)] {
+			seen[result][stored(value)] = true
+			maps[result] = append(maps[result], stored(value))
+		}
+	}
+	mapped := false
+	for result := 0; result < 2; result++ {
+		sort.Ints(maps[result])
+		for j, value := range maps[result] {
+			mapped = mapped || value != j
+			if value > 0xff {
+				flags |= tbGenWide
+			}
+		}
+	}
+	if mapped {
+		flags |= tbGenMapped
+	} else {
+		maps = [4][]int{}
+	}
+
+	symbols := make([]int, len(values))
+	for i, value := range values {
+		if modes[i] != tbGenCare {
+			continue
+		}
+		symbols[i] = stored(value)
+		if mapped {
+			symbols[i] = sort.SearchInts(maps[(1-sign(value))/2], stored(value))
+		}
+	}
+	tbGenFill(symbols, modes)
+	return symbols, flags, maps
+}
+
+// Write the WDL or DTZ file of a table.
+func (gen *tbGenerator) write(name string, pieces string, order [2]int, table *tbGenTable, dtz bool) []byte {
+	pawns := strings.Contains(name, "P")
+	files, sides := 1, 2
+	if pawns {
+		files = 4
+	}
+	if dtz {
+		sides = 1
+	}
+
+	data := binary.LittleEndian.AppendUint32(nil, tbGenWDLMagic)
+	if dtz {
+		data = binary.LittleEndian.AppendUint32(nil, tbGenDTZMagic)
+	}
+
+	// Both sides to move are stored separately since they have different
+	// pieces, and the pieces of both sides to move are listed together
+	flags := byte(1)
+	if pawns {
+		flags |= 2
+	}
+	data = append(data, flags)
+
+	var layouts [4][2]*tbGenLayout
+	for f := 0; f < files; f++ {
+		for i := 0; i < 2; i++ {
+			layouts[f][i] = gen.layout(table, pieces, order[i], f)
+		}
+		if dtz {
+			data = append(data, byte(order[0]))
+		} else {
+			data = append(data, byte(order[0]|order[1]<<4))
+		}
+		for k, code := range layouts[f][0].pieces {
+			if dtz {
+				data = append(data, code)
+			} else {
+				data = append(data, code|layouts[f][1].pieces[k]<<4)
+			}
+		}
+	}
+	data = append(data, make([]byte, len(data)&1)...)
+
+	// DTZ files store the side to move that compresses best
+	var subtables [4][2]*tbGenSubtable
+	for f := 0; f < files; f++ {
+		for i := 0; i < sides; i++ {
+			if !dtz {
+				values, modes := gen.values(table, layouts[f][i], i, false)
+				tbGenFill(values, modes)
+				subtables[f][i] = tbGenCompress(values, 0)
+				continue
+			}
+
+			for stm := 0; stm < 2; stm++ {
+				values, modes := gen.values(table, layouts[f][0], stm, true)
+				symbols, flags, maps := tbGenMapDTZ(values, modes, stm)
+				subtable := tbGenCompress(symbols, flags)
+				subtable.maps = maps
+				if subtables[f][0] == nil || subtable.bytes() < subtables[f][0].bytes() {
+					subtables[f][0] = subtable
+				}
+			}
+		}
+	}
+
+	for f := 0; f < files; f++ {
+		for i := 0; i < sides; i++ {
+			data = append(data, subtables[f][i].sizes...)
+		}
+	}
+	if dtz {
+		for f := 0; f < files; f++ {
+			subtable := subtables[f][0]
+			if subtable.flags&tbGenMapped == 0 {
+				continue
+			}
+			if subtable.flags&tbGenWide != 0 {
+				data = append(data, make([]byte, len(data)&1)...)
+			}
+			for _, values := range subtable.maps {
+				if subtable.flags&tbGenWide != 0 {
+					data = binary.LittleEndian.AppendUint16(data, uint16(len(values)))
+					for _, value := range values {
+						data = binary.LittleEndian.AppendUint16(data, uint16(value))
+					}
+				} else {
+					data = append(data, byte(len(values)))
+					for _, value := range values {
+						data = append(data, byte(value))
+					}
+				}
+			}
+		}
+		data = append(data, make([]byte, len(data)&1)...)
+	}
+	for f := 0; f < files; f++ {
+		for i := 0; i < sides; i++ {
+			data = append(data, subtables[f][i].sparse...)
+		}
+	}
+	for f := 0; f < files; f++ {
+		for i := 0; i < sides; i++ {
+			data = append(data, subtables[f][i].lengths...)
+		}
+	}
+	for f := 0; f < files; f++ {
+		for i := 0; i < sides; i++ {
+			data = append(data, make([]byte, -len(data)&0x3f)...)
+			data = append(data, subtables[f][i].blocks...)
+		}
+	}
+	return data
+}
+
+// A compressed subtable: its sizes and Huffman code as stored in the header,
+// the DTZ maps, the sparse index, the number of values in each block minus
+// one, and the blocks.
+type tbGenSubtable struct {
+	flags   uint8
+	sizes   []byte
+	maps    [4][]int
+	sparse  []byte
+	lengths []byte
+	blocks  []byte
+}
+
+func (subtable *tbGenSubtable) bytes() int {
+	size := len(subtable.sizes) + len(subtable.sparse) + len(subtable.lengths) + len(subtable.blocks)
+	for _, values := range subtable.maps {
+		size += len(values) + 1
+	}
+	return size
+}
+
+// A symbol of a subtable, which is either a value or a pair of symbols, and
+// the number of values it expands to.
+type tbGenSymbol struct {
+	left   int
+	right  int
+	length int
+}
+
+// Compress the values of a subtable, which are replaced by symbols for the
+// most common pairs of symbols over and over, and then Huffman coded.
+func tbGenCompress(values []int, flags uint8) *tbGenSubtable {
+	subtable := &tbGenSubtable{flags: flags}
+
+	single := true
+	for _, value := range values {
+		single = single && value == values[0]
+	}
+	if single {
+		subtable.sizes = []byte{flags | tbGenSingleValue, byte(values[0])}
+		return subtable
+	}
+
+	symbols := []tbGenSymbol{}
+	value_symbol := map[int]uint16{}
+	sequence := make([]uint16, len(values))
+	for i, value := range values {
+		if _, ok := value_symbol[value]; !ok {
+			value_symbol[value] = uint16(len(symbols))
+			symbols = append(symbols, tbGenSymbol{value, -1, 1})
+		}
+		sequence[i] = value_symbol[value]
+	}
+	sequence, symbols = tbGenPairs(sequence, symbols)
+
+	// Number the symbols by the length of their code, longest first, with the
+	// symbols that only appear in pairs last
+	frequency := make([]int, len(symbols))
+	for _, sym := range sequence {
+		frequency[sym]++
+	}
+	lengths := tbGenCodeLengths(frequency)
+	order := make([]int, len(symbols))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return lengths[order[i]] > lengths[order[j]]
+	})
+	number := make([]int, len(symbols))
+	for i, sym := range order {
+		number[sym] = i
+	}
+
+	// Codes of the same length are consecutive, starting from the lowest
+	// code of the length, which is half of what the codes one bit longer end
+	// at
+	min_len, max_len := 64, 0
+	count := make([]int, 34)
+	for _, length := range lengths {
+		if length > 0 {
+			min_len, max_len = Min(min_len, length), Max(max_len, length)
+			count[length]++
+		}
+	}
+	lowest := make([]int, 34)
+	base := make([]uint64, 34)
+	for length := max_len - 1; length >= min_len; length-- {
+		lowest[length] = lowest[length+1] + count[length+1]
+		base[length] = (base[length+1] + uint64(count[length+1])) / 2
+	}
+	codes := make([]uint64, len(symbols))
+	for sym, length := range lengths {
+		if length > 0 {
+			codes[sym] = base[length] + uint64(number[sym]-lowest[length])
+		}
+	}
+
+	// Pack the codes into blocks, which hold whole symbols
+	total_bits := 0
+	for _, sym := range sequence {
+		total_bits += lengths[sym]
+	}
+	block_bits := 6
+	if total_bits > 8<<14 {
+		block_bits = 8
+	}
+	block_size := 1 << block_bits
+
+	block := make([]byte, block_size)
+	bit, block_values := 0, 0
+	starts := []int{0}
+	flush := func() {
+		subtable.blocks = append(subtable.blocks, block...)
+		subtable.lengths = binary.LittleEndian.AppendUint16(subtable.lengths, uint16(block_values-1))
+		starts = append(starts, starts[len(starts)-1]+block_values)
+		block = make([]byte, block_size)
+		bit, block_values = 0, 0
+	}
+	for _, sym := range sequence {
+		if bit+lengths[sym] > 8*block_size || block_values+symbols[sym].length > tbGenMaxBlockValues {
+			flush()
+		}
+		for j := lengths[sym] - 1; j >= 0; j-- {
+			if codes[sym]>>j&1 != 0 {
+				block[bit/8] |= 0x80 >> (bit % 8)
+			}
+			bit++
+		}
+		block_values += symbols[sym].length
+	}
+	flush()
+	blocks := len(starts) - 1
+
+	// The sparse index points at the middle value of every span, which is
+	// about the number of values in a block
+	span_bits := 0
+	for span_bits < 15 && 2<<span_bits <= len(values)/blocks {
+		span_bits++
+	}
+	span := 1 << span_bits
+	for k, b := 0, 0; k*span < len(values); k++ {
+		middle := k*span + span/2
+		for b+1 < blocks && starts[b+1] <= middle {
+			b++
+		}
+		subtable.sparse = binary.LittleEndian.AppendUint32(subtable.sparse, uint32(b))
+		subtable.sparse = binary.LittleEndian.AppendUint16(subtable.sparse, uint16(middle-starts[b]))
+	}
+
+	sizes := []byte{flags, byte(block_bits), byte(span_bits), 0}
+	sizes = binary.LittleEndian.AppendUint32(sizes, uint32(blocks))
+	sizes = append(sizes, byte(max_len), byte(min_len))
+	for length := min_len; length <= max_len; length++ {
+		sizes = binary.LittleEndian.AppendUint16(sizes, uint16(lowest[length]))
+	}
+	sizes = binary.LittleEndian.AppendUint16(sizes, uint16(len(symbols)))
+	for _, sym := range order {
+		left, right := symbols[sym].left, 0xfff
+		if symbols[sym].right >= 0 {
+			left, right = number[left], number[symbols[sym].right]
+		}
+		sizes = append(sizes, byte(left), byte(left>>8)|byte(right<<4), byte(right>>4))
+	}
+	subtable.sizes = append(sizes, make([]byte, len(symbols)&1)...)
+
+	return subtable
+}
+
+// Replace the most common pairs of adjacent symbols in the sequence with new
+// symbols until no pair is common enough. Returns the new sequence and
+// symbols.
+func tbGenPairs(sequence []uint16, symbols []tbGenSymbol) ([]uint16, []tbGenSymbol) {
+	counts := make([]int32, 1<<24)
+	pairs := []uint32{}
+
+	for len(symbols) < tbGenMaxSymbols {
+		// Count the pairs, without counting overlapping pairs in a run of the
+		// same symbol twice
+		pairs = pairs[:0]
+		last, last_pair := -2, uint32(0)
+		for i := 0; i+1 < len(sequence); i++ {
+			pair := uint32(sequence[i])<<12 | uint32(sequence[i+1])
+			if pair == last_pair && last == i-1 {
+				continue
+			}
+			if counts[pair] == 0 {
+				pairs = append(pairs, pair)
+			}
+			counts[pair]++
+			last, last_pair = i, pair
+		}
+
+		common := []uint32{}
+		for _, pair := range pairs {
+			if counts[pair] >= tbGenMinPairs &&
+				symbols[pair>>12].length+symbols[pair&0xfff].length <= tbGenMaxSymbolLen {
+				common = append(common, pair)
+			}
+		}
+		sort.Slice(common, func(i, j int) bool {
+			if counts[common[i]] != counts[common[j]] {
+				return counts[common[i]] > counts[common[j]]
+			}
+			return common[i] < common[j]
+		})
+		for _, pair := range pairs {
+			counts[pair] = 0
+		}
+		if len(common) == 0 {
+			break
+		}
+		common = common[:Min(len(common), Min(tbGenPairsPerRound, tbGenMaxSymbols-len(symbols)))]
+
+		replace := map[uint32]uint16{}
+		for _, pair := range common {
+			left, right := int(pair>>12), int(pair&0xfff)
+			replace[pair] = uint16(len(symbols))
+			symbols = append(symbols, tbGenSymbol{left, right, symbols[left].length + symbols[right].length})
+		}
+		replaced := sequence[:0]
+		for i := 0; i < len(sequence); i++ {
+			if i+1 < len(sequence) {
+				if sym, ok := replace[uint32(sequence[i])<<12|uint32(sequence[i+1])]; ok {
+					replaced = append(replaced, sym)
+					i++
+					continue
+				}
+			}
+			replaced = append(replaced, sequence[i])
+		}
+		sequence = replaced
+	}
+
+	return sequence, symbols
+}
+
+// Get the lengths of a Huffman code for the symbols with the frequencies, at
+// most 32 bits long, and 0 for the symbols that aren't used.
+func tbGenCodeLengths(frequency []int) []int {
+	lengths := make([]int, len(frequency))
+	used := []int{}
+	for sym, f := range frequency {
+		if f > 0 {
+			used = append(used, sym)
+		}
+	}
+	if len(used) == 1 {
+		lengths[used[0]] = 1
+		return lengths
+	}
+
+	weights := append([]int{}, frequency...)
+	for {
+		// Join the two lightest nodes, which are the next leaf or the next
+		// joined node since both are in ascending order
+		sort.SliceStable(used, func(i, j int) bool {
+			return weights[used[i]] < weights[used[j]]
+		})
+		n := len(used)
+		weight := make([]int, 2*n-1)
+		parent := make([]int, 2*n-1)
+		for i, sym := range used {
+			weight[i] = weights[sym]
+		}
+		leaf, joined := 0, n
+		lightest := func(next int) int {
+			if leaf < n && (joined == next || weight[leaf] <= weight[joined]) {
+				leaf++
+				return leaf - 1
+			}
+			joined++
+			return joined - 1
+		}
+		for next := n; next < 2*n-1; next++ {
+			a, b := lightest(next), lightest(next)
+			weight[next] = weight[a] + weight[b]
+			parent[a], parent[b] = next, next
+		}
+
+		depth := make([]int, 2*n-1)
+		longest := 0
+		for node := 2*n - 3; node >= 0; node-- {
+			depth[node] = depth[parent[node]] + 1
+			longest = Max(longest, depth[node])
+		}
+		if longest <= 32 {
+			for i, sym := range used {
+				lengths[sym] = depth[i]
+			}
+			return lengths
+		}
+
+		// Flatten the frequencies until the code is short enough
+		for _, sym := range used {
+			weights[sym] = weights[sym]/2 + 1
+		}
+	}
+}
@@ -0,0 +1,407 @@
+package engine
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Sidhant-Roymoulik/Light-Blue/chess"
+)
+
+// The tables in testdata/syzygy, written by TestGenerateSyzygy.
+var syzygyTestTables = []string{"KQvK", "KRvK", "KBvK", "KNvK", "KPvK", "KQvKR", "KRRvK", "KBNvK"}
+
+// A directory with the official tables, which TestSyzygyOfficial compares
+// the generated tables with.
+var syzygyOfficial = flag.String("syzygy.official", "", "compare the tables in testdata/syzygy with the official tables in this directory")
+
+// The number of random positions checked for every table, and with go test
+// -short, and how many KPvK positions are skipped for every one checked
+// against the bitbase.
+const (
+	syzygyRandomPositions      = 200
+	syzygyShortRandomPositions = 20
+	syzygyKPKEvery             = 25
+	syzygyShortKPKEvery        = 250
+)
+
+// Load the tables in testdata/syzygy, failing the test if any is missing.
+func initSyzygyTest(t *testing.T) {
+	t.Helper()
+	init_syzygy(filepath.Join("testdata", "syzygy"))
+	for _, name := range syzygyTestTables {
+		table := syzygy.tables[name]
+		if table == nil || !table.load(&table.wdl) || !table.load(&table.dtz) {
+			t.Fatalf("missing Syzygy table %s in testdata/syzygy", name)
+		}
+	}
+}
+
+func TestSyzygyWDL(t *testing.T) {
+	initSyzygyTest(t)
+
+	tests := []struct {
+		name string
+		fen  string
+		wdl  int
+	}{
+		{"KQvK white to move", "4k3/8/8/8/8/8/8/3QK3 w - - 0 1", WDLWin},
+		{"KQvK black to move", "4k3/8/8/8/8/8/8/3QK3 b - - 0 1", WDLLoss},
+		{"KRvK white to move", "4k3/8/8/8/8/8/8/R3K3 w - - 0 1", WDLWin},
+		{"KRvK black to move", "4k3/8/8/8/8/8/8/R3K3 b - - 0 1", WDLLoss},
+		{"KPvK white to move", "7k/3K4/4P3/8/8/8/8/8 w - - 0 1", WDLWin},
+		{"KPvK black to move", "7k/3K4/4P3/8/8/8/8/8 b - - 0 1", WDLLoss},
+		{"KPvK blocked pawn", "4k3/4P3/4K3/8/8/8/8/8 b - - 0 1", WDLDraw},
+		{"KRvK as black", "8/8/8/8/8/8/5k2/3K3r b - - 0 1", WDLWin},
+		{"KQvK stalemate as black", "8/8/8/8/8/1q6/2k5/K7 w - - 0 1", WDLDraw},
+		{"KQvK stalemate", "k7/2Q5/1K6/8/8/8/8/8 b - - 0 1", WDLDraw},
+		{"KQvK hanging queen", "8/8/8/8/8/8/2k5/K2Q4 b - - 0 1", WDLDraw},
+		{"KBvK", "4k3/8/8/8/8/8/8/2B1K3 w - - 0 1", WDLDraw},
+		{"KNvK", "4k3/8/8/8/8/8/8/1N2K3 w - - 0 1", WDLDraw},
+		{"KQvKR capture the rook", "8/8/8/3k4/8/8/1r6/Q3K3 w - - 0 1", WDLWin},
+		{"KRRvK white to move", "4k3/8/8/8/8/8/8/RR2K3 w - - 0 1", WDLWin},
+		{"KRRvK black to move", "4k3/8/8/8/8/8/8/RR2K3 b - - 0 1", WDLLoss},
+		{"KBNvK white to move", "8/8/8/4k3/8/8/8/KBN5 w - - 0 1", WDLWin},
+		{"KBNvK capture the knight", "8/8/8/8/8/8/2k5/KBN5 b - - 0 1", WDLDraw},
+	}
+
+	for _, test := range tests {
+		position := game_from_fen(test.fen).Position().Copy()
+		wdl, ok := probe_wdl(position)
+		if !ok {
+			t.Errorf("%s: can't probe %s", test.name, test.fen)
+		} else if wdl != test.wdl {
+			t.Errorf("%s: got WDL %d for %s, expected %d", test.name, wdl, test.fen, test.wdl)
+		}
+	}
+}
+
+func TestSyzygyDTZ(t *testing.T) {
+	initSyzygyTest(t)
+
+	tests := []struct {
+		name string
+		fen  string
+		dtz  int
+	}{
+		{"KQvK mate in one", "k7/8/1K6/8/8/8/8/2Q5 w - - 0 1", 1},
+		{"KRvK mate in one", "k7/8/1K6/8/8/8/8/7R w - - 0 1", 1},
+		{"KQvK mated", "k7/1Q6/1K6/8/8/8/8/8 b - - 0 1", -1},
+		{"KRvK mated", "k6R/8/1K6/8/8/8/8/8 b - - 0 1", -1},
+		{"KQvK stalemate", "k7/2Q5/1K6/8/8/8/8/8 b - - 0 1", 0},
+		{"KPvK promotion", "7k/4P3/4K3/8/8/8/8/8 w - - 0 1", 1},
+	}
+
+	for _, test := range tests {
+		position := game_from_fen(test.fen).Position().Copy()
+		dtz, ok := probe_dtz(position)
+		if !ok {
+			t.Errorf("%s: can't probe %s", test.name, test.fen)
+		} else if dtz != test.dtz {
+			t.Errorf("%s: got DTZ %d for %s, expected %d", test.name, dtz, test.fen, test.dtz)
+		}
+	}
+}
+
+func TestSyzygyLongestWins(t *testing.T) {
+	initSyzygyTest(t)
+
+	// The longest wins take as long as the longest mates of KQvK, KRvK and
+	// KBNvK, 10, 16 and 33 moves, and the longest conversion of KQvKR, 31
+	// moves, given by published endgame statistics
+	tests := []struct {
+		name string
+		fen  string
+		dtz  int
+	}{
+		{"KQvK", "8/8/8/5k2/8/8/1Q6/K7 w - - 0 1", 19},
+		{"KRvK", "8/8/8/8/8/2k5/1R6/K7 w - - 0 1", 31},
+		{"KQvKR", "8/8/2k5/r7/8/8/8/K2Q4 w - - 0 1", 61},
+		{"KBNvK", "8/8/8/8/8/8/2k5/KNB5 w - - 0 1", 65},
+	}
+
+	for _, test := range tests {
+		position := game_from_fen(test.fen).Position().Copy()
+		dtz, ok := probe_dtz(position)
+		if !ok {
+			t.Errorf("%s: can't probe %s", test.name, test.fen)
+		} else if dtz != test.dtz {
+			t.Errorf("%s: got DTZ %d for %s, expected %d", test.name, dtz, test.fen, test.dtz)
+		}
+	}
+}
+
+func TestSyzygyRootMoves(t *testing.T) {
+	initSyzygyTest(t)
+
+	// Positions with a single best move
+	tests := []struct {
+		name string
+		fen  string
+		move string
+	}{
+		{"KQvK mate in one", "k7/8/1K6/8/8/8/8/2Q5 w - - 0 1", "c1c8"},
+		{"KRvK mate in one", "k7/8/1K6/8/8/8/8/7R w - - 0 1", "h1h8"},
+		{"KQvK capture the queen", "8/8/8/8/8/8/2k5/K2Q4 b - - 0 1", "c2d1"},
+	}
+
+	for _, test := range tests {
+		position := game_from_fen(test.fen).Position().Copy()
+		moves, ok := tb_root_moves(position, func(*chess.Move) bool { return true })
+		if !ok {
+			t.Errorf("%s: can't probe %s", test.name, test.fen)
+		} else if len(moves) != 1 || moves[0].String() != test.move {
+			t.Errorf("%s: got root moves %v for %s, expected %s", test.name, moves, test.fen, test.move)
+		}
+	}
+}
+
+func TestSyzygyKPK(t *testing.T) {
+	initSyzygyTest(t)
+
+	every := syzygyKPKEvery
+	if testing.Short() {
+		every = syzygyShortKPKEvery
+	}
+
+	// The KPvK table must agree with the KPK bitbase, and give the same result
+	// with the colors swapped, where black leads with its pawn
+	for _, turn := range []chess.Color{chess.White, chess.Black} {
+		for_each_tb_position("KPk", turn, every, func(position *chess.Position) {
+			board := position.Board()
+			white_king := board.BBForPiece(chess.WhiteKing).Msb()
+			pawn := board.BBForPiece(chess.WhitePawn).Msb()
+			black_king := board.BBForPiece(chess.BlackKing).Msb()
+			win := kpk_probe(chess.White, position.Turn(), white_king, pawn, black_king)
+
+			expected := WDLDraw
+			if win && position.Turn() == chess.White {
+				expected = WDLWin
+			} else if win {
+				expected = WDLLoss
+			}
+
+			if wdl, ok := probe_wdl(position); !ok || wdl != expected {
+				t.Errorf("got WDL %d for %s, the bitbase gives %d", wdl, position, expected)
+			}
+			flipped := position.FlipColors()
+			if wdl, ok := probe_wdl(flipped); !ok || wdl != expected {
+				t.Errorf("got WDL %d for %s, the bitbase gives %d", wdl, flipped, expected)
+			}
+		})
+	}
+}
+
+func TestSyzygyRandomPositions(t *testing.T) {
+	initSyzygyTest(t)
+
+	count := syzygyRandomPositions
+	if testing.Short() {
+		count = syzygyShortRandomPositions
+	}
+
+	random := rand.New(rand.NewSource(1))
+	for _, name := range syzygyTestTables {
+		t.Run(name, func(t *testing.T) {
+			for i := 0; i < count; i++ {
+				checkSyzygyPosition(t, random_tb_position(name, random))
+			}
+		})
+	}
+}
+
+func TestSyzygyOfficial(t *testing.T) {
+	if *syzygyOfficial == "" {
+		t.Skip("no official tables, run with -syzygy.official <directory>")
+	}
+
+	// Probe the same random positions with the official tables and then with
+	// the generated ones. DTZ may differ by a ply where one of them stores
+	// the table in moves.
+	type result struct {
+		position *chess.Position
+		wdl, dtz int
+	}
+	results := map[string][]result{}
+	init_syzygy(*syzygyOfficial)
+	random := rand.New(rand.NewSource(1))
+	for _, name := range syzygyTestTables {
+		table := syzygy.tables[name]
+		if table == nil || !table.load(&table.wdl) || !table.load(&table.dtz) {
+			t.Logf("skipping %s, missing in %s", name, *syzygyOfficial)
+			continue
+		}
+		for i := 0; i < syzygyRandomPositions; i++ {
+			position := random_tb_position(name, random)
+			wdl, ok := probe_wdl(position)
+			dtz, dtz_ok := probe_dtz(position)
+			if !ok || !dtz_ok {
+				t.Fatalf("can't probe %s with the official tables", position)
+			}
+			results[name] = append(results[name], result{position, wdl, dtz})
+		}
+	}
+
+	initSyzygyTest(t)
+	for name, positions := range results {
+		for _, expected := range positions {
+			wdl, _ := probe_wdl(expected.position)
+			dtz, _ := probe_dtz(expected.position)
+			if wdl != expected.wdl || sign(dtz) != sign(expected.dtz) || abs(dtz-expected.dtz) > 1 {
+				t.Errorf("%s: got WDL %d and DTZ %d for %s, the official tables give %d and %d",
+					name, wdl, dtz, expected.position, expected.wdl, expected.dtz)
+			}
+		}
+	}
+}
+
+// Get a random legal position with the pieces of the table, like KRvK.
+func random_tb_position(key string, random *rand.Rand) *chess.Position {
+	for {
+		var squares [64]rune
+		for side, pieces := range strings.Split(key, "v") {
+			for _, piece := range pieces {
+				if side == 1 {
+					piece += 'a' - 'A'
+				}
+				sq := random.Intn(64)
+				for squares[sq] != 0 ||
+					((piece == 'P' || piece == 'p') && (sq < 8 || sq >= 56)) {
+					sq = random.Intn(64)
+				}
+				squares[sq] = piece
+			}
+		}
+
+		turn := chess.White
+		if random.Intn(2) == 1 {
+			turn = chess.Black
+		}
+		if position := tb_position(&squares, turn); position != nil {
+			return position
+		}
+	}
+}
+
+// Call back with every every-th legal position with the pieces, like KPk for
+// a white king and pawn against a black king, and the side to move.
+func for_each_tb_position(pieces string, turn chess.Color, every int, callback func(*chess.Position)) {
+	var squares [64]rune
+	count := 0
+
+	var place func(i int)
+	place = func(i int) {
+		if i == len(pieces) {
+			if count++; count%every != 0 {
+				return
+			}
+			if position := tb_position(&squares, turn); position != nil {
+				callback(position)
+			}
+			return
+		}
+
+		piece := rune(pieces[i])
+		for sq := 0; sq < 64; sq++ {
+			if squares[sq] != 0 || ((piece == 'P' || piece == 'p') && (sq < 8 || sq >= 56)) {
+				continue
+			}
+			squares[sq] = piece
+			place(i + 1)
+			squares[sq] = 0
+		}
+	}
+	place(0)
+}
+
+// Get the position with the pieces on the squares, or nil if the side that
+// isn't to move is in check.
+func tb_position(squares *[64]rune, turn chess.Color) *chess.Position {
+	fen := ""
+	for rank := 7; rank >= 0; rank-- {
+		empty := 0
+		for file := 0; file < 8; file++ {
+			if squares[rank*8+file] == 0 {
+				empty++
+				continue
+			}
+			if empty > 0 {
+				fen += fmt.Sprint(empty)
+				empty = 0
+			}
+			fen += string(squares[rank*8+file])
+		}
+		if empty > 0 {
+			fen += fmt.Sprint(empty)
+		}
+		if rank > 0 {
+			fen += "/"
+		}
+	}
+	fen += " " + turn.String() + " - - 0 1"
+
+	position := game_from_fen(fen).Position().Copy()
+	if position.KingAttacked(turn.Other()) {
+		return nil
+	}
+	return position
+}
+
+// Check the tablebase results of the position against the results of its
+// moves.
+func checkSyzygyPosition(t *testing.T, position *chess.Position) {
+	t.Helper()
+	wdl, ok := probe_wdl(position)
+	if !ok {
+		t.Errorf("can't probe %s", position)
+		return
+	}
+
+	// Ignoring the fifty move rule, the position is won if a move loses for
+	// the other side, and lost if every move wins for the other side
+	best := WDLLoss
+	if len(position.ValidMoves()) == 0 && !position.InCheck() {
+		best = WDLDraw
+	}
+	for _, move := range position.ValidMoves() {
+		child_wdl, ok := probe_wdl(position.Update(move).Copy())
+		if !ok {
+			t.Errorf("can't probe %s after %s", position, move)
+			return
+		}
+		best = Max(best, -sign(child_wdl)*WDLWin)
+	}
+	if sign(wdl)*WDLWin != best {
+		t.Errorf("got WDL %d for %s but the moves give %d", wdl, position, best)
+	}
+
+	// Playing the best moves for both sides must reach a capture or pawn
+	// move within the distance given by the DTZ table
+	dtz, ok := probe_dtz(position)
+	if !ok {
+		t.Errorf("can't probe the DTZ of %s", position)
+		return
+	}
+	if sign(dtz) != sign(wdl) {
+		t.Errorf("got DTZ %d for %s with WDL %d", dtz, position, wdl)
+		return
+	}
+	if dtz == 0 {
+		return
+	}
+	start := position
+	for plies := 1; len(position.ValidMoves()) > 0; plies++ {
+		moves, ok := tb_root_moves(position, func(*chess.Move) bool { return true })
+		if !ok || plies > abs(dtz) {
+			t.Errorf("%s didn't convert within %d plies", start, dtz)
+			return
+		}
+		if is_zeroing(position, moves[0]) {
+			return
+		}
+		position = position.Update(moves[0]).Copy()
+	}
+}
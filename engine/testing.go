@@ -49,7 +49,18 @@ func test_benchmark() {
 		maxDepth,
 		maxNodeCount,
 	)
-	engines := []*Engine{&engine_1}
+	engine_2 := new_light_blue()
+	engine_2.name = name + " (No Singular Extensions)"
+	engine_2.upgrades.singular_extensions = false
+	engine_2.timer.Setup(
+		InfiniteTime,
+		NoValue,
+		NoValue,
+		movesToGo,
+		maxDepth,
+		maxNodeCount,
+	)
+	engines := []*Engine{&engine_1, &engine_2}
 	benchmark_engines(engines, game_from_fen("rn1qkb1r/pp2pppp/5n2/3p1b2/3P4/2N1P3/PP3PPP/R1BQKBNR w KQkq - 0 1").Position())
 }
 
@@ -1,6 +1,8 @@
 package engine
 
 import (
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -18,10 +20,19 @@ type TimeManager struct {
 	MaxNodeCount uint64
 	MaxDepth     uint8
 
-	// Fields to calculate when the search should be stopped.
-	stop        bool
+	// Fields to calculate when the search should be stopped. The stop flag
+	// is atomic since it is set by the UCI loop and by the main search
+	// thread while helper threads are reading it.
+	stop        atomic.Bool
 	TimeForMove int64
 	stopTime    time.Time
+
+	// While pondering the search runs on the opponent's time and ignores
+	// the time control until the GUI sends ponderhit. The channel is closed
+	// by a ponderhit or a stop, so the best move can be sent after them.
+	pondering   atomic.Bool
+	ponderMutex sync.Mutex
+	ponderDone  chan struct{}
 }
 
 func (tm *TimeManager) Setup(timeLeft, increment, moveTime int64,
@@ -36,16 +47,65 @@ func (tm *TimeManager) Setup(timeLeft, increment, moveTime int64,
 }
 
 func (tm *TimeManager) ForceStop() {
-	tm.stop = true
+	tm.stop.Store(true)
+	tm.endPondering()
 }
 
 func (tm *TimeManager) IsStopped() bool {
-	return tm.stop
+	return tm.stop.Load()
 }
 
 func (tm *TimeManager) Start() {
-	tm.stop = false
+	tm.stop.Store(false)
+	tm.setStopTime()
+}
+
+func (tm *TimeManager) SetPondering(pondering bool) {
+	if !pondering {
+		tm.endPondering()
+		return
+	}
+
+	tm.ponderMutex.Lock()
+	defer tm.ponderMutex.Unlock()
+	tm.pondering.Store(true)
+	tm.ponderDone = make(chan struct{})
+}
+
+func (tm *TimeManager) IsPondering() bool {
+	return tm.pondering.Load()
+}
+
+// Switch from pondering to a normal search. The time for the move is counted
+// from the ponderhit, since the opponent's clock was running until then.
+func (tm *TimeManager) PonderHit() {
+	tm.setStopTime()
+	tm.endPondering()
+}
+
+// Block until a ponderhit or a stop ends pondering. Returns right away when
+// not pondering.
+func (tm *TimeManager) WaitForPonderEnd() {
+	tm.ponderMutex.Lock()
+	done := tm.ponderDone
+	tm.ponderMutex.Unlock()
+
+	if done != nil {
+		<-done
+	}
+}
+
+func (tm *TimeManager) endPondering() {
+	tm.ponderMutex.Lock()
+	defer tm.ponderMutex.Unlock()
+	tm.pondering.Store(false)
+	if tm.ponderDone != nil {
+		close(tm.ponderDone)
+		tm.ponderDone = nil
+	}
+}
 
+func (tm *TimeManager) setStopTime() {
 	if tm.MoveTime != NoValue {
 		tm.stopTime = time.Now().Add(time.Duration(tm.MoveTime) * time.Millisecond)
 		tm.TimeLeft = NoValue
@@ -75,7 +135,7 @@ func (tm *TimeManager) Start() {
 }
 
 func (tm *TimeManager) CheckIfTimeIsUp() {
-	if tm.stop {
+	if tm.stop.Load() || tm.pondering.Load() {
 		return
 	}
 
@@ -84,6 +144,6 @@ func (tm *TimeManager) CheckIfTimeIsUp() {
 	}
 
 	if time.Now().After(tm.stopTime) {
-		tm.stop = true
+		tm.stop.Store(true)
 	}
 }
@@ -1,6 +1,11 @@
 package engine
 
-import "github.com/Sidhant-Roymoulik/Light-Blue/chess"
+import (
+	"math"
+	"sync/atomic"
+
+	"github.com/Sidhant-Roymoulik/Light-Blue/chess"
+)
 
 // transposition.go contains an implementation of a transposition table (TT) to use
 // in searching and perft.
@@ -16,6 +21,10 @@ const (
 	// considering memory alignment.
 	SearchEntrySize uint64 = 16
 	PerftEntrySize  uint64 = 24
+	PawnEntrySize   uint64 = 40
+
+	// Default size of the pawn hash table, in MB.
+	DefaultPawnTTSize = 4
 
 	// Constants representing the different flags for a transposition table entry,
 	// which determine what kind of entry it is. If the entry has a score from
@@ -27,13 +36,27 @@ const (
 	ExactFlag uint8 = 3
 )
 
-// A struct for a transposition table entry used in the search.
+// A struct for a transposition table entry used in the search. The table is
+// shared by the Lazy SMP threads without locking, so the entry is two 64 bit
+// words that are read and written atomically: the packed depth, score, best
+// move, flag and age, and the hash of the position XOR'd with them. If
+// another thread writes the entry between the two words being read, the
+// hash won't match and the entry is treated as empty, instead of a torn entry
+// passing for the position with another position's score.
+//
+// https://www.chessprogramming.org/Shared_Hash_Table#Lockless
 type SearchEntry struct {
-	Hash       uint64
-	Depth      int
-	Score      int
-	Best       chess.Move
-	FlagAndAge uint8
+	key  uint64
+	data uint64
+}
+
+// The fields of a search entry, once read from the table.
+type SearchData struct {
+	Depth int
+	Score int
+	Best  chess.Move
+	Flag  uint8
+	Age   uint8
 }
 
 // A struct for a transposition table entry used in perft.
@@ -43,109 +66,123 @@ type PerftEntry struct {
 	Depth uint8
 }
 
-func (entry SearchEntry) GetHash() uint64 {
-	return entry.Hash
+// A struct for a pawn hash table entry, with the evaluation terms that only
+// depend on the pawns, by color and middlegame or endgame.
+type PawnEntry struct {
+	Hash        uint64
+	Passed      [2]chess.Bitboard
+	Structure   [2][2]int16
+	PassedBonus [2][2]int16
 }
 
-func (entry SearchEntry) GetDepth() int {
-	return entry.Depth
+// Pack the fields of a search entry into a word: the best move in the low 16
+// bits, then the depth, the flag and age, and the score in the high 32 bits.
+func pack_search_data(depth int, score int, best uint64, flag uint8, age uint8) uint64 {
+	return best |
+		uint64(uint8(Max(0, Min(depth, math.MaxUint8))))<<16 |
+		uint64(flag<<6|age<<4)<<24 |
+		uint64(uint32(int32(score)))<<32
 }
 
-func (entry SearchEntry) GetFlag() uint8 {
-	return (entry.FlagAndAge & 0xc0) >> 6
+func pack_move(move *chess.Move) uint64 {
+	return uint64(move.S1()) | uint64(move.S2())<<6 | uint64(move.Promo())<<12
+}
 
+func unpack_search_data(data uint64) SearchData {
+	return SearchData{
+		Depth: int(uint8(data >> 16)),
+		Score: int(int32(uint32(data >> 32))),
+		Best: chess.NewMove(
+			chess.Square(data&0x3f),
+			chess.Square((data>>6)&0x3f),
+			chess.PieceType((data>>12)&0x7),
+		),
+		Flag: (uint8(data>>24) & 0xc0) >> 6,
+		Age:  (uint8(data>>24) & 0x30) >> 4,
+	}
 }
-func (entry *SearchEntry) SetFlag(flag uint8) {
-	entry.FlagAndAge &= 0x3f
-	entry.FlagAndAge |= flag << 6
+
+func (entry *SearchEntry) GetHash() uint64 {
+	return atomic.LoadUint64(&entry.key) ^ atomic.LoadUint64(&entry.data)
 }
 
-func (entry SearchEntry) GetAge() uint8 {
-	return (entry.FlagAndAge & 0x30) >> 4
+func (entry *SearchEntry) GetDepth() int {
+	return unpack_search_data(atomic.LoadUint64(&entry.data)).Depth
 }
 
-func (entry *SearchEntry) SetAge(age uint8) {
-	entry.FlagAndAge &= 0xcf
-	entry.FlagAndAge |= age << 4
+func (entry *SearchEntry) GetAge() uint8 {
+	return unpack_search_data(atomic.LoadUint64(&entry.data)).Age
 }
 
-func (entry *SearchEntry) Get(hash uint64, ply int, depth int, alpha int, beta int) (int, bool, *chess.Move) {
-	var adjustedScore int = 0
+// Read the entry, returning false if it isn't an entry for the position with
+// the hash. The best move still has to be checked to be playable, since two
+// positions can share a hash.
+func (entry *SearchEntry) Load(hash uint64) (SearchData, bool) {
+	data := atomic.LoadUint64(&entry.data)
+	if atomic.LoadUint64(&entry.key)^data != hash {
+		return SearchData{}, false
+	}
+	return unpack_search_data(data), true
+}
+
+// Get the score of the entry, and whether the score can be used in place of
+// searching the position.
+func (data *SearchData) Get(ply int, depth int, alpha int, beta int) (int, bool) {
+	// Return the score of the position to use as an estimate for various
+	// pruning and extension techniques in the search.
+	adjustedScore := data.Score
 	shouldUse := false
-	var best *chess.Move = nil
-
-	// Since index collisions can occur, test if the hash of the entry at this index
-	// actually matches the hash for the current position.
-	if entry.Hash == hash {
-
-		// Even if we don't get a score we can use from the table, we can still
-		// use the best move in this entry and put it first in our move ordering
-		// scheme.
-		best = &entry.Best
-
-		// Return the score of the position to use as an estimate for various
-		// pruning and extension techniques in the search.
-		adjustedScore = entry.Score
-
-		// To be able to get an accurate value from this entry, make sure the results of
-		// this entry are from a search that is equal or greater than the current
-		// depth of our search.
-		if entry.Depth >= depth {
-			score := entry.Score
-
-			// If the score we get from the transposition table is a checkmate score, we need
-			// to do a little extra work. This is because we store checkmates in the table using
-			// their distance from the node they're found in, not their distance from the root.
-			// So if we found a checkmate-in-8 in a node that was 5 plies from the root, we need
-			// to store the score as a checkmate-in-3. Then, if we read the checkmate-in-3 from
-			// the table in a node that's 4 plies from the root, we need to return the score as
-			// checkmate-in-7.
-			if score > MATE_CUTOFF {
-				score -= ply
-			}
-
-			if score < -MATE_CUTOFF {
-				score += ply
-			}
-
-			if entry.GetFlag() == ExactFlag {
-				// If we have an exact entry, we can use the saved score.
-				adjustedScore = score
-				shouldUse = true
-			}
-
-			if entry.GetFlag() == AlphaFlag && score <= alpha { // && score >= alpha
-				// If we have an alpha entry, and the entry's score is less than our
-				// current alpha, then we know that our current alpha is the best score
-				// we can get in this node, so we can stop searching and use alpha.
-				adjustedScore = alpha
-				shouldUse = true
-			}
-
-			if entry.GetFlag() == BetaFlag && score >= beta { //   && score <= beta
-				// If we have a beta entry, and the entry's score is greater than our
-				// current beta, then we have a beta-cutoff, since while
-				// searching this node previously, we found a value greater than the current
-				// beta. so we can stop searching and use beta.
-				adjustedScore = beta
-				shouldUse = true
-			}
+
+	// To be able to get an accurate value from this entry, make sure the results of
+	// this entry are from a search that is equal or greater than the current
+	// depth of our search.
+	if data.Depth >= depth {
+		score := data.Score
+
+		// If the score we get from the transposition table is a checkmate score, we need
+		// to do a little extra work. This is because we store checkmates in the table using
+		// their distance from the node they're found in, not their distance from the root.
+		// So if we found a checkmate-in-8 in a node that was 5 plies from the root, we need
+		// to store the score as a checkmate-in-3. Then, if we read the checkmate-in-3 from
+		// the table in a node that's 4 plies from the root, we need to return the score as
+		// checkmate-in-7.
+		if score > MATE_CUTOFF {
+			score -= ply
+		}
+
+		if score < -MATE_CUTOFF {
+			score += ply
+		}
+
+		if data.Flag == ExactFlag {
+			// If we have an exact entry, we can use the saved score.
+			adjustedScore = score
+			shouldUse = true
+		}
+
+		if data.Flag == AlphaFlag && score <= alpha { // && score >= alpha
+			// If we have an alpha entry, and the entry's score is less than our
+			// current alpha, then we know that our current alpha is the best score
+			// we can get in this node, so we can stop searching and use alpha.
+			adjustedScore = alpha
+			shouldUse = true
+		}
+
+		if data.Flag == BetaFlag && score >= beta { //   && score <= beta
+			// If we have a beta entry, and the entry's score is greater than our
+			// current beta, then we have a beta-cutoff, since while
+			// searching this node previously, we found a value greater than the current
+			// beta. so we can stop searching and use beta.
+			adjustedScore = beta
+			shouldUse = true
 		}
 	}
 
 	// Return the score
-	return adjustedScore, shouldUse, best
+	return adjustedScore, shouldUse
 }
 
 func (entry *SearchEntry) Set(hash uint64, score int, best *chess.Move, ply int, depth int, flag, age uint8) {
-	entry.Hash = hash
-	entry.Depth = depth
-	if best != nil {
-		entry.Best = *best
-	}
-	entry.SetFlag(flag)
-	entry.SetAge(age)
-
 	// If the score we get from the transposition table is a checkmate score, we need
 	// to do a little extra work. This is because we store checkmates in the table using
 	// their distance from the node they're found in, not their distance from the root.
@@ -161,7 +198,18 @@ func (entry *SearchEntry) Set(hash uint64, score int, best *chess.Move, ply int,
 		score -= ply
 	}
 
-	entry.Score = score
+	// Keep the best move of an earlier entry for the same position if there's
+	// no new one
+	move := uint64(0)
+	if best != nil {
+		move = pack_move(best)
+	} else if old := atomic.LoadUint64(&entry.data); atomic.LoadUint64(&entry.key)^old == hash {
+		move = old & 0xffff
+	}
+
+	data := pack_search_data(depth, score, move, flag, age)
+	atomic.StoreUint64(&entry.data, data)
+	atomic.StoreUint64(&entry.key, hash^data)
 }
 
 func (entry PerftEntry) GetHash() uint64 {
@@ -176,8 +224,8 @@ func (entry PerftEntry) GetAge() uint8 {
 	return 1
 }
 
-func (entry PerftEntry) GetDepth() uint8 {
-	return entry.Depth
+func (entry PerftEntry) GetDepth() int {
+	return int(entry.Depth)
 }
 
 func (entry *PerftEntry) Get(hash uint64, depth uint8) (nodeCount uint64, ok bool) {
@@ -193,9 +241,24 @@ func (entry *PerftEntry) Set(hash uint64, depth uint8, nodes uint64) {
 	entry.Nodes = nodes
 }
 
-// A struct for a transposition table.
-type TransTable[Entry interface {
-	SearchEntry | PerftEntry
+func (entry PawnEntry) GetHash() uint64 {
+	return entry.Hash
+}
+
+func (entry PawnEntry) GetAge() uint8 {
+	// Like perft entries, pawn entries are always replaced.
+	return 1
+}
+
+func (entry PawnEntry) GetDepth() int {
+	return 0
+}
+
+// A struct for a transposition table. The methods of an entry are called
+// through a pointer to it, so that search entries can be read atomically in
+// place.
+type TransTable[Entry any, EntryPtr interface {
+	*Entry
 	GetHash() uint64
 	GetAge() uint8
 	GetDepth() int
@@ -205,14 +268,14 @@ type TransTable[Entry interface {
 }
 
 // Resize the transposition table given what the size should be in MB.
-func (tt *TransTable[Entry]) Resize(sizeInMB uint64, entrySize uint64) {
+func (tt *TransTable[Entry, EntryPtr]) Resize(sizeInMB uint64, entrySize uint64) {
 	size := (sizeInMB * 1024 * 1024) / entrySize
 	tt.entries = make([]Entry, size)
 	tt.size = size
 }
 
 // Get an entry from the table to use it.
-func (tt *TransTable[Entry]) Probe(hash uint64) *Entry {
+func (tt *TransTable[Entry, EntryPtr]) Probe(hash uint64) *Entry {
 	// Get the entry from the table, calculating an index by modulo-ing the hash of
 	// the position by the size of the table. A two-bucket system is used to
 	// more efficently make use of the table.
@@ -222,7 +285,7 @@ func (tt *TransTable[Entry]) Probe(hash uint64) *Entry {
 		return &tt.entries[index]
 	}
 
-	first := tt.entries[index]
+	first := EntryPtr(&tt.entries[index])
 	if first.GetHash() == hash {
 		return &tt.entries[index]
 	}
@@ -231,18 +294,14 @@ func (tt *TransTable[Entry]) Probe(hash uint64) *Entry {
 }
 
 // Get an entry from the table to store in it.
-func (tt *TransTable[Entry]) Store(hash uint64, depth int, currAge uint8) *Entry {
+func (tt *TransTable[Entry, EntryPtr]) Store(hash uint64, depth int, currAge uint8) *Entry {
 	index := hash % tt.size
 	if index+1 == tt.size {
 		return &tt.entries[index]
 	}
 
-	first := tt.entries[index]
+	first := EntryPtr(&tt.entries[index])
 	if first.GetDepth() <= depth || first.GetAge() != currAge {
-		// Note that returning &first caused a bug where the transposition
-		// table entry was never modifed, and so the table was always empty.
-		// Have to figure out why that is, but for now return &tt.entries[index]
-		// directly.
 		return &tt.entries[index]
 	}
 
@@ -250,13 +309,13 @@ func (tt *TransTable[Entry]) Store(hash uint64, depth int, currAge uint8) *Entry
 }
 
 // Unitialize the memory used by the transposition table
-func (tt *TransTable[Entry]) Unitialize() {
+func (tt *TransTable[Entry, EntryPtr]) Unitialize() {
 	tt.entries = nil
 	tt.size = 0
 }
 
 // Clear the transposition table
-func (tt *TransTable[Entry]) Clear() {
+func (tt *TransTable[Entry, EntryPtr]) Clear() {
 	for idx := uint64(0); idx < tt.size; idx++ {
 		tt.entries[idx] = *new(Entry)
 	}
@@ -0,0 +1,358 @@
+package engine
+
+import (
+	"bufio"
+	"fmt"
+	"go/format"
+	"math"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sidhant-Roymoulik/Light-Blue/chess"
+)
+
+// tuner.go contains a Texel tuner for the weights of the evaluation. It
+// loads quiet positions labelled with the result of the game they came
+// from, and changes the weights one at a time to lower the mean squared
+// error between the result and the evaluation mapped to a win probability.
+// Since it only uses the evaluation function, it tunes any weight, including
+// the king safety points, which don't add up linearly.
+//
+// https://www.chessprogramming.org/Texel%27s_Tuning_Method
+
+// A labelled position, with the result from white's perspective: 1 for a
+// white win, 0.5 for a draw and 0 for a black win.
+type tuner_entry struct {
+	position *chess.Position
+	result   float64
+}
+
+// A table of weights the tuner can change, with the weights that are used
+// by the evaluation.
+type tuner_table struct {
+	name   string
+	table  any
+	values []*int
+}
+
+const (
+	DefaultTunerOutput   = "tuned_params.go"
+	DefaultTunerMaxPass  = 100
+	TunerKPrecision      = 10
+	TunerPrintEverySteps = 100
+)
+
+// -----------------------------------------------------------------------------
+// 		Tuned Weights
+// -----------------------------------------------------------------------------
+
+// Get every table of weights the tuner changes, which are the evaluation
+// parameters.
+func tuner_tables() []tuner_table {
+	tables := []tuner_table{}
+
+	for _, p := range params {
+		if !p.eval {
+			continue
+		}
+		table := tuner_table{p.name, p.value, nil}
+		for index, value := range param_values(p.value) {
+			if p.tuned(index) {
+				table.values = append(table.values, value)
+			}
+		}
+		tables = append(tables, table)
+	}
+
+	return tables
+}
+
+// -----------------------------------------------------------------------------
+// 		Tuner
+// -----------------------------------------------------------------------------
+
+// Tune the evaluation on the labelled positions in the file, and write the
+// tuned weights to the output file, as a parameter file if it ends in .json
+// and as Go source otherwise.
+func Tune(path string, output string, max_passes int) error {
+	entries, err := load_tuner_entries(path)
+	if err != nil {
+		return err
+	}
+	print("Tuner: loaded", len(entries), "positions")
+
+	start := time.Now()
+
+	k := find_tuner_k(entries)
+	best_error := tuner_error(entries, k)
+	print("Tuner: K", k, "error", best_error)
+
+	tables := tuner_tables()
+
+	// Change every weight by one in both directions, keeping any change that
+	// lowers the error, until no weight changes
+	for pass := 1; pass <= max_passes; pass++ {
+		improved := false
+		steps := 0
+
+		for _, table := range tables {
+			for _, value := range table.values {
+				steps++
+
+				*value++
+				new_error := tuner_error(entries, k)
+				if new_error < best_error {
+					best_error = new_error
+					improved = true
+				} else {
+					*value -= 2
+					new_error = tuner_error(entries, k)
+					if new_error < best_error {
+						best_error = new_error
+						improved = true
+					} else {
+						*value++
+					}
+				}
+
+				if steps%TunerPrintEverySteps == 0 {
+					print("Tuner: pass", pass, "step", steps, "error", best_error)
+				}
+			}
+		}
+
+		print("Tuner: pass", pass, "error", best_error, "time", time.Since(start))
+
+		// Write the weights after every pass, so a long run can be stopped
+		if strings.HasSuffix(output, ".json") {
+			err = write_params(output, true)
+		} else {
+			err = write_tuner_tables(output, tables)
+		}
+		if err != nil {
+			return err
+		}
+
+		if !improved {
+			break
+		}
+	}
+
+	print("Tuner: wrote", output)
+	return nil
+}
+
+// Load labelled positions from a file with one position per line. The
+// result can be given as 1-0, 0-1 or 1/2-1/2, optionally quoted, or as a
+// number in brackets, like [0.5].
+func load_tuner_entries(path string) ([]tuner_entry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	entries := []tuner_entry{}
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fen, result, ok := parse_tuner_line(scanner.Text())
+		if !ok {
+			continue
+		}
+
+		fen_option, err := chess.FEN(fen)
+		if err != nil {
+			continue
+		}
+		position := chess.NewGame(fen_option).Position().Copy()
+		entries = append(entries, tuner_entry{position, result})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no labelled positions in %s", path)
+	}
+	return entries, nil
+}
+
+// Split a line into the FEN of its position and its result.
+func parse_tuner_line(line string) (string, float64, bool) {
+	result := 0.0
+	switch {
+	case strings.Contains(line, "1/2-1/2"):
+		result = 0.5
+	case strings.Contains(line, "1-0"):
+		result = 1
+	case strings.Contains(line, "0-1"):
+		result = 0
+	default:
+		open := strings.Index(line, "[")
+		close := strings.Index(line, "]")
+		if open < 0 || close < open {
+			return "", 0, false
+		}
+		value, err := strconv.ParseFloat(strings.TrimSpace(line[open+1:close]), 64)
+		if err != nil {
+			return "", 0, false
+		}
+		result = value
+	}
+
+	fen, ok := parse_epd_fen(line)
+	return fen, result, ok
+}
+
+// Get the FEN of the position on an EPD line. The FEN is the first four
+// fields, followed by the move counters if they're there.
+func parse_epd_fen(line string) (string, bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 4 {
+		return "", false
+	}
+	fen := fields[:4:4]
+	for _, field := range fields[4:Min(len(fields), 6)] {
+		field = strings.TrimRight(field, ";")
+		if _, err := strconv.Atoi(field); err != nil {
+			break
+		}
+		fen = append(fen, field)
+	}
+	if len(fen) == 4 {
+		fen = append(fen, "0")
+	}
+	if len(fen) == 5 {
+		fen = append(fen, "1")
+	}
+
+	return strings.Join(fen, " "), true
+}
+
+// Map an evaluation from white's perspective to the expected result.
+func tuner_sigmoid(eval int, k float64) float64 {
+	return 1 / (1 + math.Pow(10, -k*float64(eval)/400))
+}
+
+// Get the mean squared error between the results of the positions and their
+// evaluations. The positions are split between goroutines.
+func tuner_error(entries []tuner_entry, k float64) float64 {
+	threads := runtime.NumCPU()
+	errors := make([]float64, threads)
+
+	wg := sync.WaitGroup{}
+	for thread := 0; thread < threads; thread++ {
+		wg.Add(1)
+		go func(thread int) {
+			defer wg.Done()
+			for i := thread; i < len(entries); i += threads {
+				eval := eval_pos(entries[i].position)
+				if entries[i].position.Turn() == chess.Black {
+					eval = -eval
+				}
+				diff := entries[i].result - tuner_sigmoid(eval, k)
+				errors[thread] += diff * diff
+			}
+		}(thread)
+	}
+	wg.Wait()
+
+	total := 0.0
+	for _, err := range errors {
+		total += err
+	}
+	return total / float64(len(entries))
+}
+
+// Find the scaling constant of the sigmoid that fits the current weights
+// best, so the tuner only has to change the weights relative to each other.
+func find_tuner_k(entries []tuner_entry) float64 {
+	k, step := 1.0, 0.5
+	best := tuner_error(entries, k)
+
+	for i := 0; i < TunerKPrecision; i++ {
+		for improved := true; improved; {
+			improved = false
+			for _, next := range []float64{k + step, k - step} {
+				if next <= 0 {
+					continue
+				}
+				if err := tuner_error(entries, next); err < best {
+					best, k, improved = err, next, true
+					break
+				}
+			}
+		}
+		step /= 2
+	}
+
+	return k
+}
+
+// -----------------------------------------------------------------------------
+// 		Output
+// -----------------------------------------------------------------------------
+
+// Write the tables as Go source in the layout of evaluation_functions.go. The
+// file is excluded from builds, so it can be kept next to the source while
+// the tables are copied over.
+func write_tuner_tables(path string, tables []tuner_table) error {
+	var source strings.Builder
+
+	source.WriteString("//go:build ignore\n\n")
+	source.WriteString("// Code generated by the Light Blue tuner.\n\n")
+	source.WriteString("package engine\n\n")
+
+	source.WriteString("var (\n")
+	for _, table := range tables {
+		if value, ok := table.table.(*int); ok {
+			fmt.Fprintf(&source, "\t%s int = %d\n", table.name, *value)
+		}
+	}
+	source.WriteString(")\n")
+
+	for _, table := range tables {
+		switch value := table.table.(type) {
+		case *[]int:
+			fmt.Fprintf(&source, "\nvar %s = []int{%s}\n", table.name, join_ints((*value)[:]))
+		case *[7]int:
+			fmt.Fprintf(&source, "\nvar %s = [7]int{%s}\n", table.name, join_ints(value[:]))
+		case *[64]int:
+			fmt.Fprintf(&source, "\nvar %s = [64]int{\n%s}\n", table.name, square_rows(value, "\t"))
+		case *[7][64]int:
+			fmt.Fprintf(&source, "\nvar %s = [7][64]int{\n", table.name)
+			for pt := range value {
+				fmt.Fprintf(&source, "\t{\n%s\t},\n", square_rows(&value[pt], "\t\t"))
+			}
+			source.WriteString("}\n")
+		}
+	}
+
+	formatted, err := format.Source([]byte(source.String()))
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, formatted, 0644)
+}
+
+func join_ints(values []int) string {
+	strs := make([]string, len(values))
+	for i, value := range values {
+		strs[i] = strconv.Itoa(value)
+	}
+	return strings.Join(strs, ", ")
+}
+
+// Format a table of squares as eight rows of eight values.
+func square_rows(values *[64]int, indent string) string {
+	rows := ""
+	for rank := 0; rank < 8; rank++ {
+		rows += indent + join_ints(values[rank*8:rank*8+8]) + ",\n"
+	}
+	return rows
+}
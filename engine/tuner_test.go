@@ -0,0 +1,168 @@
+package engine
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseTunerLine(t *testing.T) {
+	// Results in the formats of common datasets
+	tests := []struct {
+		name   string
+		line   string
+		fen    string
+		result float64
+		ok     bool
+	}{
+		{
+			"Quoted result in an EPD operation",
+			"rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq - c9 \"1-0\";",
+			"rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq - 0 1",
+			1, true,
+		},
+		{
+			"Result in brackets",
+			"8/8/4k3/8/8/4K3/8/8 w - - 12 40 [0.5]",
+			"8/8/4k3/8/8/4K3/8/8 w - - 12 40",
+			0.5, true,
+		},
+		{
+			"Result after a semicolon",
+			"8/8/4k3/8/8/4K3/3q4/8 w - - 0 1; 0-1",
+			"8/8/4k3/8/8/4K3/3q4/8 w - - 0 1",
+			0, true,
+		},
+		{
+			"Draw",
+			"8/8/4k3/8/8/4K3/8/8 b - - 1/2-1/2",
+			"8/8/4k3/8/8/4K3/8/8 b - - 0 1",
+			0.5, true,
+		},
+		{"No result", "8/8/4k3/8/8/4K3/8/8 w - - 0 1", "", 0, false},
+		{"Bad number in brackets", "8/8/4k3/8/8/4K3/8/8 w - - 0 1 [draw]", "", 0, false},
+		{"Too few fields", "8/8/4k3/8/8/4K3/8/8 w [1.0]", "", 0, false},
+	}
+
+	for _, test := range tests {
+		fen, result, ok := parse_tuner_line(test.line)
+		if ok != test.ok {
+			t.Errorf("%s: parsing %q gave ok %t, expected %t", test.name, test.line, ok, test.ok)
+		} else if ok && (fen != test.fen || result != test.result) {
+			t.Errorf("%s: parsed %q as %q %v, expected %q %v", test.name, test.line, fen, result, test.fen, test.result)
+		}
+	}
+}
+
+func TestTunerTables(t *testing.T) {
+	// The number of weights tuned in some of the tables, leaving out the
+	// indexes the evaluation doesn't use
+	counts := map[string]int{
+		"TempoBonusMG":          1,
+		"OuterRingAttackPoints": 4,
+		"PVM_MG":                5,
+		"Mobility_EG":           4,
+		"PST_MG":                6*64 - 16,
+		"PassedPawn_EG":         48,
+	}
+
+	seen := map[*int]string{}
+	for _, table := range tuner_tables() {
+		p := find_param(table.name)
+		if p == nil || !p.eval {
+			t.Errorf("%s is tuned but isn't an evaluation parameter", table.name)
+		}
+		if count, ok := counts[table.name]; ok && len(table.values) != count {
+			t.Errorf("%s has %d tuned weights, expected %d", table.name, len(table.values), count)
+		}
+		delete(counts, table.name)
+
+		// Every tuned weight is counted once
+		for _, value := range table.values {
+			if name, ok := seen[value]; ok {
+				t.Errorf("a weight of %s is also tuned in %s", table.name, name)
+			}
+			seen[value] = table.name
+		}
+	}
+	for name := range counts {
+		t.Errorf("%s isn't tuned", name)
+	}
+}
+
+func TestWriteTunerTables(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tuned.go")
+	tables := tuner_tables()
+	if err := write_tuner_tables(path, tables); err != nil {
+		t.Fatal(err)
+	}
+
+	// The written tables must be Go source declaring every table
+	file, err := parser.ParseFile(token.NewFileSet(), path, nil, 0)
+	if err != nil {
+		t.Fatalf("the written tables don't parse: %s", err)
+	}
+	declared := map[string]bool{}
+	ast.Inspect(file, func(node ast.Node) bool {
+		if spec, ok := node.(*ast.ValueSpec); ok {
+			for _, name := range spec.Names {
+				declared[name.Name] = true
+			}
+		}
+		return true
+	})
+	for _, table := range tables {
+		if !declared[table.name] {
+			t.Errorf("%s isn't written", table.name)
+		}
+	}
+}
+
+func TestTune(t *testing.T) {
+	restoreParams(t)
+
+	lines := []string{
+		"rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq - 0 1 [0.5]",
+		"r1bqkbnr/pppp1ppp/2n5/4p3/4P3/5N2/PPPP1PPP/RNBQKB1R w KQkq - 2 3 [0.5]",
+		"8/8/4k3/8/8/4K3/3Q4/8 w - - 0 1 [1.0]",
+		"8/8/4k3/8/8/4K3/3q4/8 w - - 0 1 [0.0]",
+		"8/5k2/8/8/8/2K5/1PP5/8 b - - 0 1 [1.0]",
+		"r3k2r/8/8/8/8/8/8/4K3 w kq - 0 1 [0.0]",
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "positions.epd")
+	data := ""
+	for _, line := range lines {
+		data += line + "\n"
+	}
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := load_tuner_entries(path)
+	if err != nil || len(entries) != len(lines) {
+		t.Fatalf("loaded %d of %d positions: %v", len(entries), len(lines), err)
+	}
+	k := find_tuner_k(entries)
+	before := tuner_error(entries, k)
+
+	// A pass keeps the changes that lower the error, which some must do on
+	// so few positions, and the written parameters load back
+	output := filepath.Join(dir, "tuned.json")
+	if err := Tune(path, output, 1); err != nil {
+		t.Fatal(err)
+	}
+	after := tuner_error(entries, k)
+	if after >= before {
+		t.Errorf("tuning didn't lower the error of %f, got %f", before, after)
+	}
+	if err := LoadParams(output); err != nil {
+		t.Errorf("the tuned parameters don't load: %s", err)
+	}
+	if tuner_error(entries, k) != after {
+		t.Errorf("the loaded parameters don't give the tuned error")
+	}
+}
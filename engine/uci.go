@@ -23,6 +23,9 @@ type UCIEngine struct {
 	OptionUseBook       bool
 	OptionBookPath      string
 	OptionBookMoveDelay int
+	OptionPonder        bool
+
+	perftTT TransTable[PerftEntry, *PerftEntry]
 }
 
 func (e *UCIEngine) reset() {
@@ -36,9 +39,19 @@ func (e *UCIEngine) uci() {
 	fmt.Printf("id author %v\n", author)
 
 	fmt.Printf("\noption name Hash type spin default 64 min 1 max 32000\n")
+	fmt.Printf("option name Threads type spin default %d min 1 max %d\n", DefaultThreads, MaxThreads)
+	fmt.Printf("option name MultiPV type spin default %d min 1 max %d\n", DefaultMultiPV, MaxMultiPV)
+	fmt.Printf("option name PawnHash type spin default %d min 1 max 1024\n", DefaultPawnTTSize)
 	fmt.Print("option name Clear Hash type button\n")
 	fmt.Print("option name Clear History type button\n")
 	fmt.Print("option name Clear Killers type button\n")
+	fmt.Print("option name Ponder type check default false\n")
+	fmt.Print("option name UCI_Chess960 type check default false\n")
+	fmt.Print("option name SyzygyPath type string default <empty>\n")
+	fmt.Print("option name EvalFile type string default <empty>\n")
+	fmt.Print("option name UseNNUE type check default false\n")
+	fmt.Print("option name EvalParams type string default <empty>\n")
+	print_spin_params()
 	// fmt.Print("option name Clear Counters type button\n")
 
 	fmt.Print("option name UseBook type check default false\n")
@@ -56,9 +69,11 @@ func (e *UCIEngine) uci() {
 	fmt.Print("\n\t* wtime <MILLISECONDS>\n\t* btime <MILLISECONDS>")
 	fmt.Print("\n\t* winc <MILLISECONDS>\n\t* binc <MILLISECONDS>")
 	fmt.Print("\n\t* movestogo <INTEGER>\n\t* depth <INTEGER>\n\t* nodes <INTEGER>\n\t* movetime <MILLISECONDS>")
-	fmt.Print("\n\t* infinite")
+	fmt.Print("\n\t* infinite\n\t* ponder\n\t* mate <INTEGER>\n\t* searchmoves <MOVES>")
 
-	fmt.Print("\n    * stop\n    * quit\n\n")
+	fmt.Print("\n    * ponderhit\n    * stop\n    * quit")
+	fmt.Print("\n    * perft <DEPTH>\n    * divide <DEPTH>")
+	fmt.Print("\n    * tune <FILE> [<OUTPUT>]\n    * eval\n    * evalsym [<FILE>]\n\n")
 	fmt.Printf("uciok\n")
 }
 
@@ -89,12 +104,71 @@ func (e *UCIEngine) setOption(command string) {
 			e.engine.uninitializeTT()
 			e.engine.resizeTT(uint64(size), SearchEntrySize)
 		}
+	case "PawnHash":
+		size, err := strconv.Atoi(value)
+		if err == nil {
+			e.engine.resizePawnTT(uint64(Max(1, Min(size, 1024))))
+		}
+	case "Threads":
+		threads, err := strconv.Atoi(value)
+		if err == nil {
+			e.engine.setThreads(threads)
+		}
+	case "MultiPV":
+		lines, err := strconv.Atoi(value)
+		if err == nil {
+			e.engine.setMultiPV(lines)
+		}
 	case "Clear Hash":
 		e.engine.clearTT()
+		e.engine.clearPawnTT()
 	case "Clear History":
 		e.engine.resetZobrist()
+		e.engine.resetHistory()
 	case "Clear Killers":
 		e.engine.resetKillerMoves()
+	case "Ponder":
+		if value == "true" {
+			e.OptionPonder = true
+		} else if value == "false" {
+			e.OptionPonder = false
+		}
+	case "UCI_Chess960":
+		if value == "true" {
+			e.engine.notation.Chess960 = true
+		} else if value == "false" {
+			e.engine.notation.Chess960 = false
+		}
+	case "SyzygyPath":
+		fmt.Printf("info string Found %d tablebases\n", init_syzygy(value))
+	case "EvalFile":
+		if value == "" || value == "<empty>" {
+			nnue_network = nil
+			break
+		}
+		network, err := LoadNetwork(value)
+		if err == nil {
+			nnue_network = network
+			fmt.Printf("info string Loaded network %s with %d hidden neurons\n", value, network.hidden)
+		} else {
+			fmt.Printf("info string Failed to load network: %s\n", err)
+		}
+	case "UseNNUE":
+		if value == "true" {
+			UseNNUE = true
+		} else if value == "false" {
+			UseNNUE = false
+		}
+		if UseNNUE && nnue_network == nil {
+			fmt.Print("info string No network loaded, using the handcrafted evaluation\n")
+		}
+	case "EvalParams":
+		if err := LoadParams(value); err == nil {
+			e.engine.clearPawnTT()
+			fmt.Printf("info string Loaded parameters from %s\n", value)
+		} else {
+			fmt.Printf("info string Failed to load parameters: %s\n", err)
+		}
 	case "UseBook":
 		if value == "true" {
 			e.OptionUseBook = true
@@ -115,42 +189,67 @@ func (e *UCIEngine) setOption(command string) {
 		if err == nil {
 			e.OptionBookMoveDelay = size
 		}
+	default:
+		number, err := strconv.Atoi(value)
+		if err == nil && set_spin_param(option, number) {
+			e.engine.clearPawnTT()
+		}
 	}
 }
 
 func (e *UCIEngine) position(command string) {
-	// parse position and moves from args
-	// set position in engine
-	args := strings.TrimPrefix(command, "position ")
-	fen := ""
+	// The position is the starting position or a FEN, followed by the moves
+	// played from it. GUIs can leave out the move counters of the FEN.
+	fields := strings.Fields(strings.TrimPrefix(command, "position"))
+	moves := len(fields)
+	for i, field := range fields {
+		if field == "moves" {
+			moves = i
+			break
+		}
+	}
 
-	if strings.HasPrefix(args, "startpos") {
-		args = strings.TrimPrefix(args, "startpos ")
+	fen := ""
+	if len(fields) > 0 && fields[0] == "startpos" {
 		fen = "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1"
-	} else if strings.HasPrefix(args, "fen ") {
-		args = strings.TrimPrefix(args, "startpos ")
-		remaining_args := strings.Fields(args)
-		fen = strings.Join(remaining_args[0:6], " ")
-		args = strings.Join(remaining_args[6:], " ")
+	} else if len(fields) > 0 && fields[0] == "fen" {
+		fen_fields := fields[1:moves]
+		if len(fen_fields) < 4 || len(fen_fields) > 6 {
+			fmt.Printf("info string Invalid FEN: %s\n", strings.Join(fen_fields, " "))
+			return
+		}
+		fen = strings.Join(fen_fields, " ") + []string{" 0 1", " 1", ""}[len(fen_fields)-4]
+	} else {
+		fmt.Print("info string Expected startpos or fen after position\n")
+		return
 	}
 
-	e.game = game_from_fen(fen)
+	fen_option, err := chess.FEN(fen)
+	if err != nil {
+		fmt.Printf("info string Invalid FEN: %s\n", err)
+		return
+	}
+	game := chess.NewGame(fen_option, chess.UseNotation(chess.AlgebraicNotation{}))
+	board := game.Position().Board()
+	if board.BBForPiece(chess.WhiteKing).CountBits() != 1 || board.BBForPiece(chess.BlackKing).CountBits() != 1 {
+		fmt.Printf("info string Invalid FEN: %s needs one king for each side\n", fen)
+		return
+	}
+	e.game = game
 	e.engine.zobristHistoryPly = e.moves
-	e.engine.Add_Zobrist_History(Zobrist.GenHash(e.game.Position()))
-
-	if strings.HasPrefix(args, "moves ") {
-		args = strings.TrimSuffix(strings.TrimPrefix(args, "moves"), " ")
-		if args != "" {
-			for _, smove := range strings.Fields(args) {
-				move, err := chess.UCINotation{}.Decode(
-					e.game.Position(), smove,
-				)
-				if err != nil {
-					panic(err)
-				}
-				e.game.Move(move)
-				e.engine.Add_Zobrist_History(Zobrist.GenHash(e.game.Position()))
+	e.engine.Add_Zobrist_History(e.game.Position().Key())
+
+	if moves < len(fields) {
+		for _, smove := range fields[moves+1:] {
+			move, err := chess.UCINotation{}.Decode(e.game.Position(), smove)
+			if err == nil {
+				err = e.game.Move(move)
+			}
+			if err != nil {
+				fmt.Printf("info string Invalid move %s: %s\n", smove, err)
+				break
 			}
+			e.engine.Add_Zobrist_History(e.game.Position().Key())
 		}
 	}
 
@@ -158,7 +257,25 @@ func (e *UCIEngine) position(command string) {
 }
 
 func (e *UCIEngine) search(command string) {
-	if e.OptionUseBook {
+	command = strings.TrimPrefix(command, "go")
+	command = strings.TrimPrefix(command, " ")
+	fields := strings.Fields(command)
+
+	// While pondering the position after the expected reply is searched, and
+	// a book move can't be played until the reply is actually made. A book
+	// move also can't answer a restricted or mate search.
+	ponder := false
+	useBook := e.OptionUseBook
+	for _, field := range fields {
+		if field == "ponder" {
+			ponder = true
+			useBook = false
+		} else if field == "searchmoves" || field == "mate" {
+			useBook = false
+		}
+	}
+
+	if useBook {
 		if entries, ok := e.OpeningBook[GenPolyglotHash(e.game.Position())]; ok {
 			// To allow opening variety, randomly select a move from an entry matching
 			// the current position.
@@ -170,16 +287,12 @@ func (e *UCIEngine) search(command string) {
 
 			// if inter.Search.Pos.MoveIsPseduoLegal(move) {
 			time.Sleep(time.Duration(e.OptionBookMoveDelay) * time.Second)
-			fmt.Printf("bestmove %v\n", move)
+			fmt.Printf("bestmove %s\n", e.engine.notation.Encode(nil, move))
 			return
 			// }
 		}
 	}
 
-	command = strings.TrimPrefix(command, "go")
-	command = strings.TrimPrefix(command, " ")
-	fields := strings.Fields(command)
-
 	colorPrefix := "b"
 	if e.game.Position().Turn() == chess.White {
 		colorPrefix = "w"
@@ -192,9 +305,29 @@ func (e *UCIEngine) search(command string) {
 	maxDepth := uint64(MAX_DEPTH)
 	maxNodeCount := uint64(math.MaxUint64)
 	moveTime := uint64(NoValue)
+	mate := 0
+
+	var searchMoves []*chess.Move
+	parsingSearchMoves := false
 
 	for index, field := range fields {
-		if strings.HasPrefix(field, colorPrefix) {
+		// Every field after searchmoves is a move until the next argument
+		if parsingSearchMoves {
+			move, err := chess.UCINotation{}.Decode(e.game.Position(), field)
+			if err == nil {
+				for _, validMove := range e.game.Position().ValidMoves() {
+					if same_move(validMove, move) {
+						searchMoves = append(searchMoves, validMove)
+					}
+				}
+				continue
+			}
+			parsingSearchMoves = false
+		}
+
+		if field == "searchmoves" {
+			parsingSearchMoves = true
+		} else if strings.HasPrefix(field, colorPrefix) {
 			if strings.HasSuffix(field, "time") {
 				timeLeft, _ = strconv.Atoi(fields[index+1])
 			} else if strings.HasSuffix(field, "inc") {
@@ -208,9 +341,19 @@ func (e *UCIEngine) search(command string) {
 			maxNodeCount, _ = strconv.ParseUint(fields[index+1], 10, 64)
 		} else if field == "movetime" {
 			moveTime, _ = strconv.ParseUint(fields[index+1], 10, 64)
+		} else if field == "mate" {
+			mate, _ = strconv.Atoi(fields[index+1])
 		}
 	}
 
+	// A mate in N is found within 2N-1 plies, and the search has to be
+	// exhaustive to rule one out.
+	if mate > 0 {
+		maxDepth = uint64(Min(int(maxDepth), 2*mate-1))
+	}
+	e.engine.mate_search = mate
+	e.engine.root_moves = searchMoves
+
 	// Setup the timer with the go command time control information.
 	e.engine.timer.Setup(
 		int64(timeLeft),
@@ -221,9 +364,105 @@ func (e *UCIEngine) search(command string) {
 		maxNodeCount,
 	)
 
-	// Report the best move found by the engine to the GUI.
+	e.engine.timer.SetPondering(ponder)
+
 	_, bestMove := e.engine.run(e.game.Position())
-	fmt.Printf("bestmove %v\n", bestMove)
+
+	// The best move can't be sent while pondering, even if the search has
+	// already finished, so wait for a ponderhit or a stop.
+	e.engine.timer.WaitForPonderEnd()
+
+	// Report the best move found by the engine to the GUI.
+	if bestMove == nil {
+		fmt.Print("bestmove 0000\n")
+	} else if e.OptionPonder && e.engine.ponder_move != nil {
+		fmt.Printf("bestmove %s ponder %s\n",
+			e.engine.notation.Encode(nil, bestMove), e.engine.notation.Encode(nil, e.engine.ponder_move))
+	} else {
+		fmt.Printf("bestmove %s\n", e.engine.notation.Encode(nil, bestMove))
+	}
+}
+
+// Parse the depth of a perft or divide command, and get the position to run
+// it on, which is the starting position if no position was given.
+func (e *UCIEngine) perftArgs(command string) (*chess.Position, uint8, bool) {
+	fields := strings.Fields(command)
+	if len(fields) < 2 {
+		return nil, 0, false
+	}
+	depth, err := strconv.Atoi(fields[1])
+	if err != nil || depth < 1 || depth > math.MaxUint8 {
+		return nil, 0, false
+	}
+
+	if e.perftTT.size == 0 {
+		e.perftTT.Resize(DefaultPerftTTSize, PerftEntrySize)
+	}
+
+	if e.game == nil {
+		return chess.StartingPosition(), uint8(depth), true
+	}
+	return e.game.Position(), uint8(depth), true
+}
+
+func (e *UCIEngine) perft(command string) {
+	position, depth, ok := e.perftArgs(command)
+	if !ok {
+		return
+	}
+	start := time.Now()
+	nodes := perft(position.Copy(), depth, &e.perftTT)
+	print_perft_result(nodes, time.Since(start))
+}
+
+func (e *UCIEngine) divide(command string) {
+	position, depth, ok := e.perftArgs(command)
+	if !ok {
+		return
+	}
+	divide(position, depth, &e.perftTT)
+}
+
+// Tune the evaluation on a file of labelled positions. The tuned weights
+// are used by the engine from then on, and written to the output file.
+func (e *UCIEngine) tune(command string) {
+	fields := strings.Fields(command)
+	if len(fields) < 2 {
+		return
+	}
+	output := DefaultTunerOutput
+	if len(fields) >= 3 {
+		output = fields[2]
+	}
+	if err := Tune(fields[1], output, DefaultTunerMaxPass); err != nil {
+		fmt.Printf("info string Failed to tune: %s\n", err)
+	}
+
+	// The tuned weights stay in use, so pawn scores cached with the old ones
+	// are stale
+	e.engine.clearPawnTT()
+}
+
+// Print the terms of the evaluation of the current position.
+func (e *UCIEngine) eval() {
+	position := chess.StartingPosition()
+	if e.game != nil {
+		position = e.game.Position()
+	}
+	print_eval_trace(TraceEval(position))
+}
+
+// Check the evaluation against the color-flipped mirrors of the positions in
+// an EPD file, or of random positions if no file is given.
+func (e *UCIEngine) evalSymmetry(command string) {
+	fields := strings.Fields(command)
+	path := ""
+	if len(fields) >= 2 {
+		path = fields[1]
+	}
+	if _, err := CheckEvalSymmetry(path); err != nil {
+		fmt.Printf("info string Failed to check eval symmetry: %s\n", err)
+	}
 }
 
 func (e *UCIEngine) quit() {
@@ -237,6 +476,7 @@ func (e *UCIEngine) loop() {
 	e.reset()
 
 	e.engine.resizeTT(DefaultTTSize, SearchEntrySize)
+	e.engine.resizePawnTT(DefaultPawnTTSize)
 
 	for {
 		command, err := reader.ReadString('\n')
@@ -258,8 +498,20 @@ func (e *UCIEngine) loop() {
 			e.position(command)
 		} else if strings.HasPrefix(command, "go") {
 			go e.search(command)
+		} else if strings.HasPrefix(command, "ponderhit") {
+			e.engine.timer.PonderHit()
 		} else if strings.HasPrefix(command, "stop") {
 			e.engine.timer.ForceStop()
+		} else if strings.HasPrefix(command, "perft") {
+			e.perft(command)
+		} else if strings.HasPrefix(command, "divide") {
+			e.divide(command)
+		} else if command == "eval\n" {
+			e.eval()
+		} else if strings.HasPrefix(command, "evalsym") {
+			e.evalSymmetry(command)
+		} else if strings.HasPrefix(command, "tune") {
+			e.tune(command)
 		} else if command == "quit\n" {
 			e.quit()
 			break
@@ -0,0 +1,53 @@
+package engine
+
+import "testing"
+
+func TestPositionCommand(t *testing.T) {
+	// The position set by the command, or "" if it must be rejected. Moves
+	// stop being played at the first illegal one.
+	tests := []struct {
+		command string
+		fen     string
+	}{
+		{"position startpos\n", "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1"},
+		{"position startpos moves e2e4 e7e5\n", "rnbqkbnr/pppp1ppp/8/4p3/4P3/8/PPPP1PPP/RNBQKBNR w KQkq e6 0 2"},
+		{"position fen 8/8/8/8/8/8/8/K1k5 w - - 3 40\n", "8/8/8/8/8/8/8/K1k5 w - - 3 40"},
+		{"position fen 8/8/8/8/8/8/8/K1k5 w - - 3\n", "8/8/8/8/8/8/8/K1k5 w - - 3 1"},
+		{"position fen 8/8/8/8/8/8/8/K1k5 w - -\n", "8/8/8/8/8/8/8/K1k5 w - - 0 1"},
+		{"position fen 8/8/8/8/8/8/8/K1k5 w - - moves a1a2\n", "8/8/8/8/8/8/K7/2k5 b - - 1 1"},
+		{
+			"position fen r1bqkbnr/pppp1ppp/2n5/4p3/2B1P3/5N2/PPPP1PPP/RNBQK2R w KQkq - moves g8f6 e1g1\n",
+			"r1bqkbnr/pppp1ppp/2n5/4p3/2B1P3/5N2/PPPP1PPP/RNBQK2R w KQkq - 0 1",
+		},
+		{
+			"position fen r1bqkbnr/pppp1ppp/2n5/4p3/2B1P3/5N2/PPPP1PPP/RNBQK2R w KQkq - moves e1g1\n",
+			"r1bqkbnr/pppp1ppp/2n5/4p3/2B1P3/5N2/PPPP1PPP/RNBQ1RK1 b kq - 1 1",
+		},
+		{"position startpos moves e2e4 zz e7e5\n", "rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq e3 0 1"},
+		{"position fen 8/8/8/8/8/8/8/K1k5 w -\n", ""},
+		{"position fen 8/8/8 w - -\n", ""},
+		{"position fen 8/8/8/8/8/8/8/K1k5 x - -\n", ""},
+		{"position fen 8/8/8/8/8/8/8/K1k5 w - - a b\n", ""},
+		{"position fen 8/8/8/8/8/8/8/K1k5 w - - 0 1 2\n", ""},
+		{"position fen 8/8/8/8/8/8/8/8 w - -\n", ""},
+		{"position fen\n", ""},
+		{"position fen moves e2e4\n", ""},
+		{"position\n", ""},
+	}
+
+	for _, test := range tests {
+		e := &UCIEngine{}
+		e.reset()
+		e.position(test.command)
+
+		if test.fen == "" {
+			if e.game != nil {
+				t.Errorf("%q: got %s, expected the command to be rejected", test.command, e.game.Position())
+			}
+		} else if e.game == nil {
+			t.Errorf("%q: rejected, expected %s", test.command, test.fen)
+		} else if fen := e.game.Position().String(); fen != test.fen {
+			t.Errorf("%q: got %s, expected %s", test.command, fen, test.fen)
+		}
+	}
+}
@@ -12,9 +12,13 @@ func main() {
 	// defer print("Finished main.")
 
 	chess.InitBitboards()
+	chess.InitZobrist()
 	engine.InitTables()
+	engine.InitSearchTables()
 	engine.InitEvalBitboards()
-	engine.InitZobrist()
+	engine.InitEndgames()
+	engine.InitParams()
+	engine.InitSyzygyTables()
 
 	runtime.GOMAXPROCS(runtime.NumCPU())
 